@@ -1,10 +1,8 @@
 package main
 
 import (
-	"log"
-
 	"github.com/antigravity/dbbackup/internal/backup"
-	"github.com/antigravity/dbbackup/internal/notifier"
+	"github.com/antigravity/dbbackup/internal/metrics"
 	"github.com/spf13/cobra"
 )
 
@@ -15,14 +13,20 @@ var backupCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		db, st, err := getComponents(appConfig)
 		if err != nil {
-			log.Fatalf("Error initializing components: %v", err)
+			fatalf("Error initializing components: %v", err)
 		}
 		defer db.Close()
 
-		notif := notifier.NewSlackNotifier(appConfig.Notify)
+		rec := metrics.NewRecorder()
+		notif, err := getNotifier(appConfig, rec)
+		if err != nil {
+			fatalf("Error initializing notifiers: %v", err)
+		}
 		mgr := backup.NewManager(db, st, appConfig.Backup, notif)
-		if err := mgr.PerformBackup(); err != nil {
-			log.Fatalf("Backup failed: %v", err)
+		err = mgr.PerformBackup()
+		exportMetrics(appConfig, rec, "backup")
+		if err != nil {
+			fatalf("Backup failed: %v", err)
 		}
 	},
 }
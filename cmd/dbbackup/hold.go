@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/antigravity/dbbackup/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var holdCmd = &cobra.Command{
+	Use:   "hold [backup_file]",
+	Short: "Place a legal hold on a backup",
+	Long:  `Places a legal hold on a backup in the storage so that it cannot be deleted or overwritten, regardless of retention.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		backupFile := args[0]
+
+		st, err := getStorage(appConfig)
+		if err != nil {
+			fatalf("Error initializing storage: %v", err)
+		}
+
+		locker, ok := st.(storage.Locker)
+		if !ok {
+			fatalf("Storage type %s does not support legal holds", appConfig.Storage.Type)
+		}
+		if err := locker.Hold(backupFile); err != nil {
+			fatalf("Hold failed: %v", err)
+		}
+		logger.Info("Legal hold placed", "file", backupFile)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(holdCmd)
+}
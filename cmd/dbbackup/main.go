@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/antigravity/dbbackup/internal/config"
 	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/antigravity/dbbackup/internal/secrets"
+	"github.com/antigravity/dbbackup/internal/tracing"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -13,10 +17,16 @@ import (
 var cfgFile string
 var appConfig config.Config
 
+// shutdownTracing flushes pending spans; it is replaced once tracing is initialized
+var shutdownTracing = func(context.Context) error { return nil }
+
 var rootCmd = &cobra.Command{
 	Use:   "dbbackup",
 	Short: "A CLI tool for database backups",
 	Long:  `A comprehensive CLI tool for backing up and restoring databases (MySQL, PostgreSQL, MongoDB) to local or cloud storage.`,
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		flushTracing()
+	},
 }
 
 func Execute() {
@@ -56,10 +66,42 @@ func initConfig() {
 			fmt.Printf("Unable to decode into struct, %v", err)
 			os.Exit(1)
 		}
-		
-		// Initialize logger
-		logger.Init(appConfig.Log.Level)
+		if err := secrets.ResolveConfig(&appConfig); err != nil {
+			fmt.Printf("Unable to resolve secrets, %v", err)
+			os.Exit(1)
+		}
+	}
+
+	// Initialize logger, with defaults if there is no config file
+	if err := logger.Init(appConfig.Log); err != nil {
+		fmt.Printf("Unable to initialize logger, %v", err)
+		os.Exit(1)
+	}
+	registerSecrets(appConfig)
+
+	shutdown, err := tracing.Init(appConfig.Tracing)
+	if err != nil {
+		fmt.Printf("Unable to initialize tracing, %v", err)
+		os.Exit(1)
 	}
+	shutdownTracing = shutdown
+}
+
+// flushTracing exports any spans that are still buffered
+func flushTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("Failed to flush traces", "error", err)
+	}
+}
+
+// fatalf logs an error and exits. Traces are flushed first, so the spans of a
+// failed run aren't lost.
+func fatalf(format string, v ...any) {
+	logger.Error(fmt.Sprintf(format, v...))
+	flushTracing()
+	os.Exit(1)
 }
 
 func main() {
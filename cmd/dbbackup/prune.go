@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/antigravity/dbbackup/internal/backup"
+	"github.com/antigravity/dbbackup/internal/database"
+	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/antigravity/dbbackup/internal/metrics"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete backups older than the retention period",
+	Long:  `Deletes backups older than backup.retention_days from the storage. Backups under retention lock or legal hold are kept. Elasticsearch snapshots are deleted from their repository along with their manifests.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// The database is only needed for providers that keep backups outside
+		// the artifacts, such as Elasticsearch snapshots
+		st, err := getStorage(appConfig)
+		if err != nil {
+			fatalf("Error initializing storage: %v", err)
+		}
+		var db database.Database
+		if appConfig.Database.Type != "" {
+			if db, err = getDatabase(appConfig); err != nil {
+				fatalf("Error initializing database: %v", err)
+			}
+			defer db.Close()
+		}
+
+		rec := metrics.NewRecorder()
+		notif, err := getNotifier(appConfig, rec)
+		if err != nil {
+			fatalf("Error initializing notifiers: %v", err)
+		}
+
+		mgr := backup.NewManager(db, st, appConfig.Backup, notif)
+		deleted, err := mgr.Prune()
+		exportMetrics(appConfig, rec, "prune")
+		if err != nil {
+			fatalf("Prune failed: %v", err)
+		}
+		logger.Info("Prune finished", "deleted", len(deleted))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+}
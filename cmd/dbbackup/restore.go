@@ -1,8 +1,10 @@
 package main
 
 import (
-	"log"
+	"time"
 
+	"github.com/antigravity/dbbackup/internal/database"
+	"github.com/antigravity/dbbackup/internal/metrics"
 	"github.com/antigravity/dbbackup/internal/restore"
 	"github.com/spf13/cobra"
 )
@@ -10,24 +12,86 @@ import (
 var restoreCmd = &cobra.Command{
 	Use:   "restore [backup_file]",
 	Short: "Restore a database from a backup",
-	Long:  `Restores the database from a specified backup file in the storage.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Restores the database from a specified backup file in the storage.
+
+With --d1-bookmark or --to-time a D1 database is rolled back in place with
+Time Travel instead. A --to-time beyond the Time Travel history restores the
+newest backup taken before it. For CockroachDB, --to-time restores the
+database as of that time from the latest backup chain.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		backupFile := args[0]
+		backupFile := ""
+		if len(args) == 1 {
+			backupFile = args[0]
+		}
+
+		pointInTime := restoreBookmark != "" || restoreToTime != ""
+		var toTime time.Time
+		switch {
+		case restoreBookmark != "" && restoreToTime != "":
+			fatalf("Use either --d1-bookmark or --to-time")
+		case pointInTime && backupFile != "":
+			fatalf("A backup file can't be combined with --d1-bookmark or --to-time")
+		case !pointInTime && backupFile == "":
+			fatalf("Specify the backup file to restore, or --d1-bookmark or --to-time")
+		case restoreToTime != "":
+			var err error
+			if toTime, err = time.Parse(time.RFC3339, restoreToTime); err != nil {
+				fatalf("Invalid --to-time %q, use RFC 3339 like 2026-01-02T15:04:05Z: %v", restoreToTime, err)
+			}
+		}
+
+		// A server-wide config restores into one database: the one given with
+		// --dbname, or the one the backup was taken from
+		if restoreDBName != "" {
+			appConfig.Database.DBName = restoreDBName
+		} else if appConfig.Database.DBName == database.AllDatabases || len(appConfig.Database.Include) > 0 {
+			if pointInTime {
+				fatalf("Name the database to roll back with --dbname")
+			}
+			name, ok := database.DatabaseFromArtifact(backupFile)
+			if !ok {
+				fatalf("Can't tell which database %s belongs to, use --dbname", backupFile)
+			}
+			appConfig.Database.DBName = name
+		}
+		appConfig.Database.Include = nil
+		appConfig.Database.Exclude = nil
 
 		db, st, err := getComponents(appConfig)
 		if err != nil {
-			log.Fatalf("Error initializing components: %v", err)
+			fatalf("Error initializing components: %v", err)
 		}
 		defer db.Close()
 
-		mgr := restore.NewManager(db, st)
-		if err := mgr.PerformRestore(backupFile); err != nil {
-			log.Fatalf("Restore failed: %v", err)
+		rec := metrics.NewRecorder()
+		notif, err := getNotifier(appConfig, rec)
+		if err != nil {
+			fatalf("Error initializing notifiers: %v", err)
+		}
+
+		mgr := restore.NewManager(db, st, notif)
+		if pointInTime {
+			err = mgr.PerformRestoreToPoint(restoreBookmark, toTime)
+		} else {
+			err = mgr.PerformRestore(backupFile)
+		}
+		exportMetrics(appConfig, rec, "restore")
+		if err != nil {
+			fatalf("Restore failed: %v", err)
 		}
 	},
 }
 
+var (
+	restoreDBName   string
+	restoreBookmark string
+	restoreToTime   string
+)
+
 func init() {
+	restoreCmd.Flags().StringVar(&restoreDBName, "dbname", "", "database to restore into, overrides database.dbname")
+	restoreCmd.Flags().StringVar(&restoreBookmark, "d1-bookmark", "", "roll a D1 database back in place to this Time Travel bookmark")
+	restoreCmd.Flags().StringVar(&restoreToTime, "to-time", "", "roll a D1 database back to this time, or restore CockroachDB as of it (RFC 3339)")
 	rootCmd.AddCommand(restoreCmd)
 }
@@ -5,29 +5,53 @@ import (
 
 	"github.com/antigravity/dbbackup/internal/config"
 	"github.com/antigravity/dbbackup/internal/database"
+	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/antigravity/dbbackup/internal/metrics"
+	"github.com/antigravity/dbbackup/internal/notifier"
 	"github.com/antigravity/dbbackup/internal/storage"
 )
 
 func getComponents(cfg config.Config) (database.Database, storage.Storage, error) {
-	var db database.Database
-	var st storage.Storage
-	var err error
+	db, err := getDatabase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := getStorage(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, st, nil
+}
 
-	// Initialize Database
+func getDatabase(cfg config.Config) (database.Database, error) {
 	switch cfg.Database.Type {
 	case "mysql":
-		db = database.NewMySQL(cfg.Database)
+		return database.NewMySQL(cfg.Database), nil
 	case "postgres":
-		db = database.NewPostgres(cfg.Database)
+		return database.NewPostgres(cfg.Database), nil
 	case "mongodb":
-		db = database.NewMongoDB(cfg.Database)
+		return database.NewMongoDB(cfg.Database), nil
 	case "d1":
-		db = database.NewD1(cfg.Database)
+		return database.NewD1(cfg.Database), nil
+	case "cockroachdb":
+		return database.NewCockroachDB(cfg.Database, cfg.Storage), nil
+	case "clickhouse":
+		return database.NewClickHouse(cfg.Database, cfg.Storage), nil
+	case "mssql":
+		return database.NewMSSQL(cfg.Database), nil
+	case "elasticsearch":
+		return database.NewElasticsearch(cfg.Database, cfg.Storage), nil
 	default:
-		return nil, nil, fmt.Errorf("unsupported database type: %s", cfg.Database.Type)
+		return nil, fmt.Errorf("unsupported database type: %s", cfg.Database.Type)
 	}
+}
+
+func getStorage(cfg config.Config) (storage.Storage, error) {
+	var st storage.Storage
+	var err error
 
-	// Initialize Storage
 	switch cfg.Storage.Type {
 	case "local":
 		st = storage.NewLocalStorage(cfg.Storage)
@@ -37,13 +61,84 @@ func getComponents(cfg config.Config) (database.Database, storage.Storage, error
 		st, err = storage.NewGCSStorage(cfg.Storage)
 	case "azure":
 		st, err = storage.NewAzureStorage(cfg.Storage)
+	case "sftp":
+		st, err = storage.NewSFTPStorage(cfg.Storage)
+	case "webdav":
+		st, err = storage.NewWebDAVStorage(cfg.Storage)
+	case "http":
+		st, err = storage.NewHTTPStorage(cfg.Storage)
 	default:
-		return nil, nil, fmt.Errorf("unsupported storage type: %s", cfg.Storage.Type)
+		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Storage.Type)
 	}
 
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to initialize storage: %v", err)
+		return nil, fmt.Errorf("failed to initialize storage: %v", err)
 	}
 
-	return db, st, nil
+	return st, nil
+}
+
+// jobName is the configured job name, or <database type>/<dbname>
+func jobName(cfg config.Config) string {
+	if cfg.Backup.Job != "" {
+		return cfg.Backup.Job
+	}
+	return fmt.Sprintf("%s/%s", cfg.Database.Type, cfg.Database.DBName)
+}
+
+// getNotifier builds the configured notification channels, plus any extra
+// receivers such as the metrics recorder. Every event is labelled with the job,
+// database and storage from the config.
+func getNotifier(cfg config.Config, extra ...notifier.Notifier) (notifier.Notifier, error) {
+	channels, err := notifier.New(cfg.Notify)
+	if err != nil {
+		return nil, err
+	}
+	notif := append(notifier.Multi{channels}, extra...)
+
+	return notifier.WithDefaults(notif, notifier.Event{
+		Job:      jobName(cfg),
+		Database: fmt.Sprintf("%s/%s", cfg.Database.Type, cfg.Database.DBName),
+		Storage:  fmt.Sprintf("%s:%s", cfg.Storage.Type, cfg.Storage.Path),
+	}), nil
+}
+
+// exportMetrics pushes the metrics of a one-shot run to the Pushgateway and/or
+// writes them to the node_exporter textfile, as configured.
+func exportMetrics(cfg config.Config, rec *metrics.Recorder, operation string) {
+	if cfg.Metrics.PushgatewayURL != "" {
+		if err := rec.Push(cfg.Metrics.PushgatewayURL, jobName(cfg), operation); err != nil {
+			logger.Warn("Failed to push metrics", "error", err)
+		}
+	}
+	if cfg.Metrics.TextfilePath != "" {
+		if err := rec.WriteTextfile(metrics.TextfilePath(cfg.Metrics.TextfilePath, operation)); err != nil {
+			logger.Warn("Failed to write metrics textfile", "error", err)
+		}
+	}
+}
+
+// registerSecrets makes sure that the credentials in the config are redacted
+// wherever they show up in logs, e.g. in the output of a failed dump tool.
+func registerSecrets(cfg config.Config) {
+	logger.AddSecret(
+		cfg.Database.Password,
+		cfg.Database.D1.APIToken,
+		cfg.Database.Elasticsearch.APIKey,
+		cfg.Storage.S3.SecretAccessKey,
+		cfg.Storage.S3.SessionToken,
+		cfg.Storage.S3.SSECustomerKey,
+		cfg.Storage.SFTP.Password,
+		cfg.Storage.SFTP.PrivateKeyPassphrase,
+		cfg.Storage.WebDAV.Password,
+		cfg.Storage.WebDAV.BearerToken,
+		cfg.Notify.SlackWebhookURL,
+		cfg.Secrets.Vault.Token,
+	)
+	for _, ch := range cfg.Notify.Channels {
+		logger.AddSecret(ch.URL, ch.Secret, ch.RoutingKey, ch.APIKey, ch.Email.Password)
+	}
+	for _, v := range cfg.Tracing.Headers {
+		logger.AddSecret(v)
+	}
 }
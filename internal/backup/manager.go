@@ -3,13 +3,14 @@ package backup
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/antigravity/dbbackup/internal/config"
 	"github.com/antigravity/dbbackup/internal/database"
-	"github.com/antigravity/dbbackup/internal/logger"
 	"github.com/antigravity/dbbackup/internal/notifier"
 	"github.com/antigravity/dbbackup/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Manager struct {
@@ -28,62 +29,271 @@ func NewManager(db database.Database, st storage.Storage, cfg config.BackupConfi
 	}
 }
 
+// notify sends an event to the notifier, if any. Notification errors are logged
+// and never fail the operation.
+func (m *Manager) notify(run *Run, event notifier.Event) {
+	if m.Notifier == nil {
+		return
+	}
+	event.RunID = run.ID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	_, end := run.Phase("notify", attribute.String("notify.status", string(event.Status)))
+	err := m.Notifier.Notify(event)
+	end(err)
+	if err != nil {
+		run.Log.Error("Notification failed", "error", err)
+	}
+}
+
+// fail reports a failed backup phase and returns err wrapped with description
+func (m *Manager) fail(run *Run, phase, description string, err error) error {
+	m.notify(run, notifier.Event{
+		Operation: "backup",
+		Phase:     phase,
+		Status:    notifier.StatusFailure,
+		Error:     err.Error(),
+		Duration:  time.Since(run.Start),
+		Phases:    run.Phases,
+	})
+	err = fmt.Errorf("%s: %v", description, err)
+	run.Log.Error("Backup failed", "phase", phase, "error", err)
+	run.End(err)
+	return err
+}
+
+// target is one artifact to produce: a database, or the server-wide globals
+type target struct {
+	name string
+	dump func() (string, *database.Metadata, error)
+}
+
+// phaseDescriptions prefix the error of a failed phase
+var phaseDescriptions = map[string]string{
+	"connect":  "database connection failed",
+	"discover": "database discovery failed",
+	"dump":     "database backup failed",
+	"compress": "compression failed",
+	"upload":   "upload to storage failed",
+}
+
 func (m *Manager) PerformBackup() error {
-	startTime := time.Now()
-	logger.Info.Println("Starting backup...")
+	run := StartRun("backup",
+		attribute.String("db.provider", ProviderName(m.DB)),
+		attribute.String("storage.provider", ProviderName(m.Storage)),
+		attribute.String("backup.type", m.Config.Type),
+		attribute.Bool("backup.compression", m.Config.Compression),
+	)
+	run.Log.Info("Starting backup", "type", m.Config.Type)
+	m.notify(run, notifier.Event{Operation: "backup", Status: notifier.StatusStarted})
 
 	// 1. Test DB Connection
-	if err := m.DB.TestConnection(); err != nil {
-		errMsg := fmt.Sprintf("Backup failed: database connection failed: %v", err)
-		if m.Notifier != nil {
-			m.Notifier.Notify(errMsg)
+	_, end := run.Phase("connect")
+	err := m.DB.TestConnection()
+	end(err)
+	if err != nil {
+		return m.fail(run, "connect", phaseDescriptions["connect"], err)
+	}
+
+	// 2. Work out what to back up: the configured database, or every
+	// discovered one plus the server globals
+	targets, closeTargets, err := m.targets(run)
+	if err != nil {
+		return m.fail(run, "discover", phaseDescriptions["discover"], err)
+	}
+	defer closeTargets()
+
+	// 3. Dump, compress and upload each target. With several databases a
+	// failure doesn't stop the others.
+	var artifacts, failed []string
+	var size int64
+	firstPhase := ""
+	for _, t := range targets {
+		artifact, artifactSize, phase, err := m.backupTarget(run, t)
+		if err != nil {
+			if len(targets) == 1 {
+				return m.fail(run, phase, phaseDescriptions[phase], err)
+			}
+			run.Log.Error("Database backup failed", "database", t.name, "phase", phase, "error", err)
+			failed = append(failed, fmt.Sprintf("%s (%s: %v)", t.name, phase, err))
+			if firstPhase == "" {
+				firstPhase = phase
+			}
+			continue
 		}
-		return fmt.Errorf("database connection failed: %v", err)
+		artifacts = append(artifacts, artifact)
+		size += artifactSize
+	}
+
+	if len(failed) > 0 {
+		err := fmt.Errorf("%d of %d backups failed: %s", len(failed), len(targets), strings.Join(failed, "; "))
+		m.notify(run, notifier.Event{
+			Operation: "backup",
+			Phase:     firstPhase,
+			Status:    notifier.StatusFailure,
+			Error:     err.Error(),
+			Duration:  time.Since(run.Start),
+			Artifact:  strings.Join(artifacts, ", "),
+			Size:      size,
+			Phases:    run.Phases,
+		})
+		run.Log.Error("Backup failed", "phase", firstPhase, "error", err)
+		run.End(err)
+		return err
+	}
+
+	duration := time.Since(run.Start)
+	artifact := strings.Join(artifacts, ", ")
+	run.Log.Info("Backup completed successfully", "duration", duration, "file", artifact, "size", size)
+	m.notify(run, notifier.Event{
+		Operation: "backup",
+		Status:    notifier.StatusSuccess,
+		Duration:  duration,
+		Artifact:  artifact,
+		Size:      size,
+		Phases:    run.Phases,
+	})
+	run.SetAttributes(attribute.String("backup.artifact", artifact), attribute.Int64("backup.size", size))
+	run.End(nil)
+	return nil
+}
+
+// targets returns the configured database, or with discovery every selected
+// database on the server and the globals. The returned function closes the
+// per-database providers.
+func (m *Manager) targets(run *Run) ([]target, func(), error) {
+	single := []target{{dump: m.dumper(m.DB)}}
+	noop := func() {}
+
+	discoverer, ok := m.DB.(database.Discoverer)
+	if !ok {
+		return single, noop, nil
 	}
 
-	// 2. Perform DB Backup
-	backupFile, err := m.DB.Backup(m.Config.Type)
+	_, end := run.Phase("discover")
+	names, err := discoverer.ListDatabases()
+	end(err)
 	if err != nil {
-		errMsg := fmt.Sprintf("Backup failed: database backup failed: %v", err)
-		if m.Notifier != nil {
-			m.Notifier.Notify(errMsg)
+		return nil, nil, err
+	}
+	if names == nil {
+		return single, noop, nil
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no databases match the include/exclude patterns")
+	}
+	run.Log.Info("Discovered databases", "databases", names)
+	run.SetAttributes(attribute.StringSlice("db.names", names))
+
+	var targets []target
+	var dbs []database.Database
+	if globals, ok := m.DB.(database.GlobalsBackuper); ok {
+		targets = append(targets, target{name: "globals", dump: func() (string, *database.Metadata, error) {
+			file, err := globals.BackupGlobals()
+			return file, nil, err
+		}})
+	}
+	for _, name := range names {
+		db := discoverer.ForDatabase(name)
+		dbs = append(dbs, db)
+		targets = append(targets, target{name: name, dump: m.dumper(db)})
+	}
+
+	return targets, func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}, nil
+}
+
+// dumper returns the dump function for a provider. Providers that record
+// metadata get the metadata of the earlier backups, which incremental
+// backups are based on.
+func (m *Manager) dumper(db database.Database) func() (string, *database.Metadata, error) {
+	backuper, ok := db.(database.MetadataBackuper)
+	if !ok {
+		return func() (string, *database.Metadata, error) {
+			file, err := db.Backup(m.Config.Type)
+			return file, nil, err
 		}
-		return fmt.Errorf("database backup failed: %v", err)
+	}
+	return func() (string, *database.Metadata, error) {
+		// A full backup doesn't build on anything
+		var previous []*database.Metadata
+		if m.Config.Type != "full" {
+			var err error
+			if previous, err = ListMetadata(m.Storage); err != nil {
+				return "", nil, fmt.Errorf("failed to read previous backup metadata: %v", err)
+			}
+		}
+		return backuper.BackupWithMetadata(m.Config.Type, previous)
+	}
+}
+
+// backupTarget dumps, compresses and uploads one target. On failure it returns
+// the phase that failed.
+func (m *Manager) backupTarget(run *Run, t target) (string, int64, string, error) {
+	var dbAttrs []attribute.KeyValue
+	if t.name != "" {
+		dbAttrs = append(dbAttrs, attribute.String("db.name", t.name))
+	}
+
+	span, end := run.Phase("dump", dbAttrs...)
+	backupFile, meta, err := t.dump()
+	if err == nil {
+		span.SetAttributes(attribute.String("backup.file", backupFile), attribute.Int64("backup.dump_size", fileSize(backupFile)))
+	}
+	end(err)
+	if err != nil {
+		return "", 0, "dump", err
 	}
 	defer os.Remove(backupFile) // Clean up local file after upload
 
-	logger.Info.Printf("Database backup created: %s", backupFile)
+	run.Log.Info("Database backup created", "file", backupFile)
 
-	// 3. Compress if enabled
+	// Compress if enabled and the provider didn't already
 	finalFile := backupFile
-	if m.Config.Compression {
+	if m.Config.Compression && !strings.HasSuffix(backupFile, ".gz") {
+		span, end := run.Phase("compress", dbAttrs...)
 		compressedFile, err := CompressFile(backupFile)
+		if err == nil {
+			span.SetAttributes(attribute.Int64("backup.compressed_size", fileSize(compressedFile)))
+		}
+		end(err)
 		if err != nil {
-			return fmt.Errorf("compression failed: %v", err)
+			return "", 0, "compress", err
 		}
 		// Remove original uncompressed file
 		os.Remove(backupFile)
 		finalFile = compressedFile
 		defer os.Remove(finalFile) // Clean up compressed file too
-		logger.Info.Printf("Backup compressed: %s", finalFile)
+		run.Log.Info("Backup compressed", "file", finalFile)
 	}
 
-	// 4. Upload to Storage
+	size := fileSize(finalFile)
+
+	// Upload to Storage
 	// Use the filename as the destination path
+	_, end = run.Phase("upload", append(dbAttrs, attribute.String("backup.artifact", finalFile), attribute.Int64("backup.size", size))...)
 	err = m.Storage.Upload(finalFile, finalFile)
+	if err == nil && meta != nil {
+		meta.Artifact = finalFile
+		err = uploadMetadata(m.Storage, meta)
+	}
+	end(err)
 	if err != nil {
-		errMsg := fmt.Sprintf("Backup failed: upload to storage failed: %v", err)
-		if m.Notifier != nil {
-			m.Notifier.Notify(errMsg)
-		}
-		return fmt.Errorf("upload to storage failed: %v", err)
+		return "", 0, "upload", err
 	}
+	return finalFile, size, "", nil
+}
 
-	duration := time.Since(startTime)
-	msg := fmt.Sprintf("Backup completed successfully in %s. File: %s", duration, finalFile)
-	logger.Info.Println(msg)
-	if m.Notifier != nil {
-		m.Notifier.Notify(msg)
+// fileSize returns the size of a local file, or 0 if it can't be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
 	}
-	return nil
+	return info.Size()
 }
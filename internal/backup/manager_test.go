@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/database"
+)
+
+// fakeDB is a provider that discovers a fixed list of databases
+type fakeDB struct {
+	databases []string
+}
+
+func (f *fakeDB) Connect() error                       { return nil }
+func (f *fakeDB) TestConnection() error                { return nil }
+func (f *fakeDB) Backup(string) (string, error)        { return "", nil }
+func (f *fakeDB) Restore(string) error                 { return nil }
+func (f *fakeDB) Close() error                         { return nil }
+func (f *fakeDB) ListDatabases() ([]string, error)     { return f.databases, nil }
+func (f *fakeDB) ForDatabase(string) database.Database { return &fakeDB{} }
+
+func TestTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		databases []string
+		want      int
+		wantErr   string
+	}{
+		{"discovery off", nil, 1, ""},
+		{"nothing selected", []string{}, 0, "no databases match the include/exclude patterns"},
+		{"one target per database", []string{"app", "billing"}, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(&fakeDB{databases: tt.databases}, nil, config.BackupConfig{}, nil)
+			targets, closeAll, err := m.targets(StartRun("backup"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("targets error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeAll()
+			if len(targets) != tt.want {
+				t.Fatalf("%d targets, want %d", len(targets), tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupTargetCompression(t *testing.T) {
+	tests := []struct {
+		dump string
+		want string
+	}{
+		{"backup_mysql_app_20260102_150405.sql", "backup_mysql_app_20260102_150405.sql.gz"},
+		{"backup_mysql-physical_20260102_150405.xbstream.gz", "backup_mysql-physical_20260102_150405.xbstream.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dump, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			st := localStore(t, nil, nil)
+			m := NewManager(&fakeDB{}, st, config.BackupConfig{Compression: true}, nil)
+			dump := func() (string, *database.Metadata, error) {
+				return tt.dump, &database.Metadata{Type: "full"}, os.WriteFile(tt.dump, []byte("dump"), 0600)
+			}
+
+			artifact, _, _, err := m.backupTarget(StartRun("backup"), target{dump: dump})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if artifact != tt.want {
+				t.Fatalf("uploaded %s, want %s", artifact, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(st.Config.Path, tt.want+database.MetadataSuffix)); err != nil {
+				t.Fatalf("metadata not uploaded: %v", err)
+			}
+			if entries, _ := os.ReadDir("."); len(entries) != 0 {
+				t.Fatalf("left files behind: %v", entries)
+			}
+		})
+	}
+}
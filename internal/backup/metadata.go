@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/antigravity/dbbackup/internal/database"
+	"github.com/antigravity/dbbackup/internal/storage"
+)
+
+// ReadMetadata reads the metadata sidecar of an artifact from storage
+func ReadMetadata(st storage.Storage, artifact string) (*database.Metadata, error) {
+	reader, err := st.GetReader(artifact + database.MetadataSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var meta database.Metadata
+	if err := json.NewDecoder(reader).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata for %s: %v", artifact, err)
+	}
+	return &meta, nil
+}
+
+// ListMetadata reads every metadata sidecar in storage, newest first
+func ListMetadata(st storage.Storage) ([]*database.Metadata, error) {
+	files, err := st.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*database.Metadata
+	for _, file := range files {
+		if !database.IsMetadata(file) {
+			continue
+		}
+		meta, err := ReadMetadata(st, file[:len(file)-len(database.MetadataSuffix)])
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, meta)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Created.After(all[j].Created) })
+	return all, nil
+}
+
+// uploadMetadata stores meta next to the artifact it describes
+func uploadMetadata(st storage.Storage, meta *database.Metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := meta.Artifact + database.MetadataSuffix
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		return err
+	}
+	defer os.Remove(name)
+	return st.Upload(name, name)
+}
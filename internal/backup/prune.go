@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/database"
+	"github.com/antigravity/dbbackup/internal/notifier"
+	"github.com/antigravity/dbbackup/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// backupTimestamp matches the timestamp the database providers put in backup file names
+var backupTimestamp = regexp.MustCompile(`_(\d{8}_\d{6})\.`)
+
+// parseBackupTime extracts the creation time from a backup file name
+func parseBackupTime(name string) (time.Time, bool) {
+	base := path.Base(name)
+	if !strings.HasPrefix(base, "backup_") {
+		return time.Time{}, false
+	}
+
+	match := backupTimestamp.FindStringSubmatch(base)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.ParseInLocation("20060102_150405", match[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Prune deletes backups older than the configured retention. Backups that the
+// storage reports as locked (object lock, retention policy or legal hold) are kept.
+func (m *Manager) Prune() ([]string, error) {
+	if m.Config.RetentionDays <= 0 {
+		return nil, nil
+	}
+
+	run := StartRun("prune",
+		attribute.String("storage.provider", ProviderName(m.Storage)),
+		attribute.Int("prune.retention_days", m.Config.RetentionDays),
+	)
+	_, end := run.Phase("list")
+	files, err := m.Storage.List("")
+	end(err)
+	if err != nil {
+		return nil, m.failPrune(run, nil, fmt.Errorf("failed to list backups: %v", err))
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -m.Config.RetentionDays)
+	locker, canLock := m.Storage.(storage.Locker)
+
+	needed, err := m.neededBases(files, cutoff)
+	if err != nil {
+		return nil, m.failPrune(run, nil, err)
+	}
+
+	var deleted []string
+	for _, file := range files {
+		// Sidecars go together with their artifact
+		if database.IsMetadata(file) {
+			continue
+		}
+		created, ok := parseBackupTime(file)
+		if !ok || !created.Before(cutoff) {
+			continue
+		}
+		if needed[file] {
+			run.Log.Info("Keeping backup that a newer incremental builds on", "file", file)
+			continue
+		}
+
+		if canLock {
+			locked, err := locker.IsLocked(file)
+			if err != nil {
+				run.Log.Warn("Failed to check lock, keeping backup", "file", file, "error", err)
+				continue
+			}
+			if locked {
+				run.Log.Info("Keeping locked backup", "file", file)
+				continue
+			}
+		}
+
+		// Delete what the artifact points at first, so a failure leaves the
+		// artifact for the next prune to retry
+		sidecar := file + database.MetadataSuffix
+		if pruner, ok := m.DB.(database.Pruner); ok && contains(files, sidecar) {
+			if err := m.deleteBackup(run, pruner, file); err != nil {
+				run.Log.Warn("Failed to delete the backup behind the artifact, keeping it", "file", file, "error", err)
+				continue
+			}
+		}
+
+		_, end := run.Phase("delete", attribute.String("backup.artifact", file))
+		err = m.Storage.Delete(file)
+		end(err)
+		if err != nil {
+			return deleted, m.failPrune(run, deleted, fmt.Errorf("failed to delete %s: %v", file, err))
+		}
+		run.Log.Info("Pruned backup", "file", file)
+		deleted = append(deleted, file)
+
+		if contains(files, sidecar) {
+			if err := m.Storage.Delete(sidecar); err != nil {
+				run.Log.Warn("Failed to delete backup metadata", "file", sidecar, "error", err)
+			}
+		}
+	}
+
+	if len(deleted) > 0 {
+		m.notify(run, notifier.Event{
+			Operation: "prune",
+			Status:    notifier.StatusSuccess,
+			Duration:  time.Since(run.Start),
+			Artifact:  strings.Join(deleted, ", "),
+		})
+	}
+	run.SetAttributes(attribute.Int("prune.deleted", len(deleted)))
+	run.End(nil)
+	return deleted, nil
+}
+
+// deleteBackup lets the provider delete what an artifact points at
+func (m *Manager) deleteBackup(run *Run, pruner database.Pruner, file string) error {
+	meta, err := ReadMetadata(m.Storage, file)
+	if err != nil {
+		return fmt.Errorf("failed to read backup metadata: %v", err)
+	}
+	_, end := run.Phase("delete_backup", attribute.String("backup.artifact", file))
+	err = pruner.DeleteBackup(m.Storage, meta)
+	end(err)
+	return err
+}
+
+// neededBases returns the artifacts that backups newer than cutoff build on,
+// following the metadata sidecars down to the full backup
+func (m *Manager) neededBases(files []string, cutoff time.Time) (map[string]bool, error) {
+	bases := map[string]string{}
+	for _, file := range files {
+		if !database.IsMetadata(file) {
+			continue
+		}
+		meta, err := ReadMetadata(m.Storage, strings.TrimSuffix(file, database.MetadataSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup metadata: %v", err)
+		}
+		if meta.Base != "" {
+			bases[meta.Artifact] = meta.Base
+		}
+	}
+
+	needed := map[string]bool{}
+	for artifact := range bases {
+		if created, ok := parseBackupTime(artifact); ok && created.Before(cutoff) {
+			continue
+		}
+		for base, ok := bases[artifact]; ok && !needed[base]; base, ok = bases[base] {
+			needed[base] = true
+		}
+	}
+	return needed, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) failPrune(run *Run, deleted []string, err error) error {
+	m.notify(run, notifier.Event{
+		Operation: "prune",
+		Phase:     "prune",
+		Status:    notifier.StatusFailure,
+		Error:     err.Error(),
+		Duration:  time.Since(run.Start),
+		Artifact:  strings.Join(deleted, ", "),
+	})
+	run.End(err)
+	return err
+}
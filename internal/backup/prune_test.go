@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/database"
+	"github.com/antigravity/dbbackup/internal/storage"
+)
+
+var (
+	expired = time.Now().AddDate(0, 0, -30)
+	recent  = time.Now().AddDate(0, 0, -1)
+)
+
+// artifactName returns a physical MySQL artifact name created at t
+func artifactName(t time.Time, n int) string {
+	return "backup_mysql-physical_" + t.Add(time.Duration(n)*time.Second).Format("20060102_150405") + ".xbstream.gz"
+}
+
+// localStore returns a local storage holding the given artifacts. Artifacts
+// with a metadata entry get a sidecar; a non-empty value is their base.
+func localStore(t *testing.T, artifacts []string, bases map[string]string) *storage.LocalStorage {
+	t.Helper()
+	dir := t.TempDir()
+	for _, artifact := range artifacts {
+		os.WriteFile(filepath.Join(dir, artifact), []byte("backup"), 0600)
+		base, ok := bases[artifact]
+		if !ok {
+			continue
+		}
+		meta := database.Metadata{Artifact: artifact, Provider: "mysql-physical", Type: "full", Base: base}
+		if base != "" {
+			meta.Type = "incremental"
+		}
+		data, _ := json.Marshal(meta)
+		os.WriteFile(filepath.Join(dir, artifact+database.MetadataSuffix), data, 0600)
+	}
+	return storage.NewLocalStorage(config.StorageConfig{Type: "local", Path: dir})
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestNeededBases(t *testing.T) {
+	oldFull, oldInc1, oldInc2 := artifactName(expired, 0), artifactName(expired, 1), artifactName(expired, 2)
+	newInc, newDiff, newFull := artifactName(recent, 0), artifactName(recent, 1), artifactName(recent, 2)
+
+	tests := []struct {
+		name  string
+		bases map[string]string
+		want  []string
+	}{
+		{
+			name:  "no metadata",
+			bases: map[string]string{},
+			want:  nil,
+		},
+		{
+			name:  "fully expired chain",
+			bases: map[string]string{oldFull: "", oldInc1: oldFull, oldInc2: oldInc1},
+			want:  nil,
+		},
+		{
+			name:  "recent incremental keeps its whole chain",
+			bases: map[string]string{oldFull: "", oldInc1: oldFull, oldInc2: oldInc1, newInc: oldInc2},
+			want:  []string{oldFull, oldInc1, oldInc2},
+		},
+		{
+			name:  "recent differential keeps only the full backup",
+			bases: map[string]string{oldFull: "", oldInc1: oldFull, newDiff: oldFull},
+			want:  []string{oldFull},
+		},
+		{
+			name:  "recent full backup needs nothing",
+			bases: map[string]string{oldFull: "", oldInc1: oldFull, newFull: ""},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var artifacts []string
+			for artifact := range tt.bases {
+				artifacts = append(artifacts, artifact)
+			}
+			st := localStore(t, artifacts, tt.bases)
+			files, _ := st.List("")
+
+			m := NewManager(nil, st, config.BackupConfig{}, nil)
+			needed, err := m.neededBases(files, time.Now().AddDate(0, 0, -7))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sortedKeys(needed); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("neededBases = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	oldFull, oldInc, oldLogical := artifactName(expired, 0), artifactName(expired, 1), "backup_postgres_app_"+expired.Format("20060102_150405")+".sql"
+	newInc, newLogical := artifactName(recent, 0), "backup_postgres_app_"+recent.Format("20060102_150405")+".sql"
+	orphan := artifactName(expired, 5)
+
+	st := localStore(t,
+		[]string{oldFull, oldInc, oldLogical, newInc, newLogical, orphan, "notes.txt"},
+		map[string]string{oldFull: "", oldInc: oldFull, newInc: oldInc, orphan: ""},
+	)
+	m := NewManager(nil, st, config.BackupConfig{RetentionDays: 7}, nil)
+
+	deleted, err := m.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{oldLogical, orphan}
+	sort.Strings(deleted)
+	sort.Strings(want)
+	if strings.Join(deleted, ",") != strings.Join(want, ",") {
+		t.Fatalf("deleted %v, want %v", deleted, want)
+	}
+
+	files, _ := st.List("")
+	for _, gone := range []string{orphan, orphan + database.MetadataSuffix, oldLogical} {
+		if contains(files, gone) {
+			t.Errorf("%s was not deleted", gone)
+		}
+	}
+	for _, kept := range []string{oldFull, oldInc, newInc, newLogical, "notes.txt"} {
+		if !contains(files, kept) {
+			t.Errorf("%s was deleted", kept)
+		}
+	}
+}
+
+func TestPruneWithoutRetention(t *testing.T) {
+	st := localStore(t, []string{artifactName(expired, 0)}, nil)
+	deleted, err := NewManager(nil, st, config.BackupConfig{}, nil).Prune()
+	if err != nil || len(deleted) != 0 {
+		t.Fatalf("Prune = %v, %v", deleted, err)
+	}
+}
+
+// pruningDB records the backups Prune asks it to delete
+type pruningDB struct {
+	fakeDB
+	st      storage.Storage
+	deleted []string
+	fail    bool
+}
+
+func (p *pruningDB) DeleteBackup(st storage.Storage, meta *database.Metadata) error {
+	if p.fail {
+		return fmt.Errorf("cluster unreachable")
+	}
+	p.st = st
+	p.deleted = append(p.deleted, meta.Artifact)
+	return nil
+}
+
+func TestPruneDeletesBackupData(t *testing.T) {
+	withMeta, withoutMeta := artifactName(expired, 0), artifactName(expired, 1)
+
+	for _, fail := range []bool{false, true} {
+		st := localStore(t, []string{withMeta, withoutMeta}, map[string]string{withMeta: ""})
+		db := &pruningDB{fail: fail}
+		deleted, err := NewManager(db, st, config.BackupConfig{RetentionDays: 7}, nil).Prune()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, _ := st.List("")
+		if fail {
+			// The artifact stays for the next prune to retry
+			if !contains(files, withMeta) || !contains(files, withMeta+database.MetadataSuffix) || contains(deleted, withMeta) {
+				t.Errorf("artifact deleted although its backup wasn't: %v", files)
+			}
+			continue
+		}
+		if strings.Join(db.deleted, ",") != withMeta || db.st != st {
+			t.Errorf("DeleteBackup called for %v with %v", db.deleted, db.st)
+		}
+		if len(files) != 0 {
+			t.Errorf("left %v", files)
+		}
+	}
+}
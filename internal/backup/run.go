@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/antigravity/dbbackup")
+
+// Run tracks one backup, restore or prune: its root span, its correlation ID
+// and how long each phase took. Phases become child spans.
+type Run struct {
+	ID     string       // trace ID when tracing, random otherwise
+	Log    *slog.Logger // logs with the run ID attached
+	Start  time.Time
+	Phases map[string]time.Duration
+	ctx    context.Context
+	span   trace.Span
+}
+
+// StartRun starts the root span of an operation
+func StartRun(operation string, attrs ...attribute.KeyValue) *Run {
+	ctx, span := tracer.Start(context.Background(), operation, trace.WithAttributes(attrs...))
+
+	id := newRunID()
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		id = sc.TraceID().String()
+	}
+	span.SetAttributes(attribute.String("run.id", id))
+
+	return &Run{
+		ID:     id,
+		Log:    logger.With("run_id", id, "operation", operation),
+		Start:  time.Now(),
+		Phases: map[string]time.Duration{},
+		ctx:    ctx,
+		span:   span,
+	}
+}
+
+func newRunID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Phase starts a child span for one phase of the run. The returned function
+// ends it and records the phase duration; pass it the phase's error, if any.
+func (r *Run) Phase(name string, attrs ...attribute.KeyValue) (trace.Span, func(error)) {
+	start := time.Now()
+	_, span := tracer.Start(r.ctx, name, trace.WithAttributes(attrs...))
+	return span, func(err error) {
+		// Phases that run once per database add up
+		r.Phases[name] += time.Since(start)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// End ends the root span
+func (r *Run) End(err error) {
+	if err != nil {
+		r.span.RecordError(err)
+		r.span.SetStatus(codes.Error, err.Error())
+	}
+	r.span.End()
+}
+
+// SetAttributes adds attributes to the root span
+func (r *Run) SetAttributes(attrs ...attribute.KeyValue) {
+	r.span.SetAttributes(attrs...)
+}
+
+// ProviderName returns a short provider name for a database or storage
+// implementation, e.g. "postgres" for *database.Postgres.
+func ProviderName(v any) string {
+	name := fmt.Sprintf("%T", v)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(strings.TrimSuffix(name, "Storage"))
+}
@@ -0,0 +1,211 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// runSpans returns the ended spans of the run with the given ID, keyed by
+// name. The global provider can only be installed once, so all tests share
+// one recorder and tell their runs apart by trace ID.
+func runSpans(t *testing.T, runID string) map[string]sdktrace.ReadOnlySpan {
+	t.Helper()
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == runID {
+			spans[span.Name()] = span
+		}
+	}
+	return spans
+}
+
+func recordSpans() {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+}
+
+// tracedDB dumps a fixed file, or fails to connect or dump
+type tracedDB struct {
+	connectErr error
+	dumpErr    error
+}
+
+func (d *tracedDB) Connect() error        { return nil }
+func (d *tracedDB) TestConnection() error { return d.connectErr }
+func (d *tracedDB) Restore(string) error  { return nil }
+func (d *tracedDB) Close() error          { return nil }
+
+func (d *tracedDB) Backup(string) (string, error) {
+	if d.dumpErr != nil {
+		return "", d.dumpErr
+	}
+	return "backup_traced_20260102_150405.sql", os.WriteFile("backup_traced_20260102_150405.sql", []byte("dump"), 0600)
+}
+
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestRunSpans(t *testing.T) {
+	recordSpans()
+	run := StartRun("backup", attribute.String("db.provider", "traced"))
+	if len(run.ID) != 32 {
+		t.Fatalf("run ID %q is not a trace ID", run.ID)
+	}
+	_, end := run.Phase("dump", attribute.String("db.name", "app"))
+	end(nil)
+	_, end = run.Phase("dump", attribute.String("db.name", "billing"))
+	end(errors.New("disk full"))
+	run.End(nil)
+
+	var dumps []sdktrace.ReadOnlySpan
+	var root sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != run.ID {
+			continue
+		}
+		if span.Name() == "backup" {
+			root = span
+		} else {
+			dumps = append(dumps, span)
+		}
+	}
+	if root == nil || len(dumps) != 2 {
+		t.Fatalf("got root %v and %d dump spans", root, len(dumps))
+	}
+	if got := attrs(root)["run.id"].AsString(); got != run.ID {
+		t.Errorf("run.id = %q, want %q", got, run.ID)
+	}
+	for _, span := range dumps {
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of the root span", span.Name())
+		}
+	}
+	if dumps[0].Status().Code != codes.Unset || dumps[1].Status().Code != codes.Error || dumps[1].Status().Description != "disk full" {
+		t.Errorf("dump statuses %v, %v", dumps[0].Status(), dumps[1].Status())
+	}
+	if _, ok := run.Phases["dump"]; !ok {
+		t.Error("dump phase duration not recorded")
+	}
+}
+
+func TestPerformBackupSpans(t *testing.T) {
+	recordSpans()
+	t.Chdir(t.TempDir())
+	st := localStore(t, nil, nil)
+	m := NewManager(&tracedDB{}, st, config.BackupConfig{Type: "full", Compression: true}, nil)
+	if err := m.PerformBackup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The run ID isn't returned, so find the trace through the root span
+	var runID string
+	for _, span := range recorder.Ended() {
+		if span.Name() == "backup" && attrs(span)["db.provider"].AsString() == "traceddb" {
+			runID = span.SpanContext().TraceID().String()
+		}
+	}
+	spans := runSpans(t, runID)
+	root := spans["backup"]
+	if root == nil {
+		t.Fatal("no root span")
+	}
+	rootAttrs := attrs(root)
+	if rootAttrs["storage.provider"].AsString() != "local" || rootAttrs["backup.type"].AsString() != "full" ||
+		!rootAttrs["backup.compression"].AsBool() || rootAttrs["backup.artifact"].AsString() != "backup_traced_20260102_150405.sql.gz" {
+		t.Errorf("root attributes %v", root.Attributes())
+	}
+	if root.Status().Code != codes.Unset {
+		t.Errorf("root status %v", root.Status())
+	}
+
+	for _, name := range []string{"connect", "dump", "compress", "upload"} {
+		span := spans[name]
+		if span == nil {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of the root span", name)
+		}
+		if span.Status().Code != codes.Unset {
+			t.Errorf("%s status %v", name, span.Status())
+		}
+	}
+	if got := attrs(spans["dump"])["backup.dump_size"].AsInt64(); got != 4 {
+		t.Errorf("backup.dump_size = %d", got)
+	}
+	if got := attrs(spans["compress"])["backup.compressed_size"].AsInt64(); got == 0 {
+		t.Error("backup.compressed_size not set")
+	}
+	uploadAttrs := attrs(spans["upload"])
+	if uploadAttrs["backup.artifact"].AsString() != "backup_traced_20260102_150405.sql.gz" || uploadAttrs["backup.size"].AsInt64() == 0 {
+		t.Errorf("upload attributes %v", spans["upload"].Attributes())
+	}
+}
+
+func TestPerformBackupSpansFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		db      *tracedDB
+		failed  string
+		skipped []string
+	}{
+		{"connect", &tracedDB{connectErr: errors.New("connection refused")}, "connect", []string{"dump", "compress", "upload"}},
+		{"dump", &tracedDB{dumpErr: errors.New("access denied")}, "dump", []string{"compress", "upload"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordSpans()
+			t.Chdir(t.TempDir())
+			before := len(recorder.Ended())
+			m := NewManager(tt.db, localStore(t, nil, nil), config.BackupConfig{Type: "full", Compression: true}, nil)
+			if err := m.PerformBackup(); err == nil {
+				t.Fatal("backup succeeded")
+			}
+
+			var runID string
+			for _, span := range recorder.Ended()[before:] {
+				if span.Name() == "backup" {
+					runID = span.SpanContext().TraceID().String()
+				}
+			}
+			spans := runSpans(t, runID)
+			root, failed := spans["backup"], spans[tt.failed]
+			if root == nil || failed == nil {
+				t.Fatalf("spans %v", spans)
+			}
+			if root.Status().Code != codes.Error || failed.Status().Code != codes.Error {
+				t.Errorf("root status %v, %s status %v", root.Status(), tt.failed, failed.Status())
+			}
+			if len(failed.Events()) == 0 || failed.Events()[0].Name != "exception" {
+				t.Errorf("%s span didn't record the error", tt.failed)
+			}
+			for _, name := range tt.skipped {
+				if _, ok := spans[name]; ok {
+					t.Errorf("%s span after a failed %s", name, tt.failed)
+				}
+			}
+		})
+	}
+}
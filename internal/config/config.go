@@ -6,37 +6,304 @@ type Config struct {
 	Backup   BackupConfig   `mapstructure:"backup"`
 	Log      LogConfig      `mapstructure:"log"`
 	Notify   NotifyConfig   `mapstructure:"notify"`
+	Metrics  MetricsConfig  `mapstructure:"metrics"`
+	Tracing  TracingConfig  `mapstructure:"tracing"`
+	Secrets  SecretsConfig  `mapstructure:"secrets"`
 }
 
 type DatabaseConfig struct {
-	Type     string `mapstructure:"type"` // mysql, postgres, mongodb, d1
-	Host     string `mapstructure:"host"`
-	Port     int    `mapstructure:"port"`
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
-	DBName   string `mapstructure:"dbname"`
-	ExtraParams string `mapstructure:"extra_params"` // e.g. sslmode=disable
-	ToolPath string `mapstructure:"tool_path"` // path to mysqldump, pg_dump, etc.
+	Type          string              `mapstructure:"type"` // mysql, postgres, mongodb, d1, cockroachdb, clickhouse, mssql, elasticsearch
+	Host          string              `mapstructure:"host"`
+	Port          int                 `mapstructure:"port"`
+	User          string              `mapstructure:"user"`
+	Password      string              `mapstructure:"password"`
+	DBName        string              `mapstructure:"dbname"`       // "*" backs up every database on the server
+	Include       []string            `mapstructure:"include"`      // database name patterns to back up, implies discovery
+	Exclude       []string            `mapstructure:"exclude"`      // database name patterns to skip
+	ExtraParams   string              `mapstructure:"extra_params"` // e.g. sslmode=disable
+	ToolPath      string              `mapstructure:"tool_path"`    // path to mysqldump, pg_dump, etc.
+	TLS           TLSConfig           `mapstructure:"tls"`
+	MongoDB       MongoDBConfig       `mapstructure:"mongodb"`
+	Postgres      PostgresConfig      `mapstructure:"postgres"`
+	MySQL         MySQLConfig         `mapstructure:"mysql"`
+	D1            D1Config            `mapstructure:"d1"`
+	CockroachDB   CockroachDBConfig   `mapstructure:"cockroachdb"`
+	ClickHouse    ClickHouseConfig    `mapstructure:"clickhouse"`
+	MSSQL         MSSQLConfig         `mapstructure:"mssql"`
+	Elasticsearch ElasticsearchConfig `mapstructure:"elasticsearch"`
+}
+
+// ElasticsearchConfig holds options for the elasticsearch provider, which
+// also works with OpenSearch. dbname labels the backups, e.g. the cluster name.
+type ElasticsearchConfig struct {
+	APIKey             string   `mapstructure:"api_key"`              // base64 API key, used instead of user and password
+	Repository         string   `mapstructure:"repository"`           // snapshot repository name, default dbbackup
+	Location           string   `mapstructure:"location"`             // fs repository path listed in path.repo on every node; derived from s3 or local storage if empty
+	S3Client           string   `mapstructure:"s3_client"`            // s3 repository client whose credentials are in the cluster keystore, default "default"
+	Indices            []string `mapstructure:"indices"`              // index patterns to snapshot and restore, default all
+	IncludeGlobalState bool     `mapstructure:"include_global_state"` // templates, persistent settings and the like
+	RenamePattern      string   `mapstructure:"rename_pattern"`       // restore: regex matched against index names, e.g. (.+)
+	RenameReplacement  string   `mapstructure:"rename_replacement"`   // restore: e.g. restored_$1
+}
+
+// MSSQLConfig holds options for the mssql provider. BACKUP DATABASE writes
+// to backup_dir on the server, which must be reachable from here as share_path.
+type MSSQLConfig struct {
+	Mode        string `mapstructure:"mode"`        // backup (native BACKUP DATABASE, default) or bacpac (sqlpackage export)
+	BackupDir   string `mapstructure:"backup_dir"`  // directory as SQL Server sees it, e.g. /var/opt/mssql/backup or \\fileserver\backups
+	SharePath   string `mapstructure:"share_path"`  // the same directory as mounted here, defaults to backup_dir
+	CopyOnly    bool   `mapstructure:"copy_only"`   // full backups leave the differential base alone; they can't be a base themselves
+	Compression bool   `mapstructure:"compression"` // backup compression, not available in Express edition
+	DataDir     string `mapstructure:"data_dir"`    // restore: data file location, defaults to the instance default
+	LogDir      string `mapstructure:"log_dir"`     // restore: log file location, defaults to the instance default
+}
+
+// ClickHouseConfig holds options for the clickhouse provider, which talks to
+// the HTTP interface (default port 8123)
+type ClickHouseConfig struct {
+	Mode       string `mapstructure:"mode"`        // backup (native BACKUP) or dump (DDL and Native data per table); default backup when a destination is known
+	BackupDisk string `mapstructure:"backup_disk"` // server disk for BACKUP ... TO Disk(); s3 storage is used otherwise
+}
+
+// CockroachDBConfig holds options for the cockroachdb provider, whose native
+// BACKUP writes straight to a collection in cloud or node-local storage
+type CockroachDBConfig struct {
+	Collection      string `mapstructure:"collection"`       // e.g. nodelocal://1/backups or s3://bucket/path?AUTH=implicit; derived from s3/gcs storage if empty
+	AsOf            string `mapstructure:"as_of"`            // back up this far in the past to avoid contention, e.g. -10s
+	RevisionHistory bool   `mapstructure:"revision_history"` // keep every revision so restore --to-time can reach any point
+}
+
+// D1Config holds the Cloudflare API settings for the d1 provider. DBName is
+// the D1 database name.
+type D1Config struct {
+	AccountID  string `mapstructure:"account_id"`  // defaults to CLOUDFLARE_ACCOUNT_ID
+	APIToken   string `mapstructure:"api_token"`   // needs D1 edit permission, defaults to CLOUDFLARE_API_TOKEN
+	DatabaseID string `mapstructure:"database_id"` // optional, looked up by name otherwise
+	Endpoint   string `mapstructure:"endpoint"`    // API base URL, defaults to https://api.cloudflare.com/client/v4
+
+	TimeTravelDays int `mapstructure:"time_travel_days"` // how far back Time Travel reaches: 30 on paid plans (default), 7 on the free plan
+}
+
+// MySQLConfig holds options for the mysql provider
+type MySQLConfig struct {
+	Engine        string          `mapstructure:"engine"`         // mysqldump (default), mydumper or physical (xtrabackup)
+	Parallel      int             `mapstructure:"parallel"`       // mydumper/myloader threads; physical: number of files copied in parallel
+	ChunkSize     int             `mapstructure:"chunk_size"`     // mydumper: rows per chunk, splitting large tables across threads
+	Tables        []string        `mapstructure:"tables"`         // mysqldump/mydumper: only these tables
+	ExcludeTables []string        `mapstructure:"exclude_tables"` // mysqldump/mydumper: tables to skip
+	DataDir       string          `mapstructure:"data_dir"`       // physical restore: empty datadir of the stopped target server
+	WorkDir       string          `mapstructure:"work_dir"`       // physical restore: where backups are extracted and prepared, defaults to the temp dir
+	Dump          MySQLDumpConfig `mapstructure:"dump"`
+}
+
+// MySQLDumpConfig holds the mysqldump options. Unset options take the safe
+// default noted for each.
+type MySQLDumpConfig struct {
+	SingleTransaction *bool  `mapstructure:"single_transaction"` // default true: consistent InnoDB snapshot without locking
+	Routines          *bool  `mapstructure:"routines"`           // default true
+	Triggers          *bool  `mapstructure:"triggers"`           // default true
+	Events            *bool  `mapstructure:"events"`             // default true
+	HexBlob           *bool  `mapstructure:"hex_blob"`           // default true
+	Quick             *bool  `mapstructure:"quick"`              // default true: stream rows instead of buffering tables
+	SetGTIDPurged     string `mapstructure:"set_gtid_purged"`    // MySQL only: OFF (default), ON, AUTO or COMMENTED
+	SourceData        *int   `mapstructure:"source_data"`        // default 2: binlog position as a comment, 1 as a statement, 0 off
+}
+
+// PostgresConfig holds options for the postgres provider
+type PostgresConfig struct {
+	Mode               string `mapstructure:"mode"`                 // logical (pg_dump, default) or physical (pg_basebackup)
+	Checkpoint         string `mapstructure:"checkpoint"`           // physical: fast or spread (default)
+	DataDir            string `mapstructure:"data_dir"`             // physical restore: empty data directory of the stopped target instance
+	RestoreCommand     string `mapstructure:"restore_command"`      // physical restore: fetches archived WAL, e.g. cp /wal_archive/%f %p
+	RecoveryTargetTime string `mapstructure:"recovery_target_time"` // physical restore: stop WAL replay at this time
+}
+
+// MongoDBConfig holds connection options for MongoDB. A full URI takes
+// precedence over host and port; user and password are added to it if it has
+// no credentials of its own.
+type MongoDBConfig struct {
+	URI            string `mapstructure:"uri"`         // mongodb:// or mongodb+srv:// connection string
+	SRV            bool   `mapstructure:"srv"`         // use mongodb+srv:// with host as the SRV name
+	AuthSource     string `mapstructure:"auth_source"` // database to authenticate against, default admin
+	ReplicaSet     string `mapstructure:"replica_set"`
+	ReadPreference string `mapstructure:"read_preference"` // e.g. secondaryPreferred to back up from a secondary
+}
+
+// TLSConfig secures the connection to the database, both for the Go driver and
+// for the dump and restore tools
+type TLSConfig struct {
+	Mode       string `mapstructure:"mode"`        // disable, require, verify-ca or verify-full
+	CAFile     string `mapstructure:"ca_file"`     // PEM bundle to verify the server with
+	CertFile   string `mapstructure:"cert_file"`   // client certificate for mTLS
+	KeyFile    string `mapstructure:"key_file"`    // client key for mTLS
+	ServerName string `mapstructure:"server_name"` // expected name in the server certificate, default host
 }
 
 type StorageConfig struct {
-	Type            string `mapstructure:"type"` // local, s3, gcs, azure
-	Path            string `mapstructure:"path"` // local path or bucket name
-	Region          string `mapstructure:"region"` // for cloud
-	CredentialsFile string `mapstructure:"credentials_file"` // for cloud
+	Type            string            `mapstructure:"type"`             // local, s3, gcs, azure, sftp, webdav, http
+	Path            string            `mapstructure:"path"`             // local path or bucket name
+	Region          string            `mapstructure:"region"`           // for cloud
+	CredentialsFile string            `mapstructure:"credentials_file"` // for cloud
+	Tags            map[string]string `mapstructure:"tags"`             // S3 object tags, GCS metadata, Azure blob tags and metadata
+	Lock            LockConfig        `mapstructure:"lock"`
+	S3              S3Config          `mapstructure:"s3"`
+	GCS             GCSConfig         `mapstructure:"gcs"`
+	Azure           AzureConfig       `mapstructure:"azure"`
+	SFTP            SFTPConfig        `mapstructure:"sftp"`
+	WebDAV          WebDAVConfig      `mapstructure:"webdav"`
+}
+
+// LockConfig makes uploaded backups immutable (WORM) for a retention window.
+// It maps to S3 Object Lock, GCS object retention, Azure immutability policies
+// and read-only files for local storage.
+type LockConfig struct {
+	Mode          string `mapstructure:"mode"` // governance or compliance
+	RetentionDays int    `mapstructure:"retention_days"`
+	LegalHold     bool   `mapstructure:"legal_hold"` // place a legal hold on every upload
+	Chattr        bool   `mapstructure:"chattr"`     // local only: also set the immutable attribute (needs CAP_LINUX_IMMUTABLE)
+}
+
+// S3Config holds options for S3 and S3-compatible services (MinIO, Backblaze B2, Wasabi, Cloudflare R2)
+type S3Config struct {
+	Endpoint        string `mapstructure:"endpoint"`       // e.g. http://localhost:9000 or https://<account>.r2.cloudflarestorage.com
+	UsePathStyle    bool   `mapstructure:"use_path_style"` // required by MinIO and most self-hosted services
+	CABundle        string `mapstructure:"ca_bundle"`      // PEM file with a custom CA for the endpoint
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	SessionToken    string `mapstructure:"session_token"`
+	Profile         string `mapstructure:"profile"`  // shared config/credentials profile
+	RoleARN         string `mapstructure:"role_arn"` // role to assume on top of the resolved credentials
+	ExternalID      string `mapstructure:"external_id"`
+	RoleSessionName string `mapstructure:"role_session_name"`
+
+	ServerSideEncryption string `mapstructure:"server_side_encryption"` // AES256, aws:kms or aws:kms:dsse
+	KMSKeyID             string `mapstructure:"kms_key_id"`
+	SSECustomerKey       string `mapstructure:"sse_customer_key"` // base64-encoded 256-bit key for SSE-C
+	StorageClass         string `mapstructure:"storage_class"`    // e.g. STANDARD_IA, GLACIER_IR, GLACIER, DEEP_ARCHIVE
+	RestoreDays          int    `mapstructure:"restore_days"`     // how long a rehydrated archive copy is kept, default 1
+	RestoreTier          string `mapstructure:"restore_tier"`     // Expedited, Standard or Bulk
+}
+
+type GCSConfig struct {
+	KMSKeyName   string `mapstructure:"kms_key_name"`  // CMEK, projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>
+	StorageClass string `mapstructure:"storage_class"` // STANDARD, NEARLINE, COLDLINE or ARCHIVE
+}
+
+type AzureConfig struct {
+	EncryptionScope   string `mapstructure:"encryption_scope"`
+	AccessTier        string `mapstructure:"access_tier"`        // Hot, Cool, Cold or Archive
+	RehydrateTier     string `mapstructure:"rehydrate_tier"`     // tier to rehydrate archived blobs to, default Hot
+	RehydratePriority string `mapstructure:"rehydrate_priority"` // Standard or High
+}
+
+// SFTPConfig holds connection options for the sftp storage type. StorageConfig.Path is the remote directory.
+type SFTPConfig struct {
+	Host                  string `mapstructure:"host"`
+	Port                  int    `mapstructure:"port"` // default 22
+	User                  string `mapstructure:"user"`
+	Password              string `mapstructure:"password"`
+	PrivateKeyFile        string `mapstructure:"private_key_file"`
+	PrivateKeyPassphrase  string `mapstructure:"private_key_passphrase"`
+	KnownHostsFile        string `mapstructure:"known_hosts_file"`         // default ~/.ssh/known_hosts
+	InsecureIgnoreHostKey bool   `mapstructure:"insecure_ignore_host_key"` // testing only
+}
+
+// WebDAVConfig holds options for the webdav and (read-only) http storage types
+type WebDAVConfig struct {
+	URL         string `mapstructure:"url"`  // base collection URL, defaults to StorageConfig.Path
+	User        string `mapstructure:"user"` // basic auth
+	Password    string `mapstructure:"password"`
+	BearerToken string `mapstructure:"bearer_token"`
+	UploadsURL  string `mapstructure:"uploads_url"` // Nextcloud chunking endpoint, e.g. https://cloud/remote.php/dav/uploads/<user>
+	ChunkSize   int64  `mapstructure:"chunk_size"`  // bytes per chunk, default 10 MiB
 }
 
 type BackupConfig struct {
-	Type        string `mapstructure:"type"` // full, incremental, differential
-	Compression bool   `mapstructure:"compression"`
-	Schedule    string `mapstructure:"schedule"` // cron expression
+	Job           string `mapstructure:"job"`  // job name in notifications, default <database type>/<dbname>
+	Type          string `mapstructure:"type"` // full, incremental, differential
+	Compression   bool   `mapstructure:"compression"`
+	Schedule      string `mapstructure:"schedule"`       // cron expression
+	RetentionDays int    `mapstructure:"retention_days"` // delete backups older than this, 0 keeps everything
 }
 
 type LogConfig struct {
-	Level string `mapstructure:"level"`
-	File  string `mapstructure:"file"`
+	Level      string `mapstructure:"level"`        // debug, info (default), warn or error
+	Format     string `mapstructure:"format"`       // text (default) or json
+	File       string `mapstructure:"file"`         // log to this file instead of stdout
+	MaxSizeMB  int    `mapstructure:"max_size_mb"`  // rotate the file at this size, default 100
+	MaxBackups int    `mapstructure:"max_backups"`  // rotated files to keep, 0 keeps all
+	MaxAgeDays int    `mapstructure:"max_age_days"` // delete rotated files older than this, 0 keeps all
+	Compress   bool   `mapstructure:"compress"`     // gzip rotated files
 }
 
 type NotifyConfig struct {
-	SlackWebhookURL string `mapstructure:"slack_webhook_url"`
+	SlackWebhookURL string           `mapstructure:"slack_webhook_url"`
+	Channels        []NotifierConfig `mapstructure:"channels"`
+}
+
+// NotifierConfig configures one notification channel. Only the fields relevant to Type are used.
+type NotifierConfig struct {
+	Type       string      `mapstructure:"type"`        // slack, email, teams, discord, pagerduty, opsgenie, webhook
+	On         []string    `mapstructure:"on"`          // success, failure; empty means everything
+	URL        string      `mapstructure:"url"`         // webhook URL, or API URL override for pagerduty/opsgenie
+	Secret     string      `mapstructure:"secret"`      // webhook: HMAC-SHA256 signing secret
+	RoutingKey string      `mapstructure:"routing_key"` // pagerduty: Events v2 integration key
+	APIKey     string      `mapstructure:"api_key"`     // opsgenie
+	Template   string      `mapstructure:"template"`    // Go template over notifier.Event: the JSON body for slack, teams, discord and webhook, the message text otherwise
+	Email      EmailConfig `mapstructure:"email"`
+}
+
+type EmailConfig struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"` // default 587
+	User     string   `mapstructure:"user"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+type MetricsConfig struct {
+	PushgatewayURL string `mapstructure:"pushgateway_url"` // one-shot runs: push to a Prometheus Pushgateway
+	TextfilePath   string `mapstructure:"textfile_path"`   // one-shot runs: node_exporter textfile, e.g. /var/lib/node_exporter/dbbackup.prom
+}
+
+// TracingConfig exports OpenTelemetry traces over OTLP. The standard
+// OTEL_EXPORTER_OTLP_* environment variables are honoured as well.
+type TracingConfig struct {
+	Enabled     bool              `mapstructure:"enabled"`
+	Endpoint    string            `mapstructure:"endpoint"` // host:port, default localhost:4317 (grpc) or localhost:4318 (http)
+	Protocol    string            `mapstructure:"protocol"` // grpc (default) or http
+	Insecure    bool              `mapstructure:"insecure"` // disable TLS
+	Headers     map[string]string `mapstructure:"headers"`
+	ServiceName string            `mapstructure:"service_name"` // default dbbackup
+	SampleRatio float64           `mapstructure:"sample_ratio"` // 0 means always sample
+}
+
+// SecretsConfig configures the providers behind secret references such as
+// ${vault:secret/data/db#password} in other config values
+type SecretsConfig struct {
+	Vault VaultConfig        `mapstructure:"vault"`
+	AWS   AWSSecretsConfig   `mapstructure:"aws"`
+	GCP   GCPSecretsConfig   `mapstructure:"gcp"`
+	Azure AzureSecretsConfig `mapstructure:"azure"`
+}
+
+type VaultConfig struct {
+	Address   string `mapstructure:"address"` // default VAULT_ADDR
+	Token     string `mapstructure:"token"`   // default VAULT_TOKEN
+	Namespace string `mapstructure:"namespace"`
+}
+
+type AWSSecretsConfig struct {
+	Region   string `mapstructure:"region"`
+	Profile  string `mapstructure:"profile"`
+	Endpoint string `mapstructure:"endpoint"` // e.g. http://localhost:4566 for LocalStack
+}
+
+type GCPSecretsConfig struct {
+	Endpoint string `mapstructure:"endpoint"` // default https://secretmanager.googleapis.com
+}
+
+type AzureSecretsConfig struct {
+	TenantID string `mapstructure:"tenant_id"`
 }
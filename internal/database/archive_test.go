@@ -0,0 +1,110 @@
+package database
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	dir := filepath.FromSlash("/restore/data")
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"PG_VERSION", "/restore/data/PG_VERSION", false},
+		{"base/1/1259", "/restore/data/base/1/1259", false},
+		{"./global/pg_control", "/restore/data/global/pg_control", false},
+		{"base/../global", "/restore/data/global", false},
+		{".", "/restore/data", false},
+		{"/etc/passwd", "/restore/data/etc/passwd", false},
+		{"..", "", true},
+		{"../data2/file", "", true},
+		{"base/../../escape", "", true},
+		{"../../etc/passwd", "", true},
+		{"..foo", "/restore/data/..foo", false},
+	}
+	for _, tt := range tests {
+		got, err := safeJoin(dir, tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("safeJoin(%q) = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("safeJoin(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "base", "1"), 0700)
+	os.WriteFile(filepath.Join(src, "PG_VERSION"), []byte("17\n"), 0600)
+	os.WriteFile(filepath.Join(src, "base", "1", "1259"), []byte("heap"), 0600)
+
+	archive := filepath.Join(t.TempDir(), "data.tar")
+	if err := tarDirectory(src, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "restored")
+	if err := extractTar(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"PG_VERSION": "17\n", "base/1/1259": "heap"} {
+		if data, err := os.ReadFile(filepath.Join(dest, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []tar.Header
+		want    string
+	}{
+		{"parent path", []tar.Header{{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0600}}, "outside the target directory"},
+		{"absolute symlink", []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}}, "refusing link"},
+		{"relative symlink out", []tar.Header{{Name: "base/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}}, "refusing link"},
+		{"hardlink", []tar.Header{{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}}, "refusing link"},
+		{
+			// Each link stays inside on its own, together they lead out
+			name: "chain of symlinks",
+			headers: []tar.Header{
+				{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "s/x", Typeflag: tar.TypeSymlink, Linkname: ".."},
+				{Name: "s/x/pwned", Typeflag: tar.TypeReg, Mode: 0600},
+			},
+			want: "refusing link",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "evil.tar")
+			file, _ := os.Create(archive)
+			tw := tar.NewWriter(file)
+			for _, header := range tt.headers {
+				if err := tw.WriteHeader(&header); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tw.Close()
+			file.Close()
+
+			parent := t.TempDir()
+			err := extractTar(archive, filepath.Join(parent, "restored"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("extractTar = %v, want %q", err, tt.want)
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "pwned")); err == nil {
+				t.Fatal("entry written outside the target directory")
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// fakeClickHouse is a stand-in for the HTTP interface that serves a fixed
+// table list and records every other statement
+type fakeClickHouse struct {
+	t        *testing.T
+	mu       sync.Mutex
+	tables   []map[string]string // system.tables rows
+	rows     map[string]string   // Native data by table name
+	stmts    []string
+	inserted map[string]string // Native data by qualified table
+}
+
+func newFakeClickHouse(t *testing.T) (*fakeClickHouse, config.DatabaseConfig) {
+	t.Helper()
+	f := &fakeClickHouse{t: t, rows: map[string]string{}, inserted: map[string]string{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	return f, config.DatabaseConfig{Type: "clickhouse", Host: host, Port: p, User: "default", DBName: "app"}
+}
+
+func (f *fakeClickHouse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stmt := r.URL.Query().Get("query")
+	var input []byte
+	if stmt == "" {
+		data, _ := io.ReadAll(r.Body)
+		stmt = string(data)
+	} else {
+		input, _ = io.ReadAll(r.Body)
+	}
+
+	switch {
+	case stmt == "SELECT 1":
+		io.WriteString(w, "1\n")
+	case strings.HasPrefix(stmt, "SELECT name, engine, create_table_query FROM system.tables"):
+		if db := r.URL.Query().Get("param_db"); db != "app" {
+			f.t.Errorf("listed tables of %q", db)
+		}
+		for _, row := range f.tables {
+			json.NewEncoder(w).Encode(row)
+		}
+	case strings.HasPrefix(stmt, "SELECT * FROM `app`."):
+		name := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(stmt, "SELECT * FROM `app`."), " FORMAT Native"), "`")
+		io.WriteString(w, f.rows[name])
+	case strings.HasPrefix(stmt, "INSERT INTO "):
+		f.inserted[strings.TrimSuffix(strings.TrimPrefix(stmt, "INSERT INTO "), " FORMAT Native")] = string(input)
+	default:
+		f.stmts = append(f.stmts, stmt)
+	}
+}
+
+func TestClickHouseDeleteBackup(t *testing.T) {
+	backup := []string{
+		"clickhouse/app/app_20260102_150405/.backup",
+		"clickhouse/app/app_20260102_150405/data/app/events/all_1_1_0/data.bin",
+	}
+	kept := []string{
+		"clickhouse/app/app_20260102_150405_old/.backup",
+		"clickhouse/app/app_20260109_150405/.backup",
+		"backup_clickhouse_app_20260102_150405.json",
+	}
+	aws := NewClickHouse(config.DatabaseConfig{DBName: "app"}, config.StorageConfig{Type: "s3", Path: "backups", Region: "eu-west-1"})
+	minio := NewClickHouse(config.DatabaseConfig{DBName: "app"}, config.StorageConfig{Type: "s3", Path: "backups", S3: config.S3Config{Endpoint: "http://minio:9000/"}})
+	s3URL := func(u string) map[string]string { return map[string]string{"s3_url": u} }
+
+	testDeleteBackup(t, append(backup, kept...), []deleteBackupCase{
+		{"s3 backup", aws, &Metadata{Provider: chProviderName, Properties: s3URL("https://backups.s3.eu-west-1.amazonaws.com/clickhouse/app/app_20260102_150405/")}, backup},
+		{"s3 backup behind an endpoint", minio, &Metadata{Provider: chProviderName, Properties: s3URL("http://minio:9000/backups/clickhouse/app/app_20260102_150405/")}, backup},
+		{"backup in another bucket", aws, &Metadata{Provider: chProviderName, Properties: s3URL("https://other.s3.eu-west-1.amazonaws.com/clickhouse/app/app_20260102_150405/")}, nil},
+		{"backup disk", aws, &Metadata{Provider: chProviderName, Properties: map[string]string{"disk": "backups", "path": "app/app_20260102_150405"}}, nil},
+		{"other provider", aws, &Metadata{Provider: esProviderName, Properties: s3URL("https://backups.s3.eu-west-1.amazonaws.com/clickhouse/app/app_20260102_150405/")}, nil},
+	})
+}
+
+func TestClickHouseBaseBackup(t *testing.T) {
+	inc := &Metadata{Artifact: "inc", Provider: chProviderName, Database: "app", Type: "incremental"}
+	full := &Metadata{Artifact: "full", Provider: chProviderName, Database: "app", Type: "full"}
+	other := &Metadata{Artifact: "other", Provider: chProviderName, Database: "billing", Type: "full"}
+	previous := []*Metadata{other, inc, full}
+
+	c := NewClickHouse(config.DatabaseConfig{DBName: "app"}, config.StorageConfig{})
+	for backupType, want := range map[string]*Metadata{"full": nil, "incremental": inc, "differential": full} {
+		if got := c.baseBackup(backupType, previous); got != want {
+			t.Errorf("%s backup based on %+v, want %+v", backupType, got, want)
+		}
+	}
+}
+
+func TestClickHouseDumpAndRestore(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake, cfg := newFakeClickHouse(t)
+	fake.tables = []map[string]string{
+		{"name": ".inner_id.5f2c", "engine": "MergeTree", "create_table_query": "CREATE TABLE app.`.inner_id.5f2c` (`day` Date, `n` UInt64) ENGINE = MergeTree ORDER BY day"},
+		{"name": "by_day", "engine": "MaterializedView", "create_table_query": "CREATE MATERIALIZED VIEW app.by_day (`day` Date, `n` UInt64) ENGINE = MergeTree ORDER BY day AS SELECT toDate(ts) AS day, count() AS n FROM app.events GROUP BY day"},
+		{"name": "daily", "engine": "SummingMergeTree", "create_table_query": "CREATE TABLE app.daily (`day` Date, `n` UInt64) ENGINE = SummingMergeTree ORDER BY day"},
+		{"name": "events", "engine": "MergeTree", "create_table_query": "CREATE TABLE app.events (`ts` DateTime) ENGINE = MergeTree ORDER BY ts"},
+		{"name": "events view", "engine": "View", "create_table_query": "CREATE VIEW app.`events view` (`ts` DateTime) AS SELECT ts FROM app.events"},
+		{"name": "to_daily", "engine": "MaterializedView", "create_table_query": "CREATE MATERIALIZED VIEW app.to_daily TO app.daily (`day` Date, `n` UInt64) AS SELECT toDate(ts) AS day, count() AS n FROM app.events GROUP BY day"},
+		{"name": "dict", "engine": "Dictionary", "create_table_query": "CREATE DICTIONARY app.dict (`id` UInt64) PRIMARY KEY id SOURCE(NULL()) LAYOUT(FLAT()) LIFETIME(0)"},
+	}
+	fake.rows = map[string]string{"events": "events-rows", "daily": "daily-rows", "by_day": "by-day-rows", ".inner_id.5f2c": "inner-rows"}
+
+	// No backup disk and no s3 storage: the dump fallback
+	file, meta, err := NewClickHouse(cfg, config.StorageConfig{Type: "local", Path: t.TempDir()}).BackupWithMetadata("full", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta != nil || !strings.HasPrefix(file, "backup_clickhouse_app_") || !strings.HasSuffix(file, chDumpSuffix) {
+		t.Fatalf("dump %s with metadata %+v", file, meta)
+	}
+
+	// Restore under another name, as with --dbname
+	cfg.DBName = "app_restored"
+	if err := NewClickHouse(cfg, config.StorageConfig{Type: "local"}).Restore(file); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"CREATE DATABASE IF NOT EXISTS `app_restored`",
+		"DROP TABLE IF EXISTS `app_restored`.`daily`",
+		"CREATE TABLE `app_restored`.daily (`day` Date, `n` UInt64) ENGINE = SummingMergeTree ORDER BY day",
+		"DROP TABLE IF EXISTS `app_restored`.`events`",
+		"CREATE TABLE `app_restored`.events (`ts` DateTime) ENGINE = MergeTree ORDER BY ts",
+		"DROP TABLE IF EXISTS `app_restored`.`by_day`",
+		"CREATE MATERIALIZED VIEW `app_restored`.by_day (`day` Date, `n` UInt64) ENGINE = MergeTree ORDER BY day AS SELECT toDate(ts) AS day, count() AS n FROM app.events GROUP BY day",
+		"DROP TABLE IF EXISTS `app_restored`.`events view`",
+		"CREATE VIEW `app_restored`.`events view` (`ts` DateTime) AS SELECT ts FROM app.events",
+		"DROP TABLE IF EXISTS `app_restored`.`to_daily`",
+		"CREATE MATERIALIZED VIEW `app_restored`.to_daily TO app.daily (`day` Date, `n` UInt64) AS SELECT toDate(ts) AS day, count() AS n FROM app.events GROUP BY day",
+		"DROP DICTIONARY IF EXISTS `app_restored`.`dict`",
+		"CREATE DICTIONARY `app_restored`.dict (`id` UInt64) PRIMARY KEY id SOURCE(NULL()) LAYOUT(FLAT()) LIFETIME(0)",
+	}
+	if got := strings.Join(fake.stmts, "\n"); got != strings.Join(want, "\n") {
+		t.Fatalf("statements:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	// The inner table's rows come back through the view, the TO view's with daily
+	wantInserted := map[string]string{
+		"`app_restored`.`daily`":  "daily-rows",
+		"`app_restored`.`events`": "events-rows",
+		"`app_restored`.`by_day`": "by-day-rows",
+	}
+	if len(fake.inserted) != len(wantInserted) {
+		t.Fatalf("inserted into %v", fake.inserted)
+	}
+	for table, rows := range wantInserted {
+		if fake.inserted[table] != rows {
+			t.Errorf("%s got %q, want %q", table, fake.inserted[table], rows)
+		}
+	}
+}
+
+func TestClickHouseHasData(t *testing.T) {
+	tests := []struct {
+		engine string
+		create string
+		want   bool
+	}{
+		{"MergeTree", "CREATE TABLE app.events (`ts` DateTime) ENGINE = MergeTree ORDER BY ts", true},
+		{"View", "CREATE VIEW app.v AS SELECT 1", false},
+		{"Distributed", "CREATE TABLE app.d AS app.events ENGINE = Distributed(c, app, events)", false},
+		{"MaterializedView", "CREATE MATERIALIZED VIEW app.mv (`n` UInt64) ENGINE = MergeTree ORDER BY n AS SELECT 1 AS n", true},
+		{"MaterializedView", "CREATE MATERIALIZED VIEW app.mv TO app.dst AS SELECT 1 AS n", false},
+		{"MaterializedView", "CREATE MATERIALIZED VIEW `my db`.`my view` TO `my db`.dst (`n` UInt64) AS SELECT 1 AS n", false},
+		{"MaterializedView", "CREATE MATERIALIZED VIEW app.mv (`n` UInt64) ENGINE = MergeTree ORDER BY n AS SELECT 1 AS n FROM app.src WHERE x = 'TO '", true},
+	}
+	for _, tt := range tests {
+		if got := chHasData(tt.engine, tt.create); got != tt.want {
+			t.Errorf("chHasData(%s, %q) = %v, want %v", tt.engine, tt.create, got, tt.want)
+		}
+	}
+}
+
+func TestClickHouseCreateName(t *testing.T) {
+	tests := []struct {
+		ddl  string
+		db   string
+		want string
+	}{
+		{"CREATE TABLE app.events (`ts` DateTime) ENGINE = MergeTree ORDER BY ts", "`copy`", "CREATE TABLE `copy`.events (`ts` DateTime) ENGINE = MergeTree ORDER BY ts"},
+		{"CREATE TABLE `my.db`.`my table` (x UInt8) ENGINE = Log", "`copy`", "CREATE TABLE `copy`.`my table` (x UInt8) ENGINE = Log"},
+		{"CREATE MATERIALIZED VIEW app.mv TO app.dst AS SELECT 1", "`copy`", "CREATE MATERIALIZED VIEW `copy`.mv TO app.dst AS SELECT 1"},
+		{"CREATE LIVE VIEW app.lv AS SELECT 1", "`copy`", "CREATE LIVE VIEW `copy`.lv AS SELECT 1"},
+		{"CREATE DICTIONARY app.dict (id UInt64) PRIMARY KEY id", "`copy`", "CREATE DICTIONARY `copy`.dict (id UInt64) PRIMARY KEY id"},
+		// $ in the new name is not a regexp group reference
+		{"CREATE TABLE app.t (x UInt8) ENGINE = Log", "`a$1`", "CREATE TABLE `a$1`.t (x UInt8) ENGINE = Log"},
+	}
+	for _, tt := range tests {
+		got := chCreateName.ReplaceAllString(tt.ddl, "${1}"+strings.ReplaceAll(tt.db, "$", "$$")+".")
+		if got != tt.want {
+			t.Errorf("renamed %q to %q, want %q", tt.ddl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/antigravity/dbbackup/internal/storage"
+	"github.com/lib/pq"
+)
+
+const (
+	crdbManifestFormat = "cockroachdb-backup"
+	crdbProviderName   = "cockroachdb"
+)
+
+// CockroachDB backs up with CockroachDB's native BACKUP, which the cluster
+// writes straight into a collection in cloud or node-local storage. The
+// artifact the manager uploads is a small manifest pointing at the backup in
+// the collection.
+type CockroachDB struct {
+	Config  config.DatabaseConfig
+	Storage config.StorageConfig
+	conn    *sql.DB
+}
+
+// crdbManifest is the artifact of a CockroachDB backup
+type crdbManifest struct {
+	Format     string    `json:"format"`
+	Database   string    `json:"database"`
+	Collection string    `json:"collection"` // without query parameters, which may hold credentials
+	Path       string    `json:"path"`       // full backup subdirectory in the collection
+	EndTime    time.Time `json:"end_time"`   // AS OF SYSTEM TIME of this backup
+	Type       string    `json:"type"`       // full or incremental
+	JobID      int64     `json:"job_id"`
+	Rows       int64     `json:"rows"`
+	Bytes      int64     `json:"bytes"`
+}
+
+func NewCockroachDB(cfg config.DatabaseConfig, st config.StorageConfig) *CockroachDB {
+	return &CockroachDB{Config: cfg, Storage: st}
+}
+
+func (c *CockroachDB) Connect() error {
+	// BACKUP and RESTORE name the database themselves, and restore must work
+	// before it exists
+	db, err := sql.Open("postgres", pgDSN(c.Config, "defaultdb"))
+	if err != nil {
+		return err
+	}
+	c.conn = db
+	return nil
+}
+
+func (c *CockroachDB) TestConnection() error {
+	if c.conn == nil {
+		if err := c.Connect(); err != nil {
+			return err
+		}
+	}
+	return c.conn.Ping()
+}
+
+func (c *CockroachDB) Backup(backupType string) (string, error) {
+	filename, _, err := c.BackupWithMetadata(backupType, nil)
+	return filename, err
+}
+
+// BackupWithMetadata takes a full backup into a new subdirectory of the
+// collection, or an incremental one on top of the latest full backup (INTO
+// LATEST IN). An incremental is based on the previous backup in its chain.
+func (c *CockroachDB) BackupWithMetadata(backupType string, previous []*Metadata) (string, *Metadata, error) {
+	if err := c.TestConnection(); err != nil {
+		return "", nil, err
+	}
+	collection, err := c.collection()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// A fixed AS OF SYSTEM TIME is what lets restore pick this backup out of
+	// the chain later
+	var now time.Time
+	if err := c.conn.QueryRow("SELECT now()").Scan(&now); err != nil {
+		return "", nil, err
+	}
+	endTime := now
+	if c.Config.CockroachDB.AsOf != "" {
+		offset, err := time.ParseDuration(c.Config.CockroachDB.AsOf)
+		if err != nil || offset > 0 {
+			return "", nil, fmt.Errorf("invalid cockroachdb.as_of %q, use a negative duration like -10s", c.Config.CockroachDB.AsOf)
+		}
+		endTime = now.Add(offset)
+	}
+
+	into := "INTO $1"
+	manifestType := "full"
+	if backupType != "full" {
+		into = "INTO LATEST IN $1"
+		manifestType = "incremental"
+	}
+	stmt := fmt.Sprintf("BACKUP DATABASE %s %s AS OF SYSTEM TIME %s",
+		pq.QuoteIdentifier(c.Config.DBName), into, pq.QuoteLiteral(crdbTimestamp(endTime)))
+	if c.Config.CockroachDB.RevisionHistory {
+		stmt += " WITH revision_history"
+	}
+
+	manifest := crdbManifest{
+		Format:     crdbManifestFormat,
+		Database:   c.Config.DBName,
+		Collection: stripQuery(collection),
+		EndTime:    endTime.UTC(),
+		Type:       manifestType,
+	}
+	var status string
+	var fraction float64
+	var indexEntries int64
+	err = c.conn.QueryRow(stmt, collection).Scan(&manifest.JobID, &status, &fraction, &manifest.Rows, &indexEntries, &manifest.Bytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("cockroachdb backup failed: %v", logger.Redact(err.Error()))
+	}
+
+	// The backup went into the newest full backup subdirectory
+	if manifest.Path, err = c.latestBackup(collection); err != nil {
+		return "", nil, err
+	}
+
+	filename := fmt.Sprintf("backup_crdb_%s_%s.json", c.Config.DBName, time.Now().Format("20060102_150405"))
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		return "", nil, err
+	}
+
+	meta := &Metadata{
+		Provider: crdbProviderName,
+		Database: c.Config.DBName,
+		Type:     manifest.Type,
+		Created:  time.Now().UTC(),
+		Properties: map[string]string{
+			"collection": manifest.Collection,
+			"path":       manifest.Path,
+			"end_time":   manifest.EndTime.Format(time.RFC3339Nano),
+		},
+	}
+	if manifest.Type != "full" {
+		meta.Base = crdbBase(previous, c.Config.DBName, manifest.Path)
+	}
+	return filename, meta, nil
+}
+
+// crdbBase returns the artifact of the newest earlier backup in the chain of
+// the full backup at fullPath, or "" if it has no metadata
+func crdbBase(previous []*Metadata, dbName, fullPath string) string {
+	for _, meta := range previous {
+		if meta.Provider == crdbProviderName && meta.Database == dbName && meta.Properties["path"] == fullPath {
+			return meta.Artifact
+		}
+	}
+	return ""
+}
+
+// latestBackup returns the newest full backup subdirectory in the collection
+func (c *CockroachDB) latestBackup(collection string) (string, error) {
+	rows, err := c.conn.Query("SHOW BACKUPS IN $1", collection)
+	if err != nil {
+		return "", fmt.Errorf("failed to list backups: %v", logger.Redact(err.Error()))
+	}
+	defer rows.Close()
+
+	latest := ""
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return "", err
+		}
+		// Subdirectories are named after their time, e.g. /2026/01/02-150405.00
+		if p > latest {
+			latest = p
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no backups found in the collection")
+	}
+	return latest, nil
+}
+
+// Restore restores the backup a manifest points at. The database must not
+// exist; restoring under another name than the backed up one (--dbname) uses
+// new_db_name.
+func (c *CockroachDB) Restore(backupFile string) error {
+	data, err := os.ReadFile(backupFile)
+	if err != nil {
+		return err
+	}
+	var manifest crdbManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Format != crdbManifestFormat {
+		return fmt.Errorf("%s is not a cockroachdb backup manifest", backupFile)
+	}
+
+	collection, err := c.collection()
+	if err != nil {
+		return err
+	}
+	if stripQuery(collection) != manifest.Collection {
+		logger.Warn("Backup was taken into another collection, restoring from the configured one", "backup_collection", manifest.Collection, "collection", stripQuery(collection))
+	}
+
+	stmt := fmt.Sprintf("RESTORE DATABASE %s FROM $1 IN $2 AS OF SYSTEM TIME %s",
+		pq.QuoteIdentifier(manifest.Database), pq.QuoteLiteral(crdbTimestamp(manifest.EndTime)))
+	if c.Config.DBName != manifest.Database {
+		stmt += " WITH new_db_name = " + pq.QuoteLiteral(c.Config.DBName)
+	}
+	return c.restore(stmt, manifest.Path, collection)
+}
+
+// RestoreToPoint restores the database as of a time from the latest backup
+// chain. Any time inside a chain needs backups taken with revision_history;
+// without it only the end times of the backups work.
+func (c *CockroachDB) RestoreToPoint(bookmark string, at time.Time) error {
+	if bookmark != "" {
+		return fmt.Errorf("cockroachdb has no bookmarks, use --to-time")
+	}
+	collection, err := c.collection()
+	if err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf("RESTORE DATABASE %s FROM LATEST IN $1 AS OF SYSTEM TIME %s",
+		pq.QuoteIdentifier(c.Config.DBName), pq.QuoteLiteral(crdbTimestamp(at)))
+	return c.restore(stmt, collection)
+}
+
+// HasBackup is always false: CockroachDB restores any point it can reach
+// itself, there is nothing to fall back to
+func (c *CockroachDB) HasBackup(meta *Metadata) bool {
+	return false
+}
+
+// restore runs a RESTORE statement once it's clear the database doesn't exist
+func (c *CockroachDB) restore(stmt string, args ...any) error {
+	if err := c.TestConnection(); err != nil {
+		return err
+	}
+
+	var count int
+	if err := c.conn.QueryRow("SELECT count(*) FROM [SHOW DATABASES] WHERE database_name = $1", c.Config.DBName).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("database %s already exists; drop it first or restore under another name with --dbname", c.Config.DBName)
+	}
+
+	if _, err := c.conn.Exec(stmt, args...); err != nil {
+		return fmt.Errorf("cockroachdb restore failed: %v", logger.Redact(err.Error()))
+	}
+	return nil
+}
+
+// collection returns the configured collection URI, or one derived from the
+// s3 or gcs storage settings under cockroach/<dbname> in the bucket
+func (c *CockroachDB) collection() (string, error) {
+	if c.Config.CockroachDB.Collection != "" {
+		return c.Config.CockroachDB.Collection, nil
+	}
+
+	st := c.Storage
+	query := url.Values{}
+	u := url.URL{Host: st.Path, Path: path.Join("/cockroach", c.Config.DBName)}
+	switch st.Type {
+	case "s3":
+		u.Scheme = "s3"
+		if st.S3.AccessKeyID != "" {
+			query.Set("AUTH", "specified")
+			query.Set("AWS_ACCESS_KEY_ID", st.S3.AccessKeyID)
+			query.Set("AWS_SECRET_ACCESS_KEY", st.S3.SecretAccessKey)
+			if st.S3.SessionToken != "" {
+				query.Set("AWS_SESSION_TOKEN", st.S3.SessionToken)
+			}
+		} else {
+			query.Set("AUTH", "implicit")
+		}
+		if st.Region != "" {
+			query.Set("AWS_REGION", st.Region)
+		}
+		if st.S3.Endpoint != "" {
+			query.Set("AWS_ENDPOINT", st.S3.Endpoint)
+		}
+		if st.S3.RoleARN != "" {
+			query.Set("ASSUME_ROLE", st.S3.RoleARN)
+		}
+	case "gcs":
+		u.Scheme = "gs"
+		if st.CredentialsFile != "" {
+			key, err := os.ReadFile(st.CredentialsFile)
+			if err != nil {
+				return "", fmt.Errorf("failed to read credentials file: %v", err)
+			}
+			encoded := base64.StdEncoding.EncodeToString(key)
+			logger.AddSecret(encoded)
+			query.Set("AUTH", "specified")
+			query.Set("CREDENTIALS", encoded)
+		} else {
+			query.Set("AUTH", "implicit")
+		}
+	default:
+		return "", fmt.Errorf("set database.cockroachdb.collection, it can't be derived from %s storage", st.Type)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// DeleteBackup deletes the data behind a pruned full backup: its subdirectory
+// of the collection and the incrementals on top of it, which go with it.
+// Retention keeps a full backup as long as a newer incremental builds on it.
+// Only collections in the storage bucket can be cleaned up this way.
+func (c *CockroachDB) DeleteBackup(st storage.Storage, meta *Metadata) error {
+	if meta.Provider != crdbProviderName || meta.Type != "full" || meta.Properties["path"] == "" {
+		return nil
+	}
+	prefix, ok := c.storagePrefix(meta.Properties["collection"])
+	if !ok {
+		logger.Warn("Backup collection is outside the storage bucket, its data must be deleted there", "collection", meta.Properties["collection"], "path", meta.Properties["path"])
+		return nil
+	}
+
+	subdir := strings.Trim(meta.Properties["path"], "/")
+	for _, dir := range []string{path.Join(prefix, subdir), path.Join(prefix, "incrementals", subdir)} {
+		files, err := st.List(dir + "/")
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %v", dir, err)
+		}
+		for _, file := range files {
+			if err := st.Delete(file); err != nil {
+				return fmt.Errorf("failed to delete %s: %v", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// storagePrefix returns the key prefix of a collection in the storage bucket,
+// or false for a collection elsewhere
+func (c *CockroachDB) storagePrefix(collection string) (string, bool) {
+	u, err := url.Parse(collection)
+	if err != nil || u.Host != c.Storage.Path {
+		return "", false
+	}
+	if !(u.Scheme == "s3" && c.Storage.Type == "s3") && !(u.Scheme == "gs" && c.Storage.Type == "gcs") {
+		return "", false
+	}
+	return strings.Trim(u.Path, "/"), true
+}
+
+// crdbTimestamp formats a time for AS OF SYSTEM TIME
+func crdbTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.999999")
+}
+
+// stripQuery drops the query parameters, and with them any credentials, from a URI
+func stripQuery(uri string) string {
+	if i := strings.Index(uri, "?"); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+func (c *CockroachDB) Close() error {
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+)
+
+func TestCockroachDBStoragePrefix(t *testing.T) {
+	tests := []struct {
+		storage    config.StorageConfig
+		collection string
+		want       string
+		ok         bool
+	}{
+		{config.StorageConfig{Type: "s3", Path: "backups"}, "s3://backups/cockroach/app", "cockroach/app", true},
+		{config.StorageConfig{Type: "gcs", Path: "backups"}, "gs://backups/cockroach/app", "cockroach/app", true},
+		{config.StorageConfig{Type: "s3", Path: "backups"}, "s3://backups", "", true},
+		{config.StorageConfig{Type: "s3", Path: "backups"}, "s3://other-bucket/cockroach/app", "", false},
+		{config.StorageConfig{Type: "gcs", Path: "backups"}, "s3://backups/cockroach/app", "", false},
+		{config.StorageConfig{Type: "local", Path: "/var/backups"}, "nodelocal://1/backups", "", false},
+	}
+	for _, tt := range tests {
+		c := NewCockroachDB(config.DatabaseConfig{DBName: "app"}, tt.storage)
+		got, ok := c.storagePrefix(tt.collection)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("storagePrefix(%q) with %s storage = %q, %v", tt.collection, tt.storage.Type, got, ok)
+		}
+	}
+}
+
+func TestCockroachDBDeleteBackup(t *testing.T) {
+	chain := []string{
+		"cockroach/app/2026/01/02-150405.00/BACKUP_MANIFEST",
+		"cockroach/app/2026/01/02-150405.00/data/1.sst",
+		"cockroach/app/incrementals/2026/01/02-150405.00/20260103/150405.00-20260102-150405.00/BACKUP_MANIFEST",
+	}
+	kept := []string{
+		"cockroach/app/2026/01/09-150405.00/BACKUP_MANIFEST",
+		"cockroach/app/incrementals/2026/01/09-150405.00/20260110/150405.00-20260109-150405.00/BACKUP_MANIFEST",
+		"cockroach/app/2026/01/02-150405.001/BACKUP_MANIFEST",
+		"backup_crdb_app_20260102_150405.json",
+	}
+	c := NewCockroachDB(config.DatabaseConfig{DBName: "app"}, config.StorageConfig{Type: "s3", Path: "backups"})
+	inBucket := map[string]string{"collection": "s3://backups/cockroach/app", "path": "/2026/01/02-150405.00"}
+	nodeLocal := map[string]string{"collection": "nodelocal://1/backups", "path": "/2026/01/02-150405.00"}
+
+	testDeleteBackup(t, append(chain, kept...), []deleteBackupCase{
+		{"full backup and its incrementals", c, &Metadata{Provider: crdbProviderName, Type: "full", Properties: inBucket}, chain},
+		{"incrementals go with their full backup", c, &Metadata{Provider: crdbProviderName, Type: "incremental", Properties: inBucket}, nil},
+		{"collection outside the bucket", c, &Metadata{Provider: crdbProviderName, Type: "full", Properties: nodeLocal}, nil},
+		{"other provider", c, &Metadata{Provider: esProviderName, Type: "full", Properties: inBucket}, nil},
+	})
+}
+
+func TestCockroachDBBase(t *testing.T) {
+	chainPath := "/2026/01/02-150405.00"
+	previous := []*Metadata{
+		{Artifact: "other-db", Provider: crdbProviderName, Database: "billing", Properties: map[string]string{"path": chainPath}},
+		{Artifact: "inc1", Provider: crdbProviderName, Database: "app", Type: "incremental", Properties: map[string]string{"path": chainPath}},
+		{Artifact: "full", Provider: crdbProviderName, Database: "app", Type: "full", Properties: map[string]string{"path": chainPath}},
+		{Artifact: "older-chain", Provider: crdbProviderName, Database: "app", Type: "full", Properties: map[string]string{"path": "/2025/12/26-150405.00"}},
+	}
+	if got := crdbBase(previous, "app", chainPath); got != "inc1" {
+		t.Errorf("crdbBase = %q, want inc1", got)
+	}
+	if got := crdbBase(previous, "app", "/2026/01/09-150405.00"); got != "" {
+		t.Errorf("crdbBase of a new chain = %q", got)
+	}
+}
+
+// newFakeCockroachDB returns a provider on a fake server that answers
+// SELECT now() and SHOW BACKUPS, and runs BACKUP with backup
+func newFakeCockroachDB(t *testing.T, backup func(args []driver.NamedValue) (*fakeRows, error)) (*CockroachDB, *fakeSQLServer) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	fake := &fakeSQLServer{
+		query: func(stmt string, args []driver.NamedValue) (*fakeRows, error) {
+			switch {
+			case stmt == "SELECT now()":
+				return &fakeRows{columns: []string{"now"}, rows: [][]driver.Value{{now}}}, nil
+			case strings.HasPrefix(stmt, "BACKUP DATABASE"):
+				return backup(args)
+			case stmt == "SHOW BACKUPS IN $1":
+				return &fakeRows{columns: []string{"path"}, rows: [][]driver.Value{{"/2025/12/26-150405.00"}, {"/2026/01/02-150405.00"}}}, nil
+			}
+			return nil, fmt.Errorf("unexpected query %s", stmt)
+		},
+	}
+	c := NewCockroachDB(config.DatabaseConfig{
+		Type:        "cockroachdb",
+		DBName:      "app",
+		CockroachDB: config.CockroachDBConfig{AsOf: "-10s"},
+	}, config.StorageConfig{Type: "s3", Path: "backups", S3: config.S3Config{AccessKeyID: "AKID", SecretAccessKey: "crdb-secret"}})
+	c.conn = sql.OpenDB(fake)
+	t.Cleanup(func() { c.Close() })
+	return c, fake
+}
+
+func TestCockroachDBBackup(t *testing.T) {
+	c, fake := newFakeCockroachDB(t, func(args []driver.NamedValue) (*fakeRows, error) {
+		return &fakeRows{
+			columns: []string{"job_id", "status", "fraction_completed", "rows", "index_entries", "bytes"},
+			rows:    [][]driver.Value{{int64(42), "succeeded", 1.0, int64(100), int64(0), int64(2048)}},
+		}, nil
+	})
+	full := &Metadata{Artifact: "full", Provider: crdbProviderName, Database: "app", Type: "full", Properties: map[string]string{"path": "/2026/01/02-150405.00"}}
+
+	file, meta, err := c.BackupWithMetadata("incremental", []*Metadata{full})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := fake.executed("BACKUP DATABASE")
+	if want := `BACKUP DATABASE "app" INTO LATEST IN $1 AS OF SYSTEM TIME '2026-01-02 15:03:55'`; len(stmts) != 1 || stmts[0] != want {
+		t.Fatalf("ran %q, want %q", stmts, want)
+	}
+	if meta.Type != "incremental" || meta.Base != "full" || meta.Properties["path"] != "/2026/01/02-150405.00" || meta.Properties["collection"] != "s3://backups/cockroach/app" {
+		t.Fatalf("metadata %+v", meta)
+	}
+
+	var manifest crdbManifest
+	data, _ := os.ReadFile(file)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.JobID != 42 || manifest.Rows != 100 || manifest.Bytes != 2048 || strings.Contains(string(data), "crdb-secret") {
+		t.Fatalf("manifest %s", data)
+	}
+}
+
+func TestCockroachDBBackupFails(t *testing.T) {
+	c, fake := newFakeCockroachDB(t, func(args []driver.NamedValue) (*fakeRows, error) {
+		// The error names the collection, credentials included
+		return nil, errors.New("failed to write to " + args[0].Value.(string) + ": AccessDenied")
+	})
+	logger.AddSecret("crdb-secret")
+
+	_, _, err := c.BackupWithMetadata("full", nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "cockroachdb backup failed: ") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("BackupWithMetadata = %v", err)
+	}
+	if strings.Contains(err.Error(), "crdb-secret") {
+		t.Fatalf("error leaks the secret key: %v", err)
+	}
+	if len(fake.executed("SHOW BACKUPS")) != 0 {
+		t.Fatal("looked for the backup after BACKUP failed")
+	}
+	if entries, _ := os.ReadDir("."); len(entries) != 0 {
+		t.Fatalf("left files behind: %v", entries)
+	}
+}
+
+// TestCockroachDBSingleNode runs against `cockroach start-single-node
+// --insecure --external-io-dir=<dir>` when DBBACKUP_TEST_CRDB_HOST is set.
+// The collection defaults to nodelocal://1/dbbackup-test.
+func TestCockroachDBSingleNode(t *testing.T) {
+	host := os.Getenv("DBBACKUP_TEST_CRDB_HOST")
+	if host == "" {
+		t.Skip("DBBACKUP_TEST_CRDB_HOST not set")
+	}
+	port := 26257
+	if p := os.Getenv("DBBACKUP_TEST_CRDB_PORT"); p != "" {
+		port, _ = strconv.Atoi(p)
+	}
+	collection := os.Getenv("DBBACKUP_TEST_CRDB_COLLECTION")
+	if collection == "" {
+		collection = fmt.Sprintf("nodelocal://1/dbbackup-test/%d", time.Now().UnixNano())
+	}
+	t.Chdir(t.TempDir())
+
+	dbName := fmt.Sprintf("dbbackup_test_%d", time.Now().UnixNano())
+	cfg := config.DatabaseConfig{
+		Type:        "cockroachdb",
+		Host:        host,
+		Port:        port,
+		User:        "root",
+		DBName:      dbName,
+		CockroachDB: config.CockroachDBConfig{Collection: collection},
+	}
+	admin, err := sql.Open("postgres", pgDSN(cfg, "defaultdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	exec := func(stmt string) {
+		t.Helper()
+		if _, err := admin.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	exec("CREATE DATABASE " + dbName)
+	exec("CREATE TABLE " + dbName + ".t (id INT PRIMARY KEY)")
+	exec("INSERT INTO " + dbName + ".t VALUES (1)")
+	defer exec("DROP DATABASE IF EXISTS " + dbName + " CASCADE")
+	defer exec("DROP DATABASE IF EXISTS " + dbName + "_restored CASCADE")
+
+	c := NewCockroachDB(cfg, config.StorageConfig{Type: "local", Path: t.TempDir()})
+	defer c.Close()
+	fullFile, fullMeta, err := c.BackupWithMetadata("full", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullMeta.Artifact = fullFile
+
+	exec("INSERT INTO " + dbName + ".t VALUES (2)")
+	incFile, incMeta, err := c.BackupWithMetadata("incremental", []*Metadata{fullMeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incMeta.Type != "incremental" || incMeta.Base != fullFile || incMeta.Properties["path"] != fullMeta.Properties["path"] {
+		t.Fatalf("incremental metadata %+v, full %+v", incMeta, fullMeta)
+	}
+
+	var manifest crdbManifest
+	data, _ := os.ReadFile(incFile)
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Format != crdbManifestFormat {
+		t.Fatalf("manifest %s: %v", data, err)
+	}
+
+	for file, rows := range map[string]int{fullFile: 1, incFile: 2} {
+		restored := NewCockroachDB(config.DatabaseConfig{
+			Type: "cockroachdb", Host: host, Port: port, User: "root",
+			DBName:      dbName + "_restored",
+			CockroachDB: cfg.CockroachDB,
+		}, c.Storage)
+		if err := restored.Restore(file); err != nil {
+			t.Fatalf("restoring %s: %v", file, err)
+		}
+		restored.Close()
+
+		var count int
+		if err := admin.QueryRow("SELECT count(*) FROM " + dbName + "_restored.t").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != rows {
+			t.Errorf("%s restored %d rows, want %d", file, count, rows)
+		}
+		exec("DROP DATABASE " + dbName + "_restored CASCADE")
+	}
+}
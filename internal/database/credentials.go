@@ -0,0 +1,83 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// writeOptionFile writes content to a new temporary file that only the
+// current user can read, for tools that would otherwise need the password on
+// the command line. The returned function removes the file.
+func writeOptionFile(pattern, content string) (string, func(), error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create option file: %v", err)
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+
+	// CreateTemp already uses 0600, but don't rely on it for a file holding a password
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return file.Name(), cleanup, nil
+}
+
+// mysqlOptionFile writes a [client] option file with the connection and TLS settings,
+// passed to mysqldump and mysql with --defaults-extra-file.
+func mysqlOptionFile(cfg config.DatabaseConfig) (string, func(), error) {
+	var b strings.Builder
+	b.WriteString("[client]\n")
+	fmt.Fprintf(&b, "host=%s\n", mysqlOptionValue(cfg.Host))
+	fmt.Fprintf(&b, "port=%d\n", cfg.Port)
+	fmt.Fprintf(&b, "user=%s\n", mysqlOptionValue(cfg.User))
+	fmt.Fprintf(&b, "password=%s\n", mysqlOptionValue(cfg.Password))
+	for _, line := range mysqlTLSOptions(cfg.TLS) {
+		b.WriteString(line + "\n")
+	}
+	return writeOptionFile("dbbackup-mysql-*.cnf", b.String())
+}
+
+// mysqlOptionValue quotes a value for a MySQL option file
+func mysqlOptionValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
+
+// mongoConfigFile writes the YAML file passed to mongodump and mongorestore
+// with --config, which is the only way to keep the connection string and its
+// password off the command line.
+func mongoConfigFile(uri string) (string, func(), error) {
+	// A JSON string is a valid double-quoted YAML scalar
+	quoted, err := json.Marshal(uri)
+	if err != nil {
+		return "", nil, err
+	}
+	return writeOptionFile("dbbackup-mongo-*.yaml", fmt.Sprintf("uri: %s\n", quoted))
+}
+
+// pgEnv returns the environment for pg_dump and psql, with the password and
+// TLS settings. Setting them on the command rather than the process keeps
+// concurrent runs apart.
+func pgEnv(cfg config.DatabaseConfig) []string {
+	env := append(os.Environ(), "PGPASSWORD="+cfg.Password)
+	for k, v := range pgTLSParams(cfg.TLS) {
+		env = append(env, "PG"+strings.ToUpper(k)+"="+v)
+	}
+	return env
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+const testPassword = `s3cr"et\pa ss#word`
+
+func TestMySQLOptionFile(t *testing.T) {
+	path, cleanup, err := mysqlOptionFile(config.DatabaseConfig{
+		Host:     "db.internal",
+		Port:     3306,
+		User:     "backup",
+		Password: testPassword,
+		TLS:      config.TLSConfig{Mode: tlsVerifyCA, CAFile: "/etc/ssl/ca.pem"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("option file mode %v, want 0600", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	want := `[client]
+host="db.internal"
+port=3306
+user="backup"
+password="s3cr\"et\\pa ss#word"
+loose-ssl-mode=VERIFY_CA
+loose-ssl
+ssl-ca="/etc/ssl/ca.pem"
+`
+	if string(data) != want {
+		t.Errorf("option file:\n%s\nwant:\n%s", data, want)
+	}
+
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("option file left behind: %v", err)
+	}
+}
+
+func TestMongoConfigFile(t *testing.T) {
+	uri := "mongodb://backup:" + testPassword + "@db.internal:27017/?authSource=admin"
+	path, cleanup, err := mongoConfigFile(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	data, _ := os.ReadFile(path)
+	value, ok := strings.CutPrefix(strings.TrimSuffix(string(data), "\n"), "uri: ")
+	if !ok {
+		t.Fatalf("config file %q", data)
+	}
+	// The value is a double-quoted scalar, which JSON decodes the same way
+	var got string
+	if err := json.Unmarshal([]byte(value), &got); err != nil || got != uri {
+		t.Fatalf("uri = %q, %v", got, err)
+	}
+}
+
+func TestPgEnv(t *testing.T) {
+	t.Setenv("PGPASSWORD", "from-the-parent")
+	env := pgEnv(config.DatabaseConfig{
+		Password: testPassword,
+		TLS:      config.TLSConfig{Mode: tlsVerifyFull, CAFile: "/etc/ssl/ca.pem"},
+	})
+
+	// The last value of a repeated variable wins
+	values := map[string]string{}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		values[k] = v
+	}
+	if values["PGPASSWORD"] != testPassword || values["PGSSLMODE"] != tlsVerifyFull || values["PGSSLROOTCERT"] != "/etc/ssl/ca.pem" {
+		t.Fatalf("env %v", values)
+	}
+	if os.Getenv("PGPASSWORD") != "from-the-parent" {
+		t.Fatal("pgEnv changed the process environment")
+	}
+}
+
+// fakeClientTool installs a shell script as name that writes its arguments,
+// PGPASSWORD and the files passed as --defaults-extra-file or --config to
+// tool.log in the same directory. It returns the directory.
+func fakeClientTool(t *testing.T, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+log="$(dirname "$0")/tool.log"
+echo "args: $*" > "$log"
+echo "PGPASSWORD=$PGPASSWORD" >> "$log"
+for arg in "$@"; do
+	case "$arg" in
+	--defaults-extra-file=*|--config=*) echo "file: ${arg#*=}" >> "$log"; cat "${arg#*=}" >> "$log" ;;
+	esac
+done
+`
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestPasswordsStayOffCommandLine(t *testing.T) {
+	tests := []struct {
+		tool     string
+		dumpTool string // restores find their tool next to the dump tool
+		run      func(cfg config.DatabaseConfig) error
+		want     string
+	}{
+		{"pg_dump", "pg_dump", func(cfg config.DatabaseConfig) error {
+			_, err := NewPostgres(cfg).Backup("full")
+			return err
+		}, "PGPASSWORD=" + testPassword},
+		{"mysql", "mysqldump", func(cfg config.DatabaseConfig) error {
+			return NewMySQL(cfg).Restore("backup_mysql_app_20260102_150405.sql")
+		}, `password="s3cr\"et\\pa ss#word"`},
+		{"mongodump", "mongodump", func(cfg config.DatabaseConfig) error {
+			_, err := NewMongoDB(cfg).Backup("full")
+			return err
+		}, "uri: \"mongodb://" + url.UserPassword("backup", testPassword).String() + "@"},
+		{"mongorestore", "mongodump", func(cfg config.DatabaseConfig) error {
+			return NewMongoDB(cfg).Restore("backup_mongo_app_20260102_150405.archive")
+		}, "uri: \"mongodb://" + url.UserPassword("backup", testPassword).String() + "@"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tool, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			os.WriteFile("backup_mysql_app_20260102_150405.sql", []byte("-- dump"), 0600)
+			dir := fakeClientTool(t, tt.tool)
+
+			cfg := config.DatabaseConfig{Host: "db.internal", Port: 5432, User: "backup", Password: testPassword, DBName: "app", ToolPath: filepath.Join(dir, tt.dumpTool)}
+			if err := tt.run(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "tool.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			log := string(data)
+			args, _, _ := strings.Cut(log, "\n")
+			if strings.Contains(args, "s3cr") {
+				t.Fatalf("password on the command line: %s", args)
+			}
+			if !strings.Contains(log, tt.want) {
+				t.Fatalf("tool didn't get the password:\n%s", log)
+			}
+			if _, file, ok := strings.Cut(log, "file: "); ok {
+				file, _, _ = strings.Cut(file, "\n")
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Fatalf("%s left behind: %v", file, err)
+				}
+			}
+		})
+	}
+}
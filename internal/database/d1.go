@@ -1,15 +1,54 @@
 package database
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
 )
 
+const (
+	d1DefaultEndpoint = "https://api.cloudflare.com/client/v4"
+	d1ProviderName    = "d1"
+	// d1TimeTravelDays is how far back Time Travel reaches on paid plans
+	d1TimeTravelDays = 30
+)
+
+var (
+	// d1PollInterval is how often a running export or import is polled
+	d1PollInterval = 2 * time.Second
+	// d1PollTimeout bounds how long an export or import may take
+	d1PollTimeout = time.Hour
+	// d1APITimeout bounds each API call. Dumps going to or from signed URLs
+	// can be gigabytes and take as long as they need, they only have to
+	// start within d1ResponseHeaderTimeout.
+	d1APITimeout            = 5 * time.Minute
+	d1ResponseHeaderTimeout = 5 * time.Minute
+)
+
+// D1 backs up Cloudflare D1 databases through the D1 REST API: exports are
+// polled until Cloudflare has written the SQL dump and downloaded from a
+// signed URL, imports are uploaded to a signed URL and then ingested.
 type D1 struct {
 	Config config.DatabaseConfig
+
+	client     *http.Client
+	endpoint   string
+	accountID  string
+	token      string
+	databaseID string
 }
 
 func NewD1(cfg config.DatabaseConfig) *D1 {
@@ -17,84 +56,384 @@ func NewD1(cfg config.DatabaseConfig) *D1 {
 }
 
 func (d *D1) Connect() error {
-	// Wrangler connects via HTTP, no persistent connection needed
+	// The API is plain HTTPS, there is no connection to hold. Connect only
+	// checks the settings.
+	d.accountID = d.Config.D1.AccountID
+	if d.accountID == "" {
+		d.accountID = os.Getenv("CLOUDFLARE_ACCOUNT_ID")
+	}
+	d.token = d.Config.D1.APIToken
+	if d.token == "" {
+		d.token = os.Getenv("CLOUDFLARE_API_TOKEN")
+	}
+	d.endpoint = strings.TrimSuffix(d.Config.D1.Endpoint, "/")
+	if d.endpoint == "" {
+		d.endpoint = d1DefaultEndpoint
+	}
+
+	if d.accountID == "" {
+		return fmt.Errorf("cloudflare account ID is not set (database.d1.account_id or CLOUDFLARE_ACCOUNT_ID)")
+	}
+	if d.token == "" {
+		return fmt.Errorf("cloudflare API token is not set (database.d1.api_token or CLOUDFLARE_API_TOKEN)")
+	}
+	logger.AddSecret(d.token)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = d1ResponseHeaderTimeout
+	d.client = &http.Client{Transport: transport}
 	return nil
 }
 
 func (d *D1) TestConnection() error {
-	// Verify wrangler is installed and authenticated
-	cmdName := "npx"
-	var args []string
-	
-	if d.Config.ToolPath != "" {
-		cmdName = d.Config.ToolPath
-		args = []string{"d1", "info", d.Config.DBName}
-	} else {
-		args = []string{"wrangler", "d1", "info", d.Config.DBName}
+	if d.client == nil {
+		if err := d.Connect(); err != nil {
+			return err
+		}
 	}
+	_, err := d.database()
+	return err
+}
 
-	cmd := exec.Command(cmdName, args...)
-	
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("wrangler d1 info failed: %v, output: %s", err, string(output))
+// database returns the UUID of the configured database, looking it up by name
+// unless database_id is set
+func (d *D1) database() (string, error) {
+	if d.databaseID != "" {
+		return d.databaseID, nil
 	}
 
-	return nil
+	if id := d.Config.D1.DatabaseID; id != "" {
+		var db struct {
+			UUID string `json:"uuid"`
+		}
+		if err := d.call(http.MethodGet, "/d1/database/"+url.PathEscape(id), nil, nil, &db); err != nil {
+			return "", err
+		}
+		d.databaseID = db.UUID
+		return d.databaseID, nil
+	}
+
+	var dbs []struct {
+		UUID string `json:"uuid"`
+		Name string `json:"name"`
+	}
+	if err := d.call(http.MethodGet, "/d1/database", url.Values{"name": {d.Config.DBName}}, nil, &dbs); err != nil {
+		return "", err
+	}
+	for _, db := range dbs {
+		if db.Name == d.Config.DBName {
+			d.databaseID = db.UUID
+			return d.databaseID, nil
+		}
+	}
+	return "", fmt.Errorf("d1 database %q not found", d.Config.DBName)
+}
+
+// d1Task is the state of an export or import, returned by every poll
+type d1Task struct {
+	AtBookmark string   `json:"at_bookmark"`
+	Status     string   `json:"status"` // active, complete or error
+	Error      string   `json:"error"`
+	Messages   []string `json:"messages"`
+	UploadURL  string   `json:"upload_url"` // import init
+	Filename   string   `json:"filename"`   // import init
+	Result     struct {
+		Filename  string `json:"filename"`
+		SignedURL string `json:"signed_url"`
+	} `json:"result"`
 }
 
 func (d *D1) Backup(backupType string) (string, error) {
+	filename, _, err := d.BackupWithMetadata(backupType, nil)
+	return filename, err
+}
+
+// BackupWithMetadata exports the database and records the Time Travel
+// bookmark the export was taken at, which restore can roll back to in place
+func (d *D1) BackupWithMetadata(backupType string, previous []*Metadata) (string, *Metadata, error) {
 	filename := fmt.Sprintf("backup_d1_%s_%s.sql", d.Config.DBName, time.Now().Format("20060102_150405"))
-	
-	cmdName := "npx"
-	var args []string
 
-	if d.Config.ToolPath != "" {
-		cmdName = d.Config.ToolPath
-		args = []string{"d1", "export", d.Config.DBName, "--remote", "--output=" + filename}
-	} else {
-		args = []string{"wrangler", "d1", "export", d.Config.DBName, "--remote", "--output=" + filename}
+	task, err := d.export()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := d.download(task.Result.SignedURL, filename); err != nil {
+		os.Remove(filename)
+		return "", nil, err
+	}
+
+	meta := &Metadata{
+		Provider: d1ProviderName,
+		Database: d.Config.DBName,
+		Type:     "full",
+		Created:  time.Now().UTC(),
+		Properties: map[string]string{
+			"bookmark":    task.AtBookmark,
+			"database_id": d.databaseID,
+		},
 	}
+	return filename, meta, nil
+}
+
+// export starts an export and polls it until the dump is ready
+func (d *D1) export() (*d1Task, error) {
+	id, err := d.database()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.poll("export", func(prev *d1Task) (*d1Task, error) {
+		body := map[string]any{"output_format": "polling"}
+		if prev != nil {
+			body["current_bookmark"] = prev.AtBookmark
+		}
+		var task d1Task
+		err := d.call(http.MethodPost, "/d1/database/"+id+"/export", nil, body, &task)
+		return &task, err
+	})
+}
 
-	cmd := exec.Command(cmdName, args...)
-	
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("wrangler d1 export failed: %v, output: %s", err, string(output))
+// download writes the dump behind a signed URL to filename
+func (d *D1) download(signedURL, filename string) error {
+	if signedURL == "" {
+		return fmt.Errorf("d1 export finished without a download URL")
+	}
+	resp, err := d.client.Get(signedURL)
+	if err != nil {
+		return fmt.Errorf("failed to download d1 export: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download d1 export: status %d", resp.StatusCode)
 	}
 
-	return filename, nil
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to download d1 export: %v", err)
+	}
+	return file.Close()
 }
 
 func (d *D1) Restore(backupFile string) error {
-	cmdName := "npx"
-	var args []string
-
-	if d.Config.ToolPath != "" {
-		// If ToolPath is just "wrangler"
-		cmdName = d.Config.ToolPath
-		args = []string{"d1", "execute", d.Config.DBName, "--remote", "--file=" + backupFile}
-	} else {
-		args = []string{"wrangler", "d1", "execute", d.Config.DBName, "--remote", "--file=" + backupFile}
-	}
-
-	cmd := exec.Command(cmdName, args...)
-	
-	// Execute takes the file directly, we don't pipe stdin for wrangler d1 execute
-	// But it might ask for confirmation: "Are you sure you want to execute? (y/n)"
-	// To bypass this, wrangler doesn't have a `--yes` normally for `execute` but let's check. 
-	// Wait, wrangler d1 execute usually requires confirmation. Let's pass `--yes` just in case.
-	// Actually `wrangler d1 execute <db> --remote --file=<file>` currently might prompt.
-	// Let's add `--yes` to auto-confirm if possible. 
-	args = append(args, "-y")
-	
-	cmd.Args = append([]string{cmdName}, args...)
-	
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("wrangler d1 execute failed: %v, output: %s", err, string(output))
+	if err := d.TestConnection(); err != nil {
+		return err
+	}
+	id := d.databaseID
+
+	// The dump is streamed twice, for its MD5 and for the upload, rather than
+	// held in memory
+	file, err := os.Open(backupFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	path := "/d1/database/" + id + "/import"
+
+	// 1. Ask for an upload URL
+	var upload d1Task
+	if err := d.call(http.MethodPost, path, nil, map[string]any{"action": "init", "etag": etag}, &upload); err != nil {
+		return err
+	}
+	if upload.UploadURL == "" {
+		return fmt.Errorf("d1 import init returned no upload URL")
+	}
+
+	// 2. Upload the dump. The returned ETag is the MD5 of what arrived.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, upload.UploadURL, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload d1 import: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to upload d1 import: status %d", resp.StatusCode)
+	}
+	if got := strings.Trim(resp.Header.Get("ETag"), `"`); got != "" && got != etag {
+		return fmt.Errorf("d1 import upload was corrupted: etag %s, expected %s", got, etag)
+	}
+
+	// 3. Ingest it and wait until it is applied
+	_, err = d.poll("import", func(prev *d1Task) (*d1Task, error) {
+		body := map[string]any{"action": "ingest", "etag": etag, "filename": upload.Filename}
+		if prev != nil {
+			body = map[string]any{"action": "poll", "current_bookmark": prev.AtBookmark}
+		}
+		var task d1Task
+		err := d.call(http.MethodPost, path, nil, body, &task)
+		return &task, err
+	})
+	return err
+}
+
+// RestoreToPoint rolls the database back in place with Time Travel. A time is
+// first turned into the nearest bookmark before it. The bookmark the database
+// was at before the restore is logged so the rollback can be undone.
+func (d *D1) RestoreToPoint(bookmark string, at time.Time) error {
+	if err := d.TestConnection(); err != nil {
+		return err
 	}
+	path := "/d1/database/" + d.databaseID + "/time_travel"
 
+	if bookmark == "" {
+		days := d.Config.D1.TimeTravelDays
+		if days <= 0 {
+			days = d1TimeTravelDays
+		}
+		if at.Before(time.Now().AddDate(0, 0, -days)) {
+			return ErrPointUnavailable
+		}
+		var result struct {
+			Bookmark string `json:"bookmark"`
+		}
+		query := url.Values{"timestamp": {at.UTC().Format(time.RFC3339)}}
+		err := d.call(http.MethodGet, path+"/bookmark", query, nil, &result)
+		// The API refuses a time before the history it keeps, which may be
+		// shorter than time_travel_days
+		var apiErr *d1Error
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusBadRequest {
+			return fmt.Errorf("%w: %v", ErrPointUnavailable, err)
+		}
+		if err != nil {
+			return err
+		}
+		bookmark = result.Bookmark
+	}
+
+	var result struct {
+		Bookmark         string `json:"bookmark"`
+		PreviousBookmark string `json:"previous_bookmark"`
+		Message          string `json:"message"`
+	}
+	if err := d.call(http.MethodPost, path+"/restore", url.Values{"bookmark": {bookmark}}, nil, &result); err != nil {
+		return err
+	}
+	logger.Info("D1 database rolled back with Time Travel", "database", d.Config.DBName, "bookmark", result.Bookmark, "previous_bookmark", result.PreviousBookmark)
 	return nil
 }
 
+// HasBackup matches the metadata of exports of this database
+func (d *D1) HasBackup(meta *Metadata) bool {
+	return meta.Provider == d1ProviderName && meta.Database == d.Config.DBName
+}
+
+// poll calls step until the task completes. step gets nil on the first call
+// and the previous state afterwards.
+func (d *D1) poll(operation string, step func(prev *d1Task) (*d1Task, error)) (*d1Task, error) {
+	deadline := time.Now().Add(d1PollTimeout)
+	var task *d1Task
+	for {
+		next, err := step(task)
+		if err != nil {
+			return nil, err
+		}
+		if next.AtBookmark == "" && task != nil {
+			next.AtBookmark = task.AtBookmark
+		}
+		task = next
+
+		switch task.Status {
+		case "complete":
+			if task.Error != "" {
+				return nil, fmt.Errorf("d1 %s failed: %s", operation, task.Error)
+			}
+			return task, nil
+		case "error":
+			return nil, fmt.Errorf("d1 %s failed: %s", operation, task.Error)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("d1 %s did not finish within %s", operation, d1PollTimeout)
+		}
+		time.Sleep(d1PollInterval)
+	}
+}
+
+// call sends a request to the account-scoped Cloudflare API and decodes the
+// result of the response envelope into result
+func (d *D1) call(method, path string, query url.Values, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := d.endpoint + "/accounts/" + url.PathEscape(d.accountID) + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d1APITimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare API returned status %d with an unreadable body: %v", resp.StatusCode, err)
+	}
+	if !envelope.Success || resp.StatusCode/100 != 2 {
+		var messages []string
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%s (code %d)", e.Message, e.Code))
+		}
+		return &d1Error{Status: resp.StatusCode, Message: strings.Join(messages, "; ")}
+	}
+	if result == nil || len(envelope.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, result)
+}
+
+// d1Error is an error response of the Cloudflare API
+type d1Error struct {
+	Status  int
+	Message string
+}
+
+func (e *d1Error) Error() string {
+	return fmt.Sprintf("cloudflare API returned status %d: %s", e.Status, e.Message)
+}
+
 func (d *D1) Close() error {
 	return nil
 }
@@ -0,0 +1,448 @@
+package database
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// fakeD1 is a stand-in for the account-scoped D1 API. Exports and imports
+// report "active" for pollsUntilDone polls before completing.
+type fakeD1 struct {
+	t              *testing.T
+	srv            *httptest.Server
+	mu             sync.Mutex
+	dump           string
+	pollsUntilDone int
+	exportPolls    int
+	importPolls    int
+	exportBodies   []map[string]any
+	importActions  []string
+	uploaded       []byte
+	uploadLength   int64
+	failWith       string // answer every API call with success:false and this message
+	historyStart   time.Time
+	lookups        []string // Time Travel bookmark lookups by timestamp
+	rolledBackTo   string
+}
+
+const (
+	d1TestAccount = "acc123"
+	d1TestUUID    = "0b6a3a8c-uuid"
+)
+
+func newFakeD1(t *testing.T) *fakeD1 {
+	t.Helper()
+	f := &fakeD1{t: t, dump: "CREATE TABLE t (id INTEGER);\nINSERT INTO t VALUES (1);\n", pollsUntilDone: 2}
+	f.srv = httptest.NewServer(f)
+	t.Cleanup(f.srv.Close)
+
+	orig, origTimeout, origAPI, origHeader := d1PollInterval, d1PollTimeout, d1APITimeout, d1ResponseHeaderTimeout
+	d1PollInterval = time.Millisecond
+	t.Cleanup(func() {
+		d1PollInterval, d1PollTimeout, d1APITimeout, d1ResponseHeaderTimeout = orig, origTimeout, origAPI, origHeader
+	})
+	return f
+}
+
+func (f *fakeD1) provider(token string) *D1 {
+	d := NewD1(config.DatabaseConfig{
+		Type:   "d1",
+		DBName: "app",
+		D1:     config.D1Config{AccountID: d1TestAccount, APIToken: token, Endpoint: f.srv.URL + "/client/v4/"},
+	})
+	if err := d.Connect(); err != nil {
+		f.t.Fatal(err)
+	}
+	return d
+}
+
+func (f *fakeD1) result(w http.ResponseWriter, result any) {
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "errors": []any{}, "result": result})
+}
+
+func (f *fakeD1) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Signed URLs don't carry the API token
+	switch r.URL.Path {
+	case "/signed/export.sql":
+		io.WriteString(w, f.dump)
+		return
+	case "/signed/upload":
+		f.uploaded, _ = io.ReadAll(r.Body)
+		f.uploadLength = r.ContentLength
+		sum := md5.Sum(f.uploaded)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer cf-token" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`)
+		return
+	}
+	if f.failWith != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"success":false,"errors":[{"code":7500,"message":%q}],"result":null}`, f.failWith)
+		return
+	}
+
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+
+	prefix := "/client/v4/accounts/" + d1TestAccount + "/d1/database"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == prefix:
+		f.result(w, []map[string]string{
+			{"uuid": "other-uuid", "name": "app-staging"},
+			{"uuid": d1TestUUID, "name": r.URL.Query().Get("name")},
+		})
+	case r.Method == http.MethodPost && r.URL.Path == prefix+"/"+d1TestUUID+"/export":
+		f.exportBodies = append(f.exportBodies, body)
+		f.exportPolls++
+		if f.exportPolls <= f.pollsUntilDone {
+			f.result(w, map[string]any{"at_bookmark": "bookmark-1", "status": "active"})
+			return
+		}
+		f.result(w, map[string]any{
+			"at_bookmark": "bookmark-1",
+			"status":      "complete",
+			"result":      map[string]string{"filename": "export.sql", "signed_url": f.srv.URL + "/signed/export.sql"},
+		})
+	case r.Method == http.MethodGet && r.URL.Path == prefix+"/"+d1TestUUID+"/time_travel/bookmark":
+		timestamp := r.URL.Query().Get("timestamp")
+		f.lookups = append(f.lookups, timestamp)
+		if at, _ := time.Parse(time.RFC3339, timestamp); at.Before(f.historyStart) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":7500,"message":"Timestamp is outside of the Time Travel retention period"}]}`)
+			return
+		}
+		f.result(w, map[string]string{"bookmark": "bookmark-at-" + timestamp})
+	case r.Method == http.MethodPost && r.URL.Path == prefix+"/"+d1TestUUID+"/time_travel/restore":
+		f.rolledBackTo = r.URL.Query().Get("bookmark")
+		f.result(w, map[string]string{"bookmark": f.rolledBackTo, "previous_bookmark": "bookmark-2", "message": "Restored"})
+	case r.Method == http.MethodPost && r.URL.Path == prefix+"/"+d1TestUUID+"/import":
+		action, _ := body["action"].(string)
+		f.importActions = append(f.importActions, action)
+		switch action {
+		case "init":
+			f.result(w, map[string]any{"upload_url": f.srv.URL + "/signed/upload", "filename": "import-1.sql"})
+		case "ingest":
+			if body["filename"] != "import-1.sql" {
+				f.t.Errorf("ingest of %v", body["filename"])
+			}
+			f.result(w, map[string]any{"at_bookmark": "bookmark-2", "status": "active"})
+		case "poll":
+			f.importPolls++
+			if f.importPolls < f.pollsUntilDone {
+				f.result(w, map[string]any{"at_bookmark": "bookmark-2", "status": "active"})
+				return
+			}
+			f.result(w, map[string]any{"at_bookmark": "bookmark-2", "status": "complete"})
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":7404,"message":"not found"}]}`)
+	}
+}
+
+func TestD1Backup(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake := newFakeD1(t)
+
+	file, meta, err := fake.provider("cf-token").BackupWithMetadata("full", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file)
+
+	data, _ := os.ReadFile(file)
+	if string(data) != fake.dump {
+		t.Fatalf("downloaded %q", data)
+	}
+	if meta.Properties["bookmark"] != "bookmark-1" || meta.Properties["database_id"] != d1TestUUID {
+		t.Fatalf("metadata %+v", meta.Properties)
+	}
+
+	// The first request starts the export, the polls continue it
+	if fake.exportPolls != 3 {
+		t.Fatalf("%d export requests, want 3", fake.exportPolls)
+	}
+	if _, ok := fake.exportBodies[0]["current_bookmark"]; ok {
+		t.Fatal("first export request carried a bookmark")
+	}
+	for _, body := range fake.exportBodies[1:] {
+		if body["current_bookmark"] != "bookmark-1" || body["output_format"] != "polling" {
+			t.Fatalf("poll body %v", body)
+		}
+	}
+}
+
+func TestD1BackupTimesOut(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake := newFakeD1(t)
+	fake.pollsUntilDone = 1 << 30
+	d1PollTimeout = 20 * time.Millisecond
+
+	_, _, err := fake.provider("cf-token").BackupWithMetadata("full", nil)
+	if err == nil || !strings.Contains(err.Error(), "d1 export did not finish within 20ms") {
+		t.Fatalf("BackupWithMetadata = %v", err)
+	}
+	if entries, _ := os.ReadDir("."); len(entries) != 0 {
+		t.Fatalf("left files behind: %v", entries)
+	}
+}
+
+func TestD1BackupDownloadFailure(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake := newFakeD1(t)
+	fake.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/signed/export.sql" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+
+	_, _, err := fake.provider("cf-token").BackupWithMetadata("full", nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to download d1 export: status 403") {
+		t.Fatalf("BackupWithMetadata = %v", err)
+	}
+}
+
+func TestD1Timeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		slow    string // path that answers late
+		wantErr string
+	}{
+		// A dump download may take longer than any API call
+		{"slow download", "/signed/export.sql", ""},
+		{"slow API call", "/client/v4/accounts/" + d1TestAccount + "/d1/database", "context deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			fake := newFakeD1(t)
+			d1APITimeout = 50 * time.Millisecond
+			fake.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.slow {
+					fake.ServeHTTP(w, r)
+					return
+				}
+				if r.URL.Path == "/signed/export.sql" {
+					// Headers right away, the body trickles in
+					for _, line := range strings.SplitAfter(fake.dump, "\n") {
+						io.WriteString(w, line)
+						w.(http.Flusher).Flush()
+						time.Sleep(40 * time.Millisecond)
+					}
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+				fake.ServeHTTP(w, r)
+			})
+
+			file, _, err := fake.provider("cf-token").BackupWithMetadata("full", nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("BackupWithMetadata = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(file); string(data) != fake.dump {
+				t.Fatalf("downloaded %q", data)
+			}
+		})
+	}
+}
+
+func TestD1UploadWaitsForHeaders(t *testing.T) {
+	fake := newFakeD1(t)
+	d1ResponseHeaderTimeout = 20 * time.Millisecond
+	fake.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/signed/upload" {
+			io.Copy(io.Discard, r.Body)
+			time.Sleep(100 * time.Millisecond)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	dump := t.TempDir() + "/dump.sql"
+	os.WriteFile(dump, []byte(fake.dump), 0600)
+
+	err := fake.provider("cf-token").Restore(dump)
+	if err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Fatalf("Restore = %v", err)
+	}
+}
+
+func TestD1Restore(t *testing.T) {
+	fake := newFakeD1(t)
+	dump := t.TempDir() + "/backup_d1_app_20260102_150405.sql"
+	os.WriteFile(dump, []byte(fake.dump), 0600)
+
+	if err := fake.provider("cf-token").Restore(dump); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.uploaded) != fake.dump || fake.uploadLength != int64(len(fake.dump)) {
+		t.Fatalf("uploaded %d bytes with length %d", len(fake.uploaded), fake.uploadLength)
+	}
+	if got := strings.Join(fake.importActions, ","); got != "init,ingest,poll,poll" {
+		t.Fatalf("import actions %s", got)
+	}
+}
+
+func TestD1RestoreToPoint(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tests := []struct {
+		name           string
+		bookmark       string
+		at             time.Time
+		timeTravelDays int
+		historyStart   time.Time
+		wantLookup     bool
+		wantBookmark   string
+		unavailable    bool
+	}{
+		{
+			name:         "bookmark",
+			bookmark:     "00000085-0000024c",
+			wantBookmark: "00000085-0000024c",
+		},
+		{
+			name:         "time inside the history",
+			at:           now.Add(-48 * time.Hour),
+			wantLookup:   true,
+			wantBookmark: "bookmark-at-" + now.Add(-48*time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:        "time before the default window",
+			at:          now.AddDate(0, 0, -31),
+			unavailable: true,
+		},
+		{
+			name:           "time before a free plan window",
+			at:             now.AddDate(0, 0, -10),
+			timeTravelDays: 7,
+			unavailable:    true,
+		},
+		{
+			name:         "time the API refuses",
+			at:           now.AddDate(0, 0, -10),
+			historyStart: now.AddDate(0, 0, -7),
+			wantLookup:   true,
+			unavailable:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeD1(t)
+			fake.historyStart = tt.historyStart
+			d := fake.provider("cf-token")
+			d.Config.D1.TimeTravelDays = tt.timeTravelDays
+
+			err := d.RestoreToPoint(tt.bookmark, tt.at)
+			if tt.unavailable {
+				if !errors.Is(err, ErrPointUnavailable) {
+					t.Fatalf("RestoreToPoint = %v, want ErrPointUnavailable", err)
+				}
+				if fake.rolledBackTo != "" {
+					t.Fatalf("rolled back to %s", fake.rolledBackTo)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(fake.lookups) > 0; got != tt.wantLookup {
+				t.Fatalf("bookmark lookups %v", fake.lookups)
+			}
+			if fake.rolledBackTo != tt.wantBookmark {
+				t.Fatalf("rolled back to %q, want %q", fake.rolledBackTo, tt.wantBookmark)
+			}
+		})
+	}
+}
+
+func TestD1RestoreToPointAPIError(t *testing.T) {
+	fake := newFakeD1(t)
+	fake.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/time_travel/bookmark") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":7500,"message":"Internal error"}]}`)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+
+	// Only a refused time falls back to a backup, other errors fail the restore
+	err := fake.provider("cf-token").RestoreToPoint("", time.Now().Add(-time.Hour))
+	if err == nil || errors.Is(err, ErrPointUnavailable) || !strings.Contains(err.Error(), "status 500: Internal error (code 7500)") {
+		t.Fatalf("RestoreToPoint = %v", err)
+	}
+}
+
+func TestD1RestoreDetectsCorruptUpload(t *testing.T) {
+	fake := newFakeD1(t)
+	fake.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/signed/upload" {
+			w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	})
+	dump := t.TempDir() + "/dump.sql"
+	os.WriteFile(dump, []byte(fake.dump), 0600)
+
+	err := fake.provider("cf-token").Restore(dump)
+	if err == nil || !strings.Contains(err.Error(), "d1 import upload was corrupted") {
+		t.Fatalf("Restore = %v", err)
+	}
+}
+
+func TestD1ErrorEnvelope(t *testing.T) {
+	fake := newFakeD1(t)
+	fake.failWith = "Export is not supported for databases over 10GB"
+
+	err := fake.provider("cf-token").TestConnection()
+	want := "cloudflare API returned status 400: Export is not supported for databases over 10GB (code 7500)"
+	if err == nil || err.Error() != want {
+		t.Fatalf("TestConnection = %v, want %q", err, want)
+	}
+}
+
+func TestD1RejectsWrongToken(t *testing.T) {
+	fake := newFakeD1(t)
+	err := fake.provider("wrong").TestConnection()
+	if err == nil || !strings.Contains(err.Error(), "Authentication error (code 10000)") {
+		t.Fatalf("TestConnection = %v", err)
+	}
+}
+
+func TestD1DatabaseNotFound(t *testing.T) {
+	fake := newFakeD1(t)
+	fake.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.result(w, []map[string]string{{"uuid": "other-uuid", "name": "app-staging"}})
+	})
+
+	err := fake.provider("cf-token").TestConnection()
+	if err == nil || err.Error() != `d1 database "app" not found` {
+		t.Fatalf("TestConnection = %v", err)
+	}
+}
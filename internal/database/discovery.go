@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// AllDatabases is the dbname that selects every database on the server
+const AllDatabases = "*"
+
+// Discoverer is implemented by providers that can back up every database on a
+// server, selected by dbname "*" and the include/exclude patterns.
+type Discoverer interface {
+	// ListDatabases returns the selected databases, or nil when the config
+	// names a single database
+	ListDatabases() ([]string, error)
+
+	// ForDatabase returns a provider for one database on the same server
+	ForDatabase(name string) Database
+}
+
+// GlobalsBackuper is implemented by providers with server-wide objects that no
+// per-database dump contains, such as Postgres roles and tablespaces. The
+// globals are backed up alongside the databases in discovery mode.
+type GlobalsBackuper interface {
+	BackupGlobals() (string, error)
+}
+
+// discoveryEnabled reports whether the config selects several databases
+func discoveryEnabled(cfg config.DatabaseConfig) bool {
+	return cfg.DBName == AllDatabases || len(cfg.Include) > 0
+}
+
+// selectDatabases applies the include and exclude patterns (path.Match syntax)
+// to the databases found on the server. System databases are skipped unless an
+// include pattern names them exactly. The result is never nil, so that
+// ListDatabases can tell "nothing matched" from "discovery is off".
+func selectDatabases(cfg config.DatabaseConfig, found []string, system map[string]bool) ([]string, error) {
+	include := cfg.Include
+	if len(include) == 0 {
+		include = []string{"*"}
+	}
+
+	selected := []string{}
+	for _, name := range found {
+		ok, err := matchAny(include, name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if system[name] && !contains(include, name) {
+			continue
+		}
+
+		excluded, err := matchAny(cfg.Exclude, name)
+		if err != nil {
+			return nil, err
+		}
+		if !excluded {
+			selected = append(selected, name)
+		}
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid database pattern %q: %v", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// forDatabase returns a copy of cfg that names a single database
+func forDatabase(cfg config.DatabaseConfig, name string) config.DatabaseConfig {
+	cfg.DBName = name
+	cfg.Include = nil
+	cfg.Exclude = nil
+	return cfg
+}
+
+// artifactDatabase matches backup_<provider>_<dbname>_<timestamp>.<ext>
+var artifactDatabase = regexp.MustCompile(`^backup_[a-z0-9]+_(.+)_\d{8}_\d{6}\.`)
+
+// DatabaseFromArtifact returns the database a backup file was taken from,
+// used to pick the restore target when dbname is "*"
+func DatabaseFromArtifact(name string) (string, bool) {
+	match := artifactDatabase.FindStringSubmatch(path.Base(name))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
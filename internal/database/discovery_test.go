@@ -0,0 +1,90 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+func TestSelectDatabases(t *testing.T) {
+	found := []string{"postgres", "app", "app_test", "billing", "analytics"}
+	system := map[string]bool{"postgres": true}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+		wantErr bool
+	}{
+		{"everything but system", nil, nil, []string{"analytics", "app", "app_test", "billing"}, false},
+		{"include pattern", []string{"app*"}, nil, []string{"app", "app_test"}, false},
+		{"include and exclude", []string{"app*"}, []string{"*_test"}, []string{"app"}, false},
+		{"exclude only", nil, []string{"billing", "analytics"}, []string{"app", "app_test"}, false},
+		{"system database named exactly", []string{"postgres", "billing"}, nil, []string{"billing", "postgres"}, false},
+		{"system database not matched by a wildcard", []string{"post*"}, nil, []string{}, false},
+		{"nothing matches", []string{"crm*"}, nil, []string{}, false},
+		{"everything excluded", nil, []string{"*"}, []string{}, false},
+		{"invalid include", []string{"[app"}, nil, nil, true},
+		{"invalid exclude", nil, []string{"[app"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DatabaseConfig{DBName: AllDatabases, Include: tt.include, Exclude: tt.exclude}
+			got, err := selectDatabases(cfg, found, system)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("selectDatabases = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// An empty selection must not look like disabled discovery
+			if got == nil {
+				t.Fatal("selectDatabases returned nil")
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("selectDatabases = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryEnabled(t *testing.T) {
+	tests := []struct {
+		cfg  config.DatabaseConfig
+		want bool
+	}{
+		{config.DatabaseConfig{DBName: "app"}, false},
+		{config.DatabaseConfig{DBName: "*"}, true},
+		{config.DatabaseConfig{Include: []string{"app*"}}, true},
+	}
+	for _, tt := range tests {
+		if got := discoveryEnabled(tt.cfg); got != tt.want {
+			t.Errorf("discoveryEnabled(%+v) = %v", tt.cfg, got)
+		}
+	}
+}
+
+func TestDatabaseFromArtifact(t *testing.T) {
+	tests := []struct {
+		name string
+		db   string
+		ok   bool
+	}{
+		{"backup_postgres_app_20260102_150405.sql.gz", "app", true},
+		{"nightly/backup_mysql_my_app_20260102_150405.sql", "my_app", true},
+		{"backup_postgres_globals_20260102_150405.sql", "globals", true},
+		{"backup_postgres_app.sql", "", false},
+		{"app.dump", "", false},
+	}
+	for _, tt := range tests {
+		db, ok := DatabaseFromArtifact(tt.name)
+		if db != tt.db || ok != tt.ok {
+			t.Errorf("DatabaseFromArtifact(%q) = %q, %v", tt.name, db, ok)
+		}
+	}
+}
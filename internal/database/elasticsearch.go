@@ -0,0 +1,467 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/antigravity/dbbackup/internal/storage"
+)
+
+const (
+	esProviderName      = "elasticsearch"
+	esManifestFormat    = "elasticsearch-snapshot"
+	esDefaultRepository = "dbbackup"
+	esDefaultLabel      = "cluster"
+)
+
+// esPollInterval is how often a running snapshot is polled
+var esPollInterval = 5 * time.Second
+
+// esLabelChars are not allowed in repository paths and snapshot names
+var esLabelChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// Elasticsearch backs up Elasticsearch and OpenSearch clusters with snapshots.
+// The cluster writes each snapshot to a repository that matches the storage
+// (the s3 bucket, or a shared fs location), and the artifact the manager
+// uploads is a manifest pointing at it. dbname only labels the backups.
+type Elasticsearch struct {
+	Config  config.DatabaseConfig
+	Storage config.StorageConfig
+
+	client  *http.Client
+	baseURL string
+}
+
+// esManifest is the artifact of a snapshot
+type esManifest struct {
+	Format     string    `json:"format"`
+	Cluster    string    `json:"cluster"`
+	Repository string    `json:"repository"`
+	Snapshot   string    `json:"snapshot"`
+	UUID       string    `json:"uuid"`
+	Indices    []string  `json:"indices"`
+	State      string    `json:"state"`
+	Shards     int       `json:"shards"`
+	Created    time.Time `json:"created"`
+}
+
+// esSnapshot is the part of the snapshot info API response used here
+type esSnapshot struct {
+	Snapshot string   `json:"snapshot"`
+	UUID     string   `json:"uuid"`
+	State    string   `json:"state"`
+	Indices  []string `json:"indices"`
+	Failures []struct {
+		Index  string `json:"index"`
+		Reason string `json:"reason"`
+	} `json:"failures"`
+	Shards struct {
+		Total      int `json:"total"`
+		Failed     int `json:"failed"`
+		Successful int `json:"successful"`
+	} `json:"shards"`
+}
+
+func NewElasticsearch(cfg config.DatabaseConfig, st config.StorageConfig) *Elasticsearch {
+	return &Elasticsearch{Config: cfg, Storage: st}
+}
+
+func (e *Elasticsearch) Connect() error {
+	tlsCfg, err := newTLSConfig(e.Config.TLS, e.Config.Host)
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if tlsCfg != nil {
+		scheme = "https"
+	}
+	port := e.Config.Port
+	if port == 0 {
+		port = 9200
+	}
+	e.baseURL = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(e.Config.Host, strconv.Itoa(port)))
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	// A restore waits for every shard to recover and runs as long as it needs
+	e.client = &http.Client{Transport: transport}
+	return nil
+}
+
+func (e *Elasticsearch) TestConnection() error {
+	if e.client == nil {
+		if err := e.Connect(); err != nil {
+			return err
+		}
+	}
+	return e.call(http.MethodGet, "/", nil, nil, nil)
+}
+
+// call sends a request to the REST API and decodes the JSON response into
+// result, if given
+func (e *Elasticsearch) call(method, p string, query url.Values, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := e.baseURL + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	if key := e.Config.Elasticsearch.APIKey; key != "" {
+		req.Header.Set("Authorization", "ApiKey "+key)
+	} else if e.Config.User != "" {
+		req.SetBasicAuth(e.Config.User, e.Config.Password)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("elasticsearch request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &esError{Status: resp.StatusCode, Reason: esErrorReason(data)}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// esError is an error response of the REST API
+type esError struct {
+	Status int
+	Reason string
+}
+
+func (e *esError) Error() string {
+	return fmt.Sprintf("elasticsearch returned status %d: %s", e.Status, logger.Redact(e.Reason))
+}
+
+// esErrorReason extracts the error type and reason from an error response
+func esErrorReason(data []byte) string {
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && len(body.Error) > 0 {
+		var detail struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		}
+		if json.Unmarshal(body.Error, &detail) == nil && detail.Reason != "" {
+			return detail.Type + ": " + detail.Reason
+		}
+		return string(body.Error)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (e *Elasticsearch) Backup(backupType string) (string, error) {
+	filename, _, err := e.BackupWithMetadata(backupType, nil)
+	return filename, err
+}
+
+// BackupWithMetadata takes a snapshot of the configured index patterns and
+// waits for it to finish. Snapshots only copy segments the repository doesn't
+// have yet, so every one is incremental on disk but restores on its own; the
+// backup type doesn't change anything.
+func (e *Elasticsearch) BackupWithMetadata(backupType string, previous []*Metadata) (string, *Metadata, error) {
+	if err := e.TestConnection(); err != nil {
+		return "", nil, err
+	}
+	repository, err := e.registerRepository()
+	if err != nil {
+		return "", nil, err
+	}
+
+	stamp := time.Now().Format("20060102_150405")
+	snapshot := "dbbackup_" + stamp
+	body := map[string]any{
+		"ignore_unavailable":   true,
+		"include_global_state": e.Config.Elasticsearch.IncludeGlobalState,
+	}
+	if len(e.Config.Elasticsearch.Indices) > 0 {
+		body["indices"] = strings.Join(e.Config.Elasticsearch.Indices, ",")
+	}
+	snapshotPath := "/_snapshot/" + url.PathEscape(repository) + "/" + url.PathEscape(snapshot)
+	if err := e.call(http.MethodPut, snapshotPath, nil, body, nil); err != nil {
+		return "", nil, fmt.Errorf("failed to start snapshot: %v", err)
+	}
+	logger.Info("Snapshot started", "repository", repository, "snapshot", snapshot)
+
+	info, err := e.waitForSnapshot(repository, snapshot)
+	if err != nil {
+		return "", nil, err
+	}
+
+	manifest := esManifest{
+		Format:     esManifestFormat,
+		Cluster:    e.label(),
+		Repository: repository,
+		Snapshot:   snapshot,
+		UUID:       info.UUID,
+		Indices:    info.Indices,
+		State:      info.State,
+		Shards:     info.Shards.Total,
+		Created:    time.Now().UTC(),
+	}
+	filename := fmt.Sprintf("backup_es_%s_%s.json", e.label(), stamp)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		return "", nil, err
+	}
+
+	meta := &Metadata{
+		Provider: esProviderName,
+		Database: e.label(),
+		Type:     "full",
+		Created:  manifest.Created,
+		Properties: map[string]string{
+			"repository": repository,
+			"snapshot":   snapshot,
+			"uuid":       info.UUID,
+		},
+	}
+	return filename, meta, nil
+}
+
+// waitForSnapshot polls a running snapshot until it finishes, logging the
+// shards and bytes done so far
+func (e *Elasticsearch) waitForSnapshot(repository, snapshot string) (*esSnapshot, error) {
+	snapshotPath := "/_snapshot/" + url.PathEscape(repository) + "/" + url.PathEscape(snapshot)
+	lastDone := -1
+	for {
+		var found struct {
+			Snapshots []esSnapshot `json:"snapshots"`
+		}
+		if err := e.call(http.MethodGet, snapshotPath, nil, nil, &found); err != nil {
+			return nil, fmt.Errorf("failed to check snapshot: %v", err)
+		}
+		if len(found.Snapshots) == 0 {
+			return nil, fmt.Errorf("snapshot %s disappeared from repository %s", snapshot, repository)
+		}
+		info := &found.Snapshots[0]
+
+		switch info.State {
+		case "SUCCESS":
+			logger.Info("Snapshot finished", "snapshot", snapshot, "indices", len(info.Indices), "shards", info.Shards.Total)
+			return info, nil
+		case "IN_PROGRESS":
+		default:
+			var reasons []string
+			for _, f := range info.Failures {
+				reasons = append(reasons, f.Index+": "+f.Reason)
+			}
+			return nil, fmt.Errorf("snapshot finished %s, %d of %d shards failed: %s",
+				info.State, info.Shards.Failed, info.Shards.Total, strings.Join(reasons, "; "))
+		}
+
+		var status struct {
+			Snapshots []struct {
+				ShardsStats struct {
+					Done  int `json:"done"`
+					Total int `json:"total"`
+				} `json:"shards_stats"`
+				Stats struct {
+					Processed struct {
+						SizeInBytes int64 `json:"size_in_bytes"`
+					} `json:"processed"`
+					Incremental struct {
+						SizeInBytes int64 `json:"size_in_bytes"`
+					} `json:"incremental"`
+				} `json:"stats"`
+			} `json:"snapshots"`
+		}
+		// Progress is informational, a failed status request doesn't stop the wait
+		if err := e.call(http.MethodGet, snapshotPath+"/_status", nil, nil, &status); err == nil && len(status.Snapshots) > 0 {
+			s := status.Snapshots[0]
+			if s.ShardsStats.Done != lastDone {
+				lastDone = s.ShardsStats.Done
+				logger.Info("Snapshot in progress", "snapshot", snapshot,
+					"shards_done", s.ShardsStats.Done, "shards_total", s.ShardsStats.Total,
+					"bytes_done", s.Stats.Processed.SizeInBytes, "bytes_total", s.Stats.Incremental.SizeInBytes)
+			}
+		}
+		time.Sleep(esPollInterval)
+	}
+}
+
+// Restore restores the indices of the snapshot a manifest points at, renamed
+// with rename_pattern and rename_replacement when configured. Open indices
+// with the same names must be closed or deleted first.
+func (e *Elasticsearch) Restore(backupFile string) error {
+	data, err := os.ReadFile(backupFile)
+	if err != nil {
+		return err
+	}
+	var manifest esManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Format != esManifestFormat {
+		return fmt.Errorf("%s is not an elasticsearch snapshot manifest", backupFile)
+	}
+
+	if err := e.TestConnection(); err != nil {
+		return err
+	}
+	repository, err := e.registerRepository()
+	if err != nil {
+		return err
+	}
+	if repository != manifest.Repository {
+		logger.Warn("Snapshot was taken into another repository, restoring from the configured one", "backup_repository", manifest.Repository, "repository", repository)
+	}
+
+	cfg := e.Config.Elasticsearch
+	body := map[string]any{
+		"ignore_unavailable":   true,
+		"include_global_state": cfg.IncludeGlobalState,
+	}
+	if len(cfg.Indices) > 0 {
+		body["indices"] = strings.Join(cfg.Indices, ",")
+	}
+	if cfg.RenamePattern != "" {
+		body["rename_pattern"] = cfg.RenamePattern
+		body["rename_replacement"] = cfg.RenameReplacement
+	}
+
+	restorePath := "/_snapshot/" + url.PathEscape(repository) + "/" + url.PathEscape(manifest.Snapshot) + "/_restore"
+	query := url.Values{"wait_for_completion": {"true"}}
+	logger.Info("Restoring snapshot", "repository", repository, "snapshot", manifest.Snapshot)
+
+	var result struct {
+		Snapshot struct {
+			Indices []string `json:"indices"`
+			Shards  struct {
+				Total  int `json:"total"`
+				Failed int `json:"failed"`
+			} `json:"shards"`
+		} `json:"snapshot"`
+	}
+	if err := e.call(http.MethodPost, restorePath, query, body, &result); err != nil {
+		return fmt.Errorf("snapshot restore failed: %v", err)
+	}
+	if result.Snapshot.Shards.Failed > 0 {
+		return fmt.Errorf("snapshot restore failed for %d of %d shards", result.Snapshot.Shards.Failed, result.Snapshot.Shards.Total)
+	}
+	logger.Info("Snapshot restored", "indices", len(result.Snapshot.Indices))
+	return nil
+}
+
+// DeleteBackup deletes the snapshot behind a pruned manifest. A snapshot
+// that is already gone is not an error.
+func (e *Elasticsearch) DeleteBackup(st storage.Storage, meta *Metadata) error {
+	if meta.Provider != esProviderName || meta.Properties["snapshot"] == "" {
+		return nil
+	}
+	if err := e.TestConnection(); err != nil {
+		return err
+	}
+	snapshotPath := "/_snapshot/" + url.PathEscape(meta.Properties["repository"]) + "/" + url.PathEscape(meta.Properties["snapshot"])
+	err := e.call(http.MethodDelete, snapshotPath, nil, nil, nil)
+	if apiErr, ok := err.(*esError); ok && apiErr.Status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// registerRepository creates or updates the snapshot repository and returns
+// its name
+func (e *Elasticsearch) registerRepository() (string, error) {
+	repository := e.Config.Elasticsearch.Repository
+	if repository == "" {
+		repository = esDefaultRepository
+	}
+	settings, err := e.repositorySettings()
+	if err != nil {
+		return "", err
+	}
+	if err := e.call(http.MethodPut, "/_snapshot/"+url.PathEscape(repository), nil, settings, nil); err != nil {
+		return "", fmt.Errorf("failed to register snapshot repository %s: %v", repository, err)
+	}
+	return repository, nil
+}
+
+// repositorySettings returns the repository definition: an fs repository at
+// the configured location, or one matching the storage. Each cluster gets its
+// own directory, a repository must never be written by two clusters.
+func (e *Elasticsearch) repositorySettings() (map[string]any, error) {
+	cfg := e.Config.Elasticsearch
+	if cfg.Location != "" {
+		return map[string]any{
+			"type":     "fs",
+			"settings": map[string]any{"location": cfg.Location, "compress": true},
+		}, nil
+	}
+
+	switch e.Storage.Type {
+	case "s3":
+		client := cfg.S3Client
+		if client == "" {
+			client = "default"
+		}
+		return map[string]any{
+			"type": "s3",
+			"settings": map[string]any{
+				"bucket":    e.Storage.Path,
+				"base_path": path.Join("elasticsearch", e.label()),
+				"client":    client,
+			},
+		}, nil
+	case "local":
+		return map[string]any{
+			"type":     "fs",
+			"settings": map[string]any{"location": filepath.Join(e.Storage.Path, "elasticsearch", e.label()), "compress": true},
+		}, nil
+	default:
+		return nil, fmt.Errorf("set database.elasticsearch.location, a snapshot repository can't be derived from %s storage", e.Storage.Type)
+	}
+}
+
+// label names the backups after dbname, made safe for paths and snapshot names
+func (e *Elasticsearch) label() string {
+	label := esLabelChars.ReplaceAllString(strings.ToLower(e.Config.DBName), "-")
+	if label == "" {
+		return esDefaultLabel
+	}
+	return label
+}
+
+func (e *Elasticsearch) Close() error {
+	if e.client != nil {
+		e.client.CloseIdleConnections()
+	}
+	return nil
+}
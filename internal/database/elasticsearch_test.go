@@ -0,0 +1,304 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// fakeES is a stand-in for the snapshot API. A snapshot goes through states,
+// one per poll, and stays in the last one.
+type fakeES struct {
+	mu             sync.Mutex
+	requests       []string                  // method and path of every call
+	bodies         map[string]map[string]any // request bodies by method and path
+	states         []string
+	polls          int
+	statusPolls    int
+	restoreFailed  int
+	deleteStatus   int
+	snapshotStatus int // answer to starting a snapshot
+}
+
+func newFakeES(t *testing.T) (*fakeES, config.DatabaseConfig) {
+	t.Helper()
+	f := &fakeES{bodies: map[string]map[string]any{}, states: []string{"SUCCESS"}, deleteStatus: http.StatusOK, snapshotStatus: http.StatusOK}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	orig := esPollInterval
+	esPollInterval = time.Millisecond
+	t.Cleanup(func() { esPollInterval = orig })
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	portNum, _ := strconv.Atoi(port)
+	return f, config.DatabaseConfig{Type: "elasticsearch", Host: host, Port: portNum, DBName: "Prod Logs"}
+}
+
+func (f *fakeES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := r.Method + " " + r.URL.Path
+	f.requests = append(f.requests, call)
+	var body map[string]any
+	if json.NewDecoder(r.Body).Decode(&body) == nil {
+		f.bodies[call] = body
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/":
+		fmt.Fprint(w, `{"version":{"number":"8.15.0"}}`)
+	case r.Method == http.MethodPut && len(parts) == 2:
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case r.Method == http.MethodPut && len(parts) == 3:
+		w.WriteHeader(f.snapshotStatus)
+		if f.snapshotStatus != http.StatusOK {
+			fmt.Fprint(w, `{"error":{"type":"invalid_snapshot_name_exception","reason":"snapshot with the same name already exists"},"status":400}`)
+			return
+		}
+		fmt.Fprint(w, `{"accepted":true}`)
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[3] == "_status":
+		f.statusPolls++
+		fmt.Fprintf(w, `{"snapshots":[{"shards_stats":{"done":%d,"total":3},"stats":{"processed":{"size_in_bytes":1024},"incremental":{"size_in_bytes":4096}}}]}`, f.statusPolls)
+	case r.Method == http.MethodGet && len(parts) == 3:
+		state := f.states[min(f.polls, len(f.states)-1)]
+		f.polls++
+		if state == "" {
+			fmt.Fprint(w, `{"snapshots":[]}`)
+			return
+		}
+		snapshot := map[string]any{
+			"snapshot": parts[2],
+			"uuid":     "snap-uuid",
+			"state":    state,
+			"indices":  []string{"logs-1", "logs-2"},
+			"shards":   map[string]int{"total": 3, "failed": 0, "successful": 3},
+		}
+		if state == "PARTIAL" {
+			snapshot["shards"] = map[string]int{"total": 3, "failed": 1, "successful": 2}
+			snapshot["failures"] = []map[string]string{{"index": "logs-2", "reason": "IndexShardSnapshotFailedException"}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"snapshots": []any{snapshot}})
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[3] == "_restore":
+		if r.URL.Query().Get("wait_for_completion") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"snapshot":{"indices":["logs-1","logs-2"],"shards":{"total":3,"failed":%d}}}`, f.restoreFailed)
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(f.deleteStatus)
+		if f.deleteStatus != http.StatusOK {
+			fmt.Fprint(w, `{"error":{"type":"concurrent_snapshot_execution_exception","reason":"busy"},"status":503}`)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// calls returns the requests made so far with the given method and path prefix
+func (f *fakeES) calls(method, prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []string
+	for _, call := range f.requests {
+		if strings.HasPrefix(call, method+" "+prefix) {
+			found = append(found, call)
+		}
+	}
+	return found
+}
+
+func TestElasticsearchBackup(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fake, cfg := newFakeES(t)
+	fake.states = []string{"IN_PROGRESS", "IN_PROGRESS", "SUCCESS"}
+	cfg.Elasticsearch.Indices = []string{"logs-*", "metrics-*"}
+
+	e := NewElasticsearch(cfg, config.StorageConfig{Type: "s3", Path: "backups"})
+	file, meta, err := e.BackupWithMetadata("full", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := fake.bodies["PUT /_snapshot/dbbackup"]
+	settings, _ := repo["settings"].(map[string]any)
+	if repo["type"] != "s3" || settings["bucket"] != "backups" || settings["base_path"] != "elasticsearch/prod-logs" || settings["client"] != "default" {
+		t.Fatalf("repository %v", repo)
+	}
+
+	starts := fake.calls(http.MethodPut, "/_snapshot/dbbackup/dbbackup_")
+	if len(starts) != 1 {
+		t.Fatalf("started %v", starts)
+	}
+	snapshot := strings.TrimPrefix(starts[0], "PUT /_snapshot/dbbackup/")
+	if body := fake.bodies[starts[0]]; body["indices"] != "logs-*,metrics-*" || body["ignore_unavailable"] != true || body["include_global_state"] != false {
+		t.Fatalf("snapshot body %v", body)
+	}
+	// Progress is read between the polls, not after the last one
+	if fake.polls != 3 || fake.statusPolls != 2 {
+		t.Fatalf("%d polls and %d status requests", fake.polls, fake.statusPolls)
+	}
+
+	var manifest esManifest
+	data, _ := os.ReadFile(file)
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Snapshot != snapshot || manifest.Cluster != "prod-logs" || manifest.UUID != "snap-uuid" || manifest.State != "SUCCESS" || manifest.Shards != 3 || len(manifest.Indices) != 2 {
+		t.Fatalf("manifest %s", data)
+	}
+	if meta.Database != "prod-logs" || meta.Properties["repository"] != "dbbackup" || meta.Properties["snapshot"] != snapshot {
+		t.Fatalf("metadata %+v", meta)
+	}
+}
+
+func TestElasticsearchBackupFails(t *testing.T) {
+	tests := []struct {
+		name           string
+		states         []string
+		snapshotStatus int
+		wantErr        string
+	}{
+		{"partial snapshot", []string{"IN_PROGRESS", "PARTIAL"}, http.StatusOK, "snapshot finished PARTIAL, 1 of 3 shards failed: logs-2: IndexShardSnapshotFailedException"},
+		{"failed snapshot", []string{"FAILED"}, http.StatusOK, "snapshot finished FAILED"},
+		{"snapshot disappears", []string{"IN_PROGRESS", ""}, http.StatusOK, "disappeared from repository dbbackup"},
+		{"snapshot refused", nil, http.StatusBadRequest, "failed to start snapshot: elasticsearch returned status 400: invalid_snapshot_name_exception"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			fake, cfg := newFakeES(t)
+			if tt.states != nil {
+				fake.states = tt.states
+			}
+			fake.snapshotStatus = tt.snapshotStatus
+
+			_, _, err := NewElasticsearch(cfg, config.StorageConfig{Type: "local", Path: "/mnt/backups"}).BackupWithMetadata("full", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("BackupWithMetadata = %v, want %q", err, tt.wantErr)
+			}
+			if entries, _ := os.ReadDir("."); len(entries) != 0 {
+				t.Fatalf("wrote a manifest for a failed snapshot: %v", entries)
+			}
+		})
+	}
+}
+
+func TestElasticsearchRestore(t *testing.T) {
+	manifest := esManifest{Format: esManifestFormat, Cluster: "prod-logs", Repository: "old-repo", Snapshot: "dbbackup_20260102_150405"}
+	data, _ := json.Marshal(manifest)
+	file := t.TempDir() + "/backup_es_prod-logs_20260102_150405.json"
+	os.WriteFile(file, data, 0600)
+
+	tests := []struct {
+		name          string
+		es            config.ElasticsearchConfig
+		restoreFailed int
+		wantBody      map[string]any
+		wantErr       string
+	}{
+		{
+			name:     "everything",
+			es:       config.ElasticsearchConfig{Location: "/mnt/snapshots"},
+			wantBody: map[string]any{"ignore_unavailable": true, "include_global_state": false},
+		},
+		{
+			name: "renamed indices",
+			es:   config.ElasticsearchConfig{Location: "/mnt/snapshots", Indices: []string{"logs-*"}, RenamePattern: "logs-(.+)", RenameReplacement: "restored-logs-$1"},
+			wantBody: map[string]any{
+				"ignore_unavailable": true, "include_global_state": false, "indices": "logs-*",
+				"rename_pattern": "logs-(.+)", "rename_replacement": "restored-logs-$1",
+			},
+		},
+		{
+			name:          "failed shards",
+			es:            config.ElasticsearchConfig{Location: "/mnt/snapshots"},
+			restoreFailed: 1,
+			wantErr:       "snapshot restore failed for 1 of 3 shards",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, cfg := newFakeES(t)
+			fake.restoreFailed = tt.restoreFailed
+			cfg.Elasticsearch = tt.es
+
+			err := NewElasticsearch(cfg, config.StorageConfig{}).Restore(file)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Restore = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The snapshot is restored from the configured repository
+			if repo := fake.bodies["PUT /_snapshot/dbbackup"]; repo["type"] != "fs" {
+				t.Fatalf("repository %v", repo)
+			}
+			body := fake.bodies["POST /_snapshot/dbbackup/dbbackup_20260102_150405/_restore"]
+			if len(body) != len(tt.wantBody) {
+				t.Fatalf("restore body %v, want %v", body, tt.wantBody)
+			}
+			for k, v := range tt.wantBody {
+				if body[k] != v {
+					t.Fatalf("restore %s = %v, want %v", k, body[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestElasticsearchDeleteBackup(t *testing.T) {
+	snapshot := map[string]string{"repository": "dbbackup", "snapshot": "dbbackup_20260102_150405"}
+
+	tests := []struct {
+		name    string
+		meta    *Metadata
+		status  int
+		deletes int
+		wantErr string
+	}{
+		{"deletes the snapshot", &Metadata{Provider: esProviderName, Properties: snapshot}, http.StatusOK, 1, ""},
+		{"snapshot already gone", &Metadata{Provider: esProviderName, Properties: snapshot}, http.StatusNotFound, 1, ""},
+		{"cluster error", &Metadata{Provider: esProviderName, Properties: snapshot}, http.StatusServiceUnavailable, 1, "status 503: concurrent_snapshot_execution_exception"},
+		{"other provider", &Metadata{Provider: chProviderName, Properties: snapshot}, http.StatusOK, 0, ""},
+		{"no snapshot", &Metadata{Provider: esProviderName}, http.StatusOK, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, cfg := newFakeES(t)
+			fake.deleteStatus = tt.status
+
+			err := NewElasticsearch(cfg, config.StorageConfig{}).DeleteBackup(newMemStorage(), tt.meta)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DeleteBackup = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			deletes := fake.calls(http.MethodDelete, "/")
+			if len(deletes) != tt.deletes {
+				t.Fatalf("deletes %v", deletes)
+			}
+			if tt.deletes > 0 && deletes[0] != "DELETE /_snapshot/dbbackup/dbbackup_20260102_150405" {
+				t.Fatalf("deleted %s", deletes[0])
+			}
+		})
+	}
+}
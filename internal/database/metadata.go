@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/storage"
+)
+
+// MetadataSuffix is appended to an artifact name for its metadata sidecar
+const MetadataSuffix = ".meta.json"
+
+// Metadata describes a backup artifact. It is uploaded next to the artifact as
+// <artifact>.meta.json and lets a later backup build on it.
+type Metadata struct {
+	Artifact   string            `json:"artifact"`
+	Provider   string            `json:"provider"`
+	Database   string            `json:"database,omitempty"` // empty for server-wide backups
+	Type       string            `json:"type"`               // full, incremental or differential
+	Base       string            `json:"base,omitempty"`     // artifact an incremental or differential backup applies on top of
+	Created    time.Time         `json:"created"`
+	Properties map[string]string `json:"properties,omitempty"` // provider-specific, e.g. the xtrabackup LSNs
+}
+
+// IsMetadata reports whether a storage path is a metadata sidecar
+func IsMetadata(path string) bool {
+	return strings.HasSuffix(path, MetadataSuffix)
+}
+
+// MetadataBackuper is implemented by providers that record metadata with each
+// backup. previous holds the metadata of earlier backups of the same provider,
+// newest first; incremental backups are based on it.
+type MetadataBackuper interface {
+	BackupWithMetadata(backupType string, previous []*Metadata) (string, *Metadata, error)
+}
+
+// ChainRestorer is implemented by providers whose backups can depend on
+// earlier ones. files are the local artifacts ordered from the full backup to
+// the one being restored.
+type ChainRestorer interface {
+	RestoreChain(files []string) error
+}
+
+// Pruner is implemented by providers whose backups live outside the
+// artifact, such as snapshots in a repository the cluster writes to. Prune
+// calls DeleteBackup with the storage and the metadata of an expired artifact
+// before deleting it; metadata of other providers is ignored.
+type Pruner interface {
+	DeleteBackup(st storage.Storage, meta *Metadata) error
+}
+
+// ErrPointUnavailable is returned by RestoreToPoint for a point older than the
+// history the provider keeps
+var ErrPointUnavailable = errors.New("point in time is outside the database history")
+
+// PointInTimeRestorer is implemented by providers that can roll a database
+// back in place, such as D1 with Time Travel.
+type PointInTimeRestorer interface {
+	// RestoreToPoint rolls the database back to a bookmark, or to the time at
+	// when bookmark is empty
+	RestoreToPoint(bookmark string, at time.Time) error
+
+	// HasBackup reports whether a backup was taken from this database. Points
+	// beyond the history are restored from the newest such backup before them.
+	HasBackup(meta *Metadata) bool
+}
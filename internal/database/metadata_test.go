@@ -0,0 +1,82 @@
+package database
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memStorage is an in-memory bucket for the providers that clean up data
+// they wrote next to the artifacts
+type memStorage struct {
+	objects map[string]string
+}
+
+func newMemStorage(keys ...string) *memStorage {
+	m := &memStorage{objects: map[string]string{}}
+	for _, key := range keys {
+		m.objects[key] = "data"
+	}
+	return m
+}
+
+func (m *memStorage) Upload(srcPath, destPath string) error {
+	data, err := os.ReadFile(srcPath)
+	m.objects[destPath] = string(data)
+	return err
+}
+
+func (m *memStorage) Download(srcPath, destPath string) error {
+	return os.WriteFile(destPath, []byte(m.objects[srcPath]), 0600)
+}
+
+func (m *memStorage) List(prefix string) ([]string, error) {
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memStorage) Delete(path string) error {
+	delete(m.objects, path)
+	return nil
+}
+
+func (m *memStorage) GetReader(path string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(m.objects[path])), nil
+}
+
+// deleteBackupCase is one call of a Pruner's DeleteBackup and the keys it must
+// delete from the bucket
+type deleteBackupCase struct {
+	name    string
+	pruner  Pruner
+	meta    *Metadata
+	deleted []string
+}
+
+// testDeleteBackup runs each case against a fresh bucket holding keys and
+// checks that exactly the case's keys are gone afterwards
+func testDeleteBackup(t *testing.T, keys []string, tests []deleteBackupCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newMemStorage(keys...)
+			if err := tt.pruner.DeleteBackup(st, tt.meta); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range keys {
+				_, exists := st.objects[key]
+				if gone := contains(tt.deleted, key); exists == gone {
+					t.Errorf("%s exists = %v", key, exists)
+				}
+			}
+		})
+	}
+}
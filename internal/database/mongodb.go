@@ -2,12 +2,19 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/url"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -21,9 +28,63 @@ func NewMongoDB(cfg config.DatabaseConfig) *MongoDB {
 	return &MongoDB{Config: cfg}
 }
 
+// connectionURI returns the connection string shared by the driver and the
+// tools. Credentials are URL-escaped, so passwords may contain @, : or /.
+func (m *MongoDB) connectionURI() (*url.URL, error) {
+	cfg := m.Config.MongoDB
+
+	var u *url.URL
+	if cfg.URI != "" {
+		parsed, err := url.Parse(cfg.URI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mongodb uri: %v", err)
+		}
+		if parsed.Scheme != "mongodb" && parsed.Scheme != "mongodb+srv" {
+			return nil, fmt.Errorf("mongodb uri must start with mongodb:// or mongodb+srv://")
+		}
+		u = parsed
+	} else {
+		u = &url.URL{Scheme: "mongodb", Host: m.Config.Host, Path: "/"}
+		if cfg.SRV {
+			// SRV names carry no port
+			u.Scheme = "mongodb+srv"
+		} else if m.Config.Port != 0 && !strings.Contains(m.Config.Host, ",") {
+			u.Host = net.JoinHostPort(m.Config.Host, strconv.Itoa(m.Config.Port))
+		}
+	}
+
+	if u.User == nil && m.Config.User != "" {
+		u.User = url.UserPassword(m.Config.User, m.Config.Password)
+	}
+
+	query := u.Query()
+	setDefault := func(key, value string) {
+		if value != "" && query.Get(key) == "" {
+			query.Set(key, value)
+		}
+	}
+	setDefault("authSource", cfg.AuthSource)
+	setDefault("replicaSet", cfg.ReplicaSet)
+	setDefault("readPreference", cfg.ReadPreference)
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
 func (m *MongoDB) Connect() error {
-	uri := fmt.Sprintf("mongodb://%s:%s@%s:%d", m.Config.User, m.Config.Password, m.Config.Host, m.Config.Port)
-	clientOptions := options.Client().ApplyURI(uri)
+	uri, err := m.connectionURI()
+	if err != nil {
+		return err
+	}
+	clientOptions := options.Client().ApplyURI(uri.String())
+
+	tlsCfg, err := m.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if tlsCfg != nil {
+		clientOptions.SetTLSConfig(tlsCfg)
+	}
+
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
 		return err
@@ -32,6 +93,13 @@ func (m *MongoDB) Connect() error {
 	return nil
 }
 
+// tlsConfig leaves the server name empty unless tls.server_name is set, so
+// the driver checks each replica set member, or each host behind an SRV
+// record, against its own name
+func (m *MongoDB) tlsConfig() (*tls.Config, error) {
+	return newTLSConfig(m.Config.TLS, "")
+}
+
 func (m *MongoDB) TestConnection() error {
 	if m.client == nil {
 		if err := m.Connect(); err != nil {
@@ -45,15 +113,30 @@ func (m *MongoDB) Backup(backupType string) (string, error) {
 	// mongodump creates a directory by default, we should probably zip it or just use --archive
 	filename := fmt.Sprintf("backup_mongo_%s_%s.archive", m.Config.DBName, time.Now().Format("20060102_150405"))
 	
+	// The read preference in the URI lets the dump run against a secondary
+	uri, err := m.connectionURI()
+	if err != nil {
+		return "", err
+	}
+	configFile, cleanup, err := mongoConfigFile(uri.String())
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
 	args := []string{
-		fmt.Sprintf("--host=%s", m.Config.Host),
-		fmt.Sprintf("--port=%d", m.Config.Port),
-		fmt.Sprintf("--username=%s", m.Config.User),
-		fmt.Sprintf("--password=%s", m.Config.Password),
+		"--config=" + configFile,
 		fmt.Sprintf("--db=%s", m.Config.DBName),
 		fmt.Sprintf("--archive=%s", filename),
 	}
 
+	tlsArgs, cleanupTLS, err := mongoTLSArgs(m.Config.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer cleanupTLS()
+	args = append(args, tlsArgs...)
+
 	cmdName := "mongodump"
 	if m.Config.ToolPath != "" {
 		cmdName = m.Config.ToolPath
@@ -62,21 +145,40 @@ func (m *MongoDB) Backup(backupType string) (string, error) {
 	cmd := exec.Command(cmdName, args...)
 	
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("mongodump failed: %v, output: %s", err, string(output))
+		return "", fmt.Errorf("mongodump failed: %v, output: %s", err, logger.Redact(string(output)))
 	}
 
 	return filename, nil
 }
 
 func (m *MongoDB) Restore(backupFile string) error {
+	uri, err := m.connectionURI()
+	if err != nil {
+		return err
+	}
+	// Writes always go to the primary
+	query := uri.Query()
+	query.Del("readPreference")
+	uri.RawQuery = query.Encode()
+
+	configFile, cleanup, err := mongoConfigFile(uri.String())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	args := []string{
-		fmt.Sprintf("--host=%s", m.Config.Host),
-		fmt.Sprintf("--port=%d", m.Config.Port),
-		fmt.Sprintf("--username=%s", m.Config.User),
-		fmt.Sprintf("--password=%s", m.Config.Password),
+		"--config=" + configFile,
 		fmt.Sprintf("--archive=%s", backupFile),
 	}
 
+	tlsArgs, cleanupTLS, err := mongoTLSArgs(m.Config.TLS)
+	if err != nil {
+		return err
+	}
+	defer cleanupTLS()
+	args = append(args, tlsArgs...)
+
 	cmdName := "mongorestore"
 	if m.Config.ToolPath != "" {
 		dir := filepath.Dir(m.Config.ToolPath)
@@ -86,12 +188,33 @@ func (m *MongoDB) Restore(backupFile string) error {
 	cmd := exec.Command(cmdName, args...)
 	
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("mongorestore failed: %v, output: %s", err, string(output))
+		return fmt.Errorf("mongorestore failed: %v, output: %s", err, logger.Redact(string(output)))
 	}
 
 	return nil
 }
 
+var mongoSystemDatabases = map[string]bool{"admin": true, "local": true, "config": true}
+
+func (m *MongoDB) ListDatabases() ([]string, error) {
+	if !discoveryEnabled(m.Config) {
+		return nil, nil
+	}
+	if err := m.TestConnection(); err != nil {
+		return nil, err
+	}
+
+	names, err := m.client.ListDatabaseNames(context.TODO(), bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %v", err)
+	}
+	return selectDatabases(m.Config, names, mongoSystemDatabases)
+}
+
+func (m *MongoDB) ForDatabase(name string) Database {
+	return NewMongoDB(forDatabase(m.Config, name))
+}
+
 func (m *MongoDB) Close() error {
 	if m.client != nil {
 		return m.client.Disconnect(context.TODO())
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+func TestMongoDBTLSServerName(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  config.TLSConfig
+		want string
+	}{
+		{"each member checked against its own name", config.TLSConfig{Mode: tlsVerifyFull}, ""},
+		{"configured name", config.TLSConfig{Mode: tlsVerifyFull, ServerName: "mongo.internal"}, "mongo.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMongoDB(config.DatabaseConfig{Host: "cluster0.example.mongodb.net", TLS: tt.tls})
+			tlsCfg, err := m.tlsConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tlsCfg.ServerName != tt.want {
+				t.Fatalf("ServerName = %q, want %q", tlsCfg.ServerName, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,544 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+)
+
+const (
+	mssqlModeBacpac    = "bacpac"
+	mssqlBacpacSuffix  = ".bacpac"
+	mssqlProviderName  = "mssql"
+	mssqlMaintenanceDB = "master"
+)
+
+var mssqlSystemDatabases = map[string]bool{"master": true, "model": true, "msdb": true, "tempdb": true}
+
+// MSSQL backs up SQL Server with BACKUP DATABASE. The server writes the .bak
+// file into backup_dir, which must be shared with this host (share_path) so
+// the file can be collected and uploaded. The bacpac mode exports a logical
+// copy with sqlpackage instead.
+type MSSQL struct {
+	Config config.DatabaseConfig
+	conn   *sql.DB
+}
+
+func NewMSSQL(cfg config.DatabaseConfig) *MSSQL {
+	return &MSSQL{Config: cfg}
+}
+
+func (s *MSSQL) Connect() error {
+	// BACKUP and RESTORE name the database themselves, and restore must work
+	// before it exists
+	query := url.Values{}
+	query.Set("database", mssqlMaintenanceDB)
+	query.Set("app name", "dbbackup")
+	dsn := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(s.Config.User, s.Config.Password),
+		Host:     fmt.Sprintf("%s:%d", s.Config.Host, s.Config.Port),
+		RawQuery: query.Encode(),
+	}
+	if s.Config.ExtraParams != "" {
+		dsn.RawQuery += "&" + s.Config.ExtraParams
+	}
+
+	params, err := msdsn.Parse(dsn.String())
+	if err != nil {
+		return fmt.Errorf("invalid sql server connection settings: %v", logger.Redact(err.Error()))
+	}
+	switch s.Config.TLS.Mode {
+	case "":
+	case tlsDisable:
+		params.Encryption = msdsn.EncryptionDisabled
+	default:
+		tlsCfg, err := newTLSConfig(s.Config.TLS, s.Config.Host)
+		if err != nil {
+			return err
+		}
+		params.Encryption = msdsn.EncryptionRequired
+		params.TLSConfig = tlsCfg
+		params.HostInCertificateProvided = true
+	}
+
+	s.conn = sql.OpenDB(mssql.NewConnectorConfig(params))
+	return nil
+}
+
+func (s *MSSQL) TestConnection() error {
+	if s.conn == nil {
+		if err := s.Connect(); err != nil {
+			return err
+		}
+	}
+	return s.conn.Ping()
+}
+
+func (s *MSSQL) Backup(backupType string) (string, error) {
+	filename, _, err := s.BackupWithMetadata(backupType, nil)
+	return filename, err
+}
+
+// BackupWithMetadata takes a full backup, or a differential one on top of the
+// last full backup. The checkpoint LSN of each full backup is recorded, so a
+// differential can be checked against the base its restore will need.
+func (s *MSSQL) BackupWithMetadata(backupType string, previous []*Metadata) (string, *Metadata, error) {
+	if s.Config.MSSQL.Mode == mssqlModeBacpac {
+		filename, err := s.exportBacpac()
+		return filename, nil, err
+	}
+	if s.Config.MSSQL.BackupDir == "" {
+		return "", nil, fmt.Errorf("set database.mssql.backup_dir to the directory SQL Server writes backups to")
+	}
+	if err := s.TestConnection(); err != nil {
+		return "", nil, err
+	}
+
+	base := s.baseBackup(backupType, previous)
+	if backupType != "full" && base == nil {
+		logger.Warn("No previous full backup found, taking a full backup", "type", backupType)
+	}
+
+	filename := fmt.Sprintf("backup_mssql_%s_%s.bak", s.Config.DBName, time.Now().Format("20060102_150405"))
+	serverFile := s.serverPath(filename)
+
+	opts := []string{"INIT", "CHECKSUM"}
+	if s.Config.MSSQL.Compression {
+		opts = append(opts, "COMPRESSION")
+	}
+	if base != nil {
+		opts = append(opts, "DIFFERENTIAL")
+	} else if s.Config.MSSQL.CopyOnly {
+		opts = append(opts, "COPY_ONLY")
+	}
+	stmt := fmt.Sprintf("BACKUP DATABASE %s TO DISK = @p1 WITH %s", msIdent(s.Config.DBName), strings.Join(opts, ", "))
+	if _, err := s.conn.Exec(stmt, serverFile); err != nil {
+		// A failed backup can leave a partial file behind
+		os.Remove(s.sharePath(filename))
+		return "", nil, fmt.Errorf("sql server backup failed: %v", err)
+	}
+
+	header, err := s.restoreHeader(serverFile)
+	if err != nil {
+		os.Remove(s.sharePath(filename))
+		return "", nil, err
+	}
+	if err := moveFile(s.sharePath(filename), filename); err != nil {
+		return "", nil, fmt.Errorf("failed to collect %s from the backup share: %v", filename, err)
+	}
+
+	meta := &Metadata{
+		Provider: mssqlProviderName,
+		Database: s.Config.DBName,
+		Type:     "full",
+		Created:  time.Now().UTC(),
+		Properties: map[string]string{
+			"checkpoint_lsn": header["CheckpointLSN"],
+			"copy_only":      strconv.FormatBool(base == nil && s.Config.MSSQL.CopyOnly),
+		},
+	}
+	if base != nil {
+		// SQL Server bases a differential on the last full backup that isn't
+		// copy-only, which may be one taken outside dbbackup
+		if header["DifferentialBaseLSN"] != base.Properties["checkpoint_lsn"] {
+			os.Remove(filename)
+			return "", nil, fmt.Errorf("differential backup is based on a full backup dbbackup didn't take (base LSN %s), take a full backup first", header["DifferentialBaseLSN"])
+		}
+		meta.Type = "differential"
+		meta.Base = base.Artifact
+	}
+	return filename, meta, nil
+}
+
+// baseBackup picks the full backup a differential is based on. Copy-only
+// backups can't be a differential base.
+func (s *MSSQL) baseBackup(backupType string, previous []*Metadata) *Metadata {
+	if backupType == "full" {
+		return nil
+	}
+	for _, meta := range previous {
+		if meta.Provider != mssqlProviderName || meta.Database != s.Config.DBName || meta.Type != "full" {
+			continue
+		}
+		if meta.Properties["copy_only"] == "true" || meta.Properties["checkpoint_lsn"] == "" {
+			continue
+		}
+		return meta
+	}
+	return nil
+}
+
+// restoreHeader reads the backup set header of a .bak file on the server
+func (s *MSSQL) restoreHeader(serverFile string) (map[string]string, error) {
+	rows, err := s.queryRows("RESTORE HEADERONLY FROM DISK = @p1", serverFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup header: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s holds no backup set", serverFile)
+	}
+	return rows[0], nil
+}
+
+func (s *MSSQL) Restore(backupFile string) error {
+	if strings.HasSuffix(backupFile, mssqlBacpacSuffix) {
+		return s.importBacpac(backupFile)
+	}
+	return s.RestoreChain([]string{backupFile})
+}
+
+// RestoreChain restores a full backup and the differential on top of it.
+// Every backup but the last is restored WITH NORECOVERY; an existing database
+// is replaced, and its files moved to data_dir and log_dir.
+func (s *MSSQL) RestoreChain(files []string) (err error) {
+	if err := s.TestConnection(); err != nil {
+		return err
+	}
+	if s.Config.MSSQL.BackupDir == "" {
+		return fmt.Errorf("set database.mssql.backup_dir to the directory SQL Server reads backups from")
+	}
+
+	var exists int
+	if err := s.conn.QueryRow("SELECT count(*) FROM sys.databases WHERE name = @p1", s.Config.DBName).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		// Disconnect other sessions, which would block the restore
+		stmt := fmt.Sprintf("ALTER DATABASE %s SET SINGLE_USER WITH ROLLBACK IMMEDIATE", msIdent(s.Config.DBName))
+		if _, err := s.conn.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to disconnect sessions from %s: %v", s.Config.DBName, err)
+		}
+		// A failed restore leaves the old database in place, let the other
+		// sessions back in. A successful one takes the setting from the backup.
+		defer func() {
+			if err == nil {
+				return
+			}
+			stmt := fmt.Sprintf("ALTER DATABASE %s SET MULTI_USER", msIdent(s.Config.DBName))
+			if _, alterErr := s.conn.Exec(stmt); alterErr != nil {
+				logger.Warn("Failed to set the database back to multi-user", "database", s.Config.DBName, "error", alterErr)
+			}
+		}()
+	}
+
+	for i, file := range files {
+		last := i == len(files)-1
+		logger.Info("Restoring SQL Server backup", "file", filepath.Base(file), "recovery", last)
+		if err := s.restoreFile(file, i == 0, last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFile runs RESTORE DATABASE for one .bak file. The first file of a
+// chain places the database files, the last one brings it online.
+func (s *MSSQL) restoreFile(backupFile string, first, last bool) error {
+	serverFile, cleanup, err := s.stage(backupFile)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	args := []any{serverFile}
+	var opts []string
+	if first {
+		moves, err := s.moveOptions(serverFile)
+		if err != nil {
+			return err
+		}
+		for _, move := range moves {
+			opts = append(opts, fmt.Sprintf("MOVE @p%d TO @p%d", len(args)+1, len(args)+2))
+			args = append(args, move[0], move[1])
+		}
+		opts = append(opts, "REPLACE")
+	}
+	if last {
+		opts = append(opts, "RECOVERY")
+	} else {
+		opts = append(opts, "NORECOVERY")
+	}
+
+	stmt := fmt.Sprintf("RESTORE DATABASE %s FROM DISK = @p1 WITH %s", msIdent(s.Config.DBName), strings.Join(opts, ", "))
+	if _, err := s.conn.Exec(stmt, args...); err != nil {
+		return fmt.Errorf("sql server restore failed: %v", err)
+	}
+	return nil
+}
+
+// moveOptions maps each logical file in the backup to a physical file named
+// after the target database, so a copy can be restored next to the original
+func (s *MSSQL) moveOptions(serverFile string) ([][2]string, error) {
+	files, err := s.queryRows("RESTORE FILELISTONLY FROM DISK = @p1", serverFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file list: %v", err)
+	}
+
+	dataDir, logDir := s.Config.MSSQL.DataDir, s.Config.MSSQL.LogDir
+	if dataDir == "" || logDir == "" {
+		var defaultData, defaultLog sql.NullString
+		err := s.conn.QueryRow("SELECT CAST(SERVERPROPERTY('InstanceDefaultDataPath') AS nvarchar(4000)), CAST(SERVERPROPERTY('InstanceDefaultLogPath') AS nvarchar(4000))").Scan(&defaultData, &defaultLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read default file locations: %v", err)
+		}
+		if dataDir == "" {
+			dataDir = defaultData.String
+		}
+		if logDir == "" {
+			logDir = defaultLog.String
+		}
+	}
+
+	var moves [][2]string
+	for _, file := range files {
+		dir := dataDir
+		if file["Type"] == "L" {
+			dir = logDir
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("server has no default location for %s, set database.mssql.data_dir and log_dir", file["LogicalName"])
+		}
+		ext := path.Ext(strings.ReplaceAll(file["PhysicalName"], `\`, "/"))
+		moves = append(moves, [2]string{file["LogicalName"], serverJoin(dir, s.Config.DBName+"_"+file["LogicalName"]+ext)})
+	}
+	return moves, nil
+}
+
+// queryRows runs a statement like RESTORE FILELISTONLY, whose many columns
+// vary between versions, and returns the rows as strings by column name
+func (s *MSSQL) queryRows(stmt string, args ...any) ([]map[string]string, error) {
+	rows, err := s.conn.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(columns))
+		for i, col := range columns {
+			switch v := values[i].(type) {
+			case nil:
+			case []byte:
+				row[col] = string(v)
+			default:
+				row[col] = fmt.Sprint(v)
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// stage copies a local backup file onto the share and returns its path as the
+// server sees it. The copy is world-readable, SQL Server usually runs as
+// another user.
+func (s *MSSQL) stage(backupFile string) (string, func(), error) {
+	name := filepath.Base(backupFile)
+	shared := s.sharePath(name)
+	if err := copyFile(backupFile, shared, 0644); err != nil {
+		return "", nil, fmt.Errorf("failed to copy %s to the backup share: %v", name, err)
+	}
+	return s.serverPath(name), func() { os.Remove(shared) }, nil
+}
+
+// serverPath is a file in backup_dir as SQL Server sees it
+func (s *MSSQL) serverPath(name string) string {
+	return serverJoin(s.Config.MSSQL.BackupDir, name)
+}
+
+// sharePath is a file in backup_dir as this host sees it
+func (s *MSSQL) sharePath(name string) string {
+	dir := s.Config.MSSQL.SharePath
+	if dir == "" {
+		dir = s.Config.MSSQL.BackupDir
+	}
+	return filepath.Join(dir, name)
+}
+
+// serverJoin joins a path on the server, which may be Windows or Linux
+func serverJoin(dir, name string) string {
+	if strings.Contains(dir, `\`) {
+		return strings.TrimRight(dir, `\`) + `\` + name
+	}
+	return path.Join(dir, name)
+}
+
+// exportBacpac exports the schema and data with sqlpackage
+func (s *MSSQL) exportBacpac() (string, error) {
+	filename := fmt.Sprintf("backup_mssql_%s_%s%s", s.Config.DBName, time.Now().Format("20060102_150405"), mssqlBacpacSuffix)
+	err := s.sqlpackage("/SourceConnectionString", "/Action:Export", "/TargetFile:"+filename)
+	if err != nil {
+		os.Remove(filename)
+		return "", err
+	}
+	return filename, nil
+}
+
+// importBacpac imports a bacpac into a new database
+func (s *MSSQL) importBacpac(backupFile string) error {
+	if err := s.TestConnection(); err != nil {
+		return err
+	}
+	var exists int
+	if err := s.conn.QueryRow("SELECT count(*) FROM sys.databases WHERE name = @p1", s.Config.DBName).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fmt.Errorf("database %s already exists; drop it first or import under another name with --dbname", s.Config.DBName)
+	}
+	return s.sqlpackage("/TargetConnectionString", "/Action:Import", "/SourceFile:"+backupFile)
+}
+
+// sqlpackage runs sqlpackage with the connection string in a response file,
+// which keeps the password off the command line
+func (s *MSSQL) sqlpackage(connParam string, args ...string) error {
+	responseFile, cleanup, err := writeOptionFile("dbbackup-sqlpackage-*.rsp", fmt.Sprintf("%s:\"%s\"\n", connParam, s.connectionString()))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	tool := "sqlpackage"
+	if s.Config.ToolPath != "" {
+		tool = s.Config.ToolPath
+	}
+	cmd := exec.Command(tool, append(args, "@"+responseFile)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sqlpackage failed: %v, output: %s", err, logger.Redact(string(output)))
+	}
+	return nil
+}
+
+// connectionString builds the ADO.NET connection string for sqlpackage.
+// sqlpackage verifies certificates against the system trust store, tls.ca_file
+// doesn't apply to it.
+func (s *MSSQL) connectionString() string {
+	parts := []string{
+		"Server=tcp:" + adoValue(fmt.Sprintf("%s,%d", s.Config.Host, s.Config.Port)),
+		"Database=" + adoValue(s.Config.DBName),
+		"User ID=" + adoValue(s.Config.User),
+		"Password=" + adoValue(s.Config.Password),
+	}
+	switch s.Config.TLS.Mode {
+	case "":
+	case tlsDisable:
+		parts = append(parts, "Encrypt=False")
+	case tlsRequire:
+		parts = append(parts, "Encrypt=True", "TrustServerCertificate=True")
+	default:
+		parts = append(parts, "Encrypt=True", "TrustServerCertificate=False")
+		if s.Config.TLS.ServerName != "" {
+			parts = append(parts, "HostNameInCertificate="+adoValue(s.Config.TLS.ServerName))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// adoValue quotes a connection string value that contains separators
+func adoValue(v string) string {
+	if !strings.ContainsAny(v, `;'"=`) && strings.TrimSpace(v) == v {
+		return v
+	}
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// msIdent quotes a SQL Server identifier
+func msIdent(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+func (s *MSSQL) ListDatabases() ([]string, error) {
+	if !discoveryEnabled(s.Config) {
+		return nil, nil
+	}
+	if err := s.TestConnection(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.conn.Query("SELECT name FROM sys.databases WHERE state_desc = 'ONLINE'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return selectDatabases(s.Config, names, mssqlSystemDatabases)
+}
+
+func (s *MSSQL) ForDatabase(name string) Database {
+	return NewMSSQL(forDatabase(s.Config, name))
+}
+
+// moveFile renames a file, copying it when the share is another file system
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst, 0600); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func (s *MSSQL) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
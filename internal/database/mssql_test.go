@@ -0,0 +1,419 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// fakeSQLServer is a database/sql stand-in that records the statements it
+// gets. exec and query decide how each one goes.
+type fakeSQLServer struct {
+	mu         sync.Mutex
+	statements []string
+	exec       func(stmt string, args []driver.NamedValue) error
+	query      func(stmt string, args []driver.NamedValue) (*fakeRows, error)
+}
+
+func (f *fakeSQLServer) Open(string) (driver.Conn, error)             { return fakeSQLConn{f}, nil }
+func (f *fakeSQLServer) Connect(context.Context) (driver.Conn, error) { return fakeSQLConn{f}, nil }
+func (f *fakeSQLServer) Driver() driver.Driver                        { return f }
+
+func (f *fakeSQLServer) record(stmt string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = append(f.statements, stmt)
+}
+
+// executed returns the statements run so far that contain substr
+func (f *fakeSQLServer) executed(substr string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []string
+	for _, stmt := range f.statements {
+		if strings.Contains(stmt, substr) {
+			found = append(found, stmt)
+		}
+	}
+	return found
+}
+
+type fakeSQLConn struct{ f *fakeSQLServer }
+
+func (c fakeSQLConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeSQLConn) Close() error                        { return nil }
+func (c fakeSQLConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeSQLConn) ExecContext(_ context.Context, stmt string, args []driver.NamedValue) (driver.Result, error) {
+	c.f.record(stmt)
+	if c.f.exec == nil {
+		return driver.RowsAffected(0), nil
+	}
+	return driver.RowsAffected(0), c.f.exec(stmt, args)
+}
+
+func (c fakeSQLConn) QueryContext(_ context.Context, stmt string, args []driver.NamedValue) (driver.Rows, error) {
+	c.f.record(stmt)
+	if c.f.query == nil {
+		return nil, fmt.Errorf("unexpected query %s", stmt)
+	}
+	return c.f.query(stmt, args)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// newFakeMSSQL returns a provider on a fake server with backup_dir in a
+// temporary directory the test works in
+func newFakeMSSQL(t *testing.T, fake *fakeSQLServer) (*MSSQL, string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	share := t.TempDir()
+	s := NewMSSQL(config.DatabaseConfig{
+		Type:   "mssql",
+		DBName: "app",
+		MSSQL:  config.MSSQLConfig{BackupDir: share, DataDir: "/var/opt/mssql/data", LogDir: "/var/opt/mssql/log"},
+	})
+	s.conn = sql.OpenDB(fake)
+	t.Cleanup(func() { s.Close() })
+	return s, share
+}
+
+// backupHeader answers RESTORE HEADERONLY with the given LSNs
+func backupHeader(checkpoint, differentialBase string) func(string, []driver.NamedValue) (*fakeRows, error) {
+	return func(stmt string, args []driver.NamedValue) (*fakeRows, error) {
+		if !strings.HasPrefix(stmt, "RESTORE HEADERONLY") {
+			return nil, fmt.Errorf("unexpected query %s", stmt)
+		}
+		return &fakeRows{
+			columns: []string{"CheckpointLSN", "DifferentialBaseLSN"},
+			rows:    [][]driver.Value{{checkpoint, differentialBase}},
+		}, nil
+	}
+}
+
+// writeBackup makes BACKUP write a file to backup_dir and fail with err
+func writeBackup(err error) func(string, []driver.NamedValue) error {
+	return func(stmt string, args []driver.NamedValue) error {
+		if strings.HasPrefix(stmt, "BACKUP DATABASE") {
+			os.WriteFile(args[0].Value.(string), []byte("TAPE"), 0600)
+		}
+		return err
+	}
+}
+
+func TestMSSQLBackupRemovesPartialFile(t *testing.T) {
+	fake := &fakeSQLServer{exec: writeBackup(errors.New("There is insufficient free space on disk volume"))}
+	s, share := newFakeMSSQL(t, fake)
+
+	_, _, err := s.BackupWithMetadata("full", nil)
+	if err == nil || !strings.Contains(err.Error(), "sql server backup failed") {
+		t.Fatalf("BackupWithMetadata = %v", err)
+	}
+	if entries, _ := os.ReadDir(share); len(entries) != 0 {
+		t.Fatalf("partial backup left on the share: %v", entries)
+	}
+}
+
+func TestMSSQLBackupDifferential(t *testing.T) {
+	full := &Metadata{
+		Artifact:   "backup_mssql_app_20260102_150405.bak.gz",
+		Provider:   mssqlProviderName,
+		Database:   "app",
+		Type:       "full",
+		Properties: map[string]string{"checkpoint_lsn": "42000000012300037", "copy_only": "false"},
+	}
+	copyOnly := &Metadata{
+		Artifact:   "backup_mssql_app_20260103_150405.bak.gz",
+		Provider:   mssqlProviderName,
+		Database:   "app",
+		Type:       "full",
+		Properties: map[string]string{"checkpoint_lsn": "42000000015600037", "copy_only": "true"},
+	}
+
+	fake := &fakeSQLServer{exec: writeBackup(nil), query: backupHeader("42000000017800001", "42000000012300037")}
+	s, share := newFakeMSSQL(t, fake)
+
+	file, meta, err := s.BackupWithMetadata("differential", []*Metadata{copyOnly, full})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Type != "differential" || meta.Base != full.Artifact {
+		t.Fatalf("metadata %+v", meta)
+	}
+	if backups := fake.executed("BACKUP DATABASE"); len(backups) != 1 || !strings.Contains(backups[0], "DIFFERENTIAL") {
+		t.Fatalf("backup statements %v", backups)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("backup was not collected: %v", err)
+	}
+	if entries, _ := os.ReadDir(share); len(entries) != 0 {
+		t.Fatalf("backup left on the share: %v", entries)
+	}
+}
+
+func TestMSSQLBackupDifferentialOnForeignBase(t *testing.T) {
+	full := &Metadata{Artifact: "full", Provider: mssqlProviderName, Database: "app", Type: "full", Properties: map[string]string{"checkpoint_lsn": "100"}}
+	fake := &fakeSQLServer{exec: writeBackup(nil), query: backupHeader("300", "200")}
+	s, share := newFakeMSSQL(t, fake)
+
+	_, _, err := s.BackupWithMetadata("differential", []*Metadata{full})
+	if err == nil || !strings.Contains(err.Error(), "full backup dbbackup didn't take (base LSN 200)") {
+		t.Fatalf("BackupWithMetadata = %v", err)
+	}
+	for _, dir := range []string{".", share} {
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Fatalf("left %v in %s", entries, dir)
+		}
+	}
+}
+
+func TestMSSQLRestoreChain(t *testing.T) {
+	fileList := &fakeRows{
+		columns: []string{"LogicalName", "PhysicalName", "Type"},
+		rows: [][]driver.Value{
+			{"app", `C:\Program Files\Microsoft SQL Server\MSSQL16.MSSQLSERVER\MSSQL\DATA\app.mdf`, "D"},
+			{"app_log", `C:\Program Files\Microsoft SQL Server\MSSQL16.MSSQLSERVER\MSSQL\DATA\app_log.ldf`, "L"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		exists     int64
+		restoreErr error
+		wantErr    bool
+		multiUser  bool
+	}{
+		{name: "new database", exists: 0},
+		{name: "replaces an existing database", exists: 1},
+		{name: "failure lets sessions back in", exists: 1, restoreErr: errors.New("The media family on device is incorrectly formed"), wantErr: true, multiUser: true},
+		{name: "failure without an existing database", exists: 0, restoreErr: errors.New("incorrectly formed"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSQLServer{
+				exec: func(stmt string, args []driver.NamedValue) error {
+					if strings.HasPrefix(stmt, "RESTORE DATABASE") {
+						return tt.restoreErr
+					}
+					return nil
+				},
+				query: func(stmt string, args []driver.NamedValue) (*fakeRows, error) {
+					switch {
+					case strings.Contains(stmt, "sys.databases"):
+						return &fakeRows{columns: []string{""}, rows: [][]driver.Value{{tt.exists}}}, nil
+					case strings.HasPrefix(stmt, "RESTORE FILELISTONLY"):
+						rows := *fileList
+						return &rows, nil
+					}
+					return nil, fmt.Errorf("unexpected query %s", stmt)
+				},
+			}
+			s, share := newFakeMSSQL(t, fake)
+			full, diff := "backup_mssql_app_20260102_150405.bak", "backup_mssql_app_20260103_150405.bak"
+			os.WriteFile(full, []byte("TAPE"), 0600)
+			os.WriteFile(diff, []byte("TAPE"), 0600)
+
+			err := s.RestoreChain([]string{full, diff})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RestoreChain = %v", err)
+			}
+
+			singleUser := len(fake.executed("SET SINGLE_USER")) > 0
+			if singleUser != (tt.exists > 0) {
+				t.Errorf("SINGLE_USER set = %v", singleUser)
+			}
+			if multiUser := len(fake.executed("SET MULTI_USER")) > 0; multiUser != tt.multiUser {
+				t.Errorf("MULTI_USER set = %v", multiUser)
+			}
+			if entries, _ := os.ReadDir(share); len(entries) != 0 {
+				t.Errorf("staged files left on the share: %v", entries)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			restores := fake.executed("RESTORE DATABASE")
+			if len(restores) != 2 || !strings.Contains(restores[0], "NORECOVERY") || !strings.Contains(restores[1], "WITH RECOVERY") {
+				t.Fatalf("restore statements %v", restores)
+			}
+			if !strings.Contains(restores[0], "MOVE @p2 TO @p3, MOVE @p4 TO @p5, REPLACE") {
+				t.Fatalf("first restore doesn't move the files: %s", restores[0])
+			}
+		})
+	}
+}
+
+func TestMSSQLBaseBackup(t *testing.T) {
+	full := &Metadata{Artifact: "full", Provider: mssqlProviderName, Database: "app", Type: "full", Properties: map[string]string{"checkpoint_lsn": "100"}}
+	diff := &Metadata{Artifact: "diff", Provider: mssqlProviderName, Database: "app", Type: "differential", Base: "full", Properties: map[string]string{}}
+	copyOnly := &Metadata{Artifact: "copy", Provider: mssqlProviderName, Database: "app", Type: "full", Properties: map[string]string{"checkpoint_lsn": "200", "copy_only": "true"}}
+	other := &Metadata{Artifact: "other", Provider: mssqlProviderName, Database: "billing", Type: "full", Properties: map[string]string{"checkpoint_lsn": "300"}}
+
+	s := NewMSSQL(config.DatabaseConfig{DBName: "app"})
+	tests := []struct {
+		backupType string
+		previous   []*Metadata
+		want       *Metadata
+	}{
+		{"full", []*Metadata{full}, nil},
+		{"differential", []*Metadata{other, copyOnly, diff, full}, full},
+		{"incremental", []*Metadata{diff, full}, full},
+		{"differential", []*Metadata{copyOnly, other}, nil},
+	}
+	for _, tt := range tests {
+		if got := s.baseBackup(tt.backupType, tt.previous); got != tt.want {
+			t.Errorf("baseBackup(%s) = %+v, want %+v", tt.backupType, got, tt.want)
+		}
+	}
+}
+
+func TestServerJoin(t *testing.T) {
+	tests := []struct{ dir, want string }{
+		{"/var/opt/mssql/backup", "/var/opt/mssql/backup/app.bak"},
+		{"/var/opt/mssql/backup/", "/var/opt/mssql/backup/app.bak"},
+		{`D:\Backup`, `D:\Backup\app.bak`},
+		{`D:\Backup\`, `D:\Backup\app.bak`},
+	}
+	for _, tt := range tests {
+		if got := serverJoin(tt.dir, "app.bak"); got != tt.want {
+			t.Errorf("serverJoin(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+// TestMSSQLServer runs against a SQL Server, e.g. the
+// mcr.microsoft.com/mssql/server image with -v <share>:/var/opt/mssql/backup,
+// when DBBACKUP_TEST_MSSQL_HOST is set. DBBACKUP_TEST_MSSQL_SHARE_PATH is the
+// directory the container sees as DBBACKUP_TEST_MSSQL_BACKUP_DIR.
+func TestMSSQLServer(t *testing.T) {
+	host := os.Getenv("DBBACKUP_TEST_MSSQL_HOST")
+	if host == "" {
+		t.Skip("DBBACKUP_TEST_MSSQL_HOST not set")
+	}
+	env := func(name, fallback string) string {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		return fallback
+	}
+	port, _ := strconv.Atoi(env("DBBACKUP_TEST_MSSQL_PORT", "1433"))
+	cfg := config.DatabaseConfig{
+		Type:        "mssql",
+		Host:        host,
+		Port:        port,
+		User:        env("DBBACKUP_TEST_MSSQL_USER", "sa"),
+		Password:    os.Getenv("DBBACKUP_TEST_MSSQL_PASSWORD"),
+		DBName:      fmt.Sprintf("dbbackup_test_%d", time.Now().UnixNano()),
+		ExtraParams: "encrypt=disable",
+		MSSQL: config.MSSQLConfig{
+			BackupDir: env("DBBACKUP_TEST_MSSQL_BACKUP_DIR", "/var/opt/mssql/backup"),
+			SharePath: os.Getenv("DBBACKUP_TEST_MSSQL_SHARE_PATH"),
+		},
+	}
+	t.Chdir(t.TempDir())
+
+	s := NewMSSQL(cfg)
+	defer s.Close()
+	if err := s.TestConnection(); err != nil {
+		t.Fatal(err)
+	}
+	exec := func(stmt string) {
+		t.Helper()
+		if _, err := s.conn.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	count := func(db string) int {
+		t.Helper()
+		var n int
+		if err := s.conn.QueryRow("SELECT count(*) FROM " + msIdent(db) + ".dbo.t").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	restored := cfg.DBName + "_restored"
+	exec("CREATE DATABASE " + msIdent(cfg.DBName))
+	defer exec("DROP DATABASE IF EXISTS " + msIdent(cfg.DBName))
+	defer exec("DROP DATABASE IF EXISTS " + msIdent(restored))
+	exec("CREATE TABLE " + msIdent(cfg.DBName) + ".dbo.t (id int)")
+	exec("INSERT INTO " + msIdent(cfg.DBName) + ".dbo.t VALUES (1)")
+
+	full, fullMeta, err := s.BackupWithMetadata("full", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullMeta.Artifact = full
+	exec("INSERT INTO " + msIdent(cfg.DBName) + ".dbo.t VALUES (2)")
+	diff, diffMeta, err := s.BackupWithMetadata("differential", []*Metadata{fullMeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffMeta.Type != "differential" || diffMeta.Base != full {
+		t.Fatalf("differential metadata %+v", diffMeta)
+	}
+
+	restoreCfg := cfg
+	restoreCfg.DBName = restored
+	r := NewMSSQL(restoreCfg)
+	defer r.Close()
+	if err := r.RestoreChain([]string{full, diff}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(restored); n != 2 {
+		t.Fatalf("restored %d rows, want 2", n)
+	}
+
+	// A failed restore over the existing database lets sessions back in
+	corrupt := filepath.Join(t.TempDir(), "backup_mssql_corrupt_20260102_150405.bak")
+	os.WriteFile(corrupt, []byte("not a backup"), 0600)
+	if err := r.RestoreChain([]string{corrupt}); err == nil {
+		t.Fatal("restoring a corrupt backup succeeded")
+	}
+	var access string
+	if err := s.conn.QueryRow("SELECT user_access_desc FROM sys.databases WHERE name = @p1", restored).Scan(&access); err != nil {
+		t.Fatal(err)
+	}
+	if access != "MULTI_USER" {
+		t.Fatalf("database left %s after a failed restore", access)
+	}
+	if n := count(restored); n != 2 {
+		t.Fatalf("failed restore changed the database: %d rows", n)
+	}
+
+	// A failed backup leaves nothing on the share
+	missing := NewMSSQL(func() config.DatabaseConfig { c := cfg; c.DBName += "_missing"; return c }())
+	defer missing.Close()
+	if _, _, err := missing.BackupWithMetadata("full", nil); err == nil {
+		t.Fatal("backup of a missing database succeeded")
+	}
+	if left, _ := filepath.Glob(missing.sharePath("backup_mssql_" + missing.Config.DBName + "_*")); len(left) != 0 {
+		t.Fatalf("failed backup left %v on the share", left)
+	}
+}
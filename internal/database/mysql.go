@@ -3,13 +3,18 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/antigravity/dbbackup/internal/config"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/go-sql-driver/mysql"
 )
 
 type MySQL struct {
@@ -22,12 +27,26 @@ func NewMySQL(cfg config.DatabaseConfig) *MySQL {
 }
 
 func (m *MySQL) Connect() error {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", m.Config.User, m.Config.Password, m.Config.Host, m.Config.Port, m.Config.DBName)
-	db, err := sql.Open("mysql", dsn)
+	tlsCfg, err := newTLSConfig(m.Config.TLS, m.Config.Host)
 	if err != nil {
 		return err
 	}
-	m.conn = db
+
+	driverCfg := mysql.NewConfig()
+	driverCfg.User = m.Config.User
+	driverCfg.Passwd = m.Config.Password
+	driverCfg.Net = "tcp"
+	driverCfg.Addr = net.JoinHostPort(m.Config.Host, strconv.Itoa(m.Config.Port))
+	if !discoveryEnabled(m.Config) {
+		driverCfg.DBName = m.Config.DBName
+	}
+	driverCfg.TLS = tlsCfg
+
+	connector, err := mysql.NewConnector(driverCfg)
+	if err != nil {
+		return err
+	}
+	m.conn = sql.OpenDB(connector)
 	return nil
 }
 
@@ -41,20 +60,41 @@ func (m *MySQL) TestConnection() error {
 }
 
 func (m *MySQL) Backup(backupType string) (string, error) {
+	switch m.Config.MySQL.Engine {
+	case mysqlEnginePhysical:
+		// Without the previous metadata this is always a full backup
+		filename, _, err := m.backupPhysical(backupType, nil)
+		return filename, err
+	case mysqlEngineMydumper:
+		return m.backupMydumper()
+	}
+
 	// Note: mysqldump typically performs a full backup. 
 	// Incremental backups in MySQL usually require binary logs, which is complex for a CLI tool.
 	// We will stick to full backups for now unless 'incremental' logic is strictly required via binlogs.
 	
 	filename := fmt.Sprintf("backup_mysql_%s_%s.sql", m.Config.DBName, time.Now().Format("20060102_150405"))
+
+	server, err := m.serverInfo()
+	if err != nil {
+		return "", err
+	}
 	
-	args := []string{
-		fmt.Sprintf("-h%s", m.Config.Host),
-		fmt.Sprintf("-P%d", m.Config.Port),
-		fmt.Sprintf("-u%s", m.Config.User),
-		fmt.Sprintf("-p%s", m.Config.Password),
-		m.Config.DBName,
-		"--result-file=" + filename,
+	optionFile, cleanup, err := mysqlOptionFile(m.Config)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	// --defaults-extra-file must be the first argument
+	args := []string{"--defaults-extra-file=" + optionFile}
+	args = append(args, m.dumpArgs(server)...)
+	selection, err := m.tableArgs()
+	if err != nil {
+		return "", err
 	}
+	args = append(args, selection...)
+	args = append(args, "--result-file="+filename)
 
 	cmdName := "mysqldump"
 	if m.Config.ToolPath != "" {
@@ -62,26 +102,32 @@ func (m *MySQL) Backup(backupType string) (string, error) {
 	}
 
 	cmd := exec.Command(cmdName, args...)
-	// Hide password from process list if possible, but passing as arg is standard for mysqldump in simple scripts.
-	// A better way is using a config file or env var, but for now this is direct.
-	// WARNING: -p with password directly can be insecure in shared environments. 
-	// Ideally we write a temporary .my.cnf file.
-	
+
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("mysqldump failed: %v, output: %s", err, string(output))
+		return "", fmt.Errorf("mysqldump failed: %v, output: %s", err, logger.Redact(string(output)))
 	}
 
 	return filename, nil
 }
 
 func (m *MySQL) Restore(backupFile string) error {
-	// mysql -h... -u... -p... dbname < backupFile
+	if isXtrabackupArtifact(backupFile) {
+		return m.RestoreChain([]string{backupFile})
+	}
+	if isMydumperArtifact(backupFile) {
+		return m.restoreMydumper(backupFile)
+	}
+
+	// mysql --defaults-extra-file=... dbname < backupFile
 	
+	optionFile, cleanup, err := mysqlOptionFile(m.Config)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	args := []string{
-		fmt.Sprintf("-h%s", m.Config.Host),
-		fmt.Sprintf("-P%d", m.Config.Port),
-		fmt.Sprintf("-u%s", m.Config.User),
-		fmt.Sprintf("-p%s", m.Config.Password),
+		"--defaults-extra-file=" + optionFile,
 		m.Config.DBName,
 	}
 
@@ -102,12 +148,190 @@ func (m *MySQL) Restore(backupFile string) error {
 	cmd.Stdin = file
 	
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("mysql restore failed: %v, output: %s", err, string(output))
+		return fmt.Errorf("mysql restore failed: %v, output: %s", err, logger.Redact(string(output)))
 	}
 
 	return nil
 }
 
+// mysqlServer is what the dump options depend on
+type mysqlServer struct {
+	version string
+	mariaDB bool
+	binlog  bool
+}
+
+// mysqlVersion matches the numeric part of VERSION()
+var mysqlVersion = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+// atLeast reports whether the server version is major.minor.patch or later
+func (s mysqlServer) atLeast(major, minor, patch int) bool {
+	match := mysqlVersion.FindStringSubmatch(s.version)
+	if match == nil {
+		return false
+	}
+	want := []int{major, minor, patch}
+	for i, part := range match[1:] {
+		n, _ := strconv.Atoi(part)
+		if n != want[i] {
+			return n > want[i]
+		}
+	}
+	return true
+}
+
+// serverInfo asks the server for its version and whether binary logging is on
+func (m *MySQL) serverInfo() (mysqlServer, error) {
+	if err := m.TestConnection(); err != nil {
+		return mysqlServer{}, err
+	}
+	var server mysqlServer
+	if err := m.conn.QueryRow("SELECT VERSION(), @@log_bin").Scan(&server.version, &server.binlog); err != nil {
+		return mysqlServer{}, fmt.Errorf("failed to detect server version: %v", err)
+	}
+	server.mariaDB = strings.Contains(strings.ToLower(server.version), "mariadb")
+	return server, nil
+}
+
+// dumpArgs returns the mysqldump options from mysql.dump, adjusted for the
+// server: MariaDB has no GTID_PURGED handling, and servers before MySQL
+// 8.0.26 only know --master-data.
+func (m *MySQL) dumpArgs(server mysqlServer) []string {
+	opts := m.Config.MySQL.Dump
+
+	var args []string
+	flags := []struct {
+		enabled *bool
+		flag    string
+	}{
+		{opts.SingleTransaction, "--single-transaction"},
+		{opts.Quick, "--quick"},
+		{opts.Routines, "--routines"},
+		{opts.Triggers, "--triggers"},
+		{opts.Events, "--events"},
+		{opts.HexBlob, "--hex-blob"},
+	}
+	for _, f := range flags {
+		if f.enabled == nil || *f.enabled {
+			args = append(args, f.flag)
+		} else {
+			// --triggers is on by default in mysqldump itself
+			args = append(args, "--skip"+strings.TrimPrefix(f.flag, "-"))
+		}
+	}
+
+	if !server.mariaDB {
+		gtidPurged := opts.SetGTIDPurged
+		if gtidPurged == "" {
+			// A single database restored into a server with its own GTID
+			// history can't set GTID_PURGED
+			gtidPurged = "OFF"
+		}
+		args = append(args, "--set-gtid-purged="+gtidPurged)
+	}
+
+	sourceData := 2
+	if opts.SourceData != nil {
+		sourceData = *opts.SourceData
+	}
+	if sourceData > 0 {
+		if !server.binlog {
+			logger.Warn("Binary logging is off, the dump won't record a binlog position", "version", server.version)
+		} else {
+			flag := "--source-data"
+			if server.mariaDB || !server.atLeast(8, 0, 26) {
+				flag = "--master-data"
+			}
+			args = append(args, fmt.Sprintf("%s=%d", flag, sourceData))
+		}
+	}
+	return args
+}
+
+// tableArgs returns the --ignore-table options, the database and the tables
+// to dump, which mysqldump takes as bare names after the database
+func (m *MySQL) tableArgs() ([]string, error) {
+	var args []string
+	for _, table := range m.Config.MySQL.ExcludeTables {
+		qualified, err := qualifyTable(m.Config.DBName, table)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--ignore-table="+qualified)
+	}
+	args = append(args, m.Config.DBName)
+	for _, table := range m.Config.MySQL.Tables {
+		name, err := tableName(m.Config.DBName, table)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, name)
+	}
+	return args, nil
+}
+
+// tableName returns a tables or exclude_tables entry without its database
+// prefix. Entries are "table" or "db.table", and db must be the dumped database.
+func tableName(dbName, table string) (string, error) {
+	db, name, ok := strings.Cut(table, ".")
+	if !ok {
+		return table, nil
+	}
+	if db != dbName {
+		return "", fmt.Errorf("table %q is not in database %q", table, dbName)
+	}
+	return name, nil
+}
+
+// qualifyTable prefixes a table name with its database unless it has one
+func qualifyTable(dbName, table string) (string, error) {
+	name, err := tableName(dbName, table)
+	if err != nil {
+		return "", err
+	}
+	return dbName + "." + name, nil
+}
+
+var mysqlSystemDatabases = map[string]bool{
+	"information_schema": true,
+	"performance_schema": true,
+	"mysql":              true,
+	"sys":                true,
+}
+
+func (m *MySQL) ListDatabases() ([]string, error) {
+	// A physical backup always covers the whole server
+	if !discoveryEnabled(m.Config) || m.Config.MySQL.Engine == mysqlEnginePhysical {
+		return nil, nil
+	}
+	if err := m.TestConnection(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.conn.Query("SHOW DATABASES")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return selectDatabases(m.Config, names, mysqlSystemDatabases)
+}
+
+func (m *MySQL) ForDatabase(name string) Database {
+	return NewMySQL(forDatabase(m.Config, name))
+}
+
 func (m *MySQL) Close() error {
 	if m.conn != nil {
 		return m.conn.Close()
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+func TestXtrabackupBase(t *testing.T) {
+	incremental := &Metadata{Artifact: "inc2", Provider: xtrabackupProviderName, Type: "incremental", Properties: map[string]string{"to_lsn": "300"}}
+	noLSN := &Metadata{Artifact: "broken", Provider: xtrabackupProviderName, Type: "full"}
+	otherProvider := &Metadata{Artifact: "pg", Provider: "pg-physical", Type: "full", Properties: map[string]string{"to_lsn": "1"}}
+	full := &Metadata{Artifact: "full", Provider: xtrabackupProviderName, Type: "full", Properties: map[string]string{"to_lsn": "100"}}
+
+	tests := []struct {
+		name       string
+		backupType string
+		previous   []*Metadata
+		want       *Metadata
+	}{
+		{"full never has a base", "full", []*Metadata{incremental, full}, nil},
+		{"incremental on the latest backup", "incremental", []*Metadata{incremental, full}, incremental},
+		{"differential on the latest full", "differential", []*Metadata{incremental, full}, full},
+		{"skips metadata without an LSN", "incremental", []*Metadata{noLSN, full}, full},
+		{"skips other providers", "incremental", []*Metadata{otherProvider, full}, full},
+		{"no previous backup", "incremental", nil, nil},
+		{"differential without a full", "differential", []*Metadata{incremental}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := xtrabackupBase(tt.backupType, tt.previous); got != tt.want {
+				t.Fatalf("xtrabackupBase = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadXtrabackupCheckpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "full",
+			content: "backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 18733340\nlast_lsn = 18733356\nflushed_lsn = 18733340\n",
+			want:    map[string]string{"backup_type": "full-backuped", "from_lsn": "0", "to_lsn": "18733340", "last_lsn": "18733356", "flushed_lsn": "18733340"},
+		},
+		{
+			name:    "incremental without spaces or trailing newline",
+			content: "backup_type=incremental\nfrom_lsn=18733340\nto_lsn=18800000",
+			want:    map[string]string{"backup_type": "incremental", "from_lsn": "18733340", "to_lsn": "18800000"},
+		},
+		{
+			name:    "ignores lines without a value",
+			content: "# comment\n\nto_lsn = 5\n",
+			want:    map[string]string{"to_lsn": "5"},
+		},
+		{name: "no to_lsn", content: "backup_type = full-backuped\nfrom_lsn = 0\n", wantErr: "has no to_lsn"},
+		{name: "empty to_lsn", content: "to_lsn =\n", wantErr: "has no to_lsn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), xtrabackupCheckpoints)
+			os.WriteFile(path, []byte(tt.content), 0600)
+
+			got, err := readXtrabackupCheckpoints(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Fatalf("%s = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+
+	if _, err := readXtrabackupCheckpoints(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("no error for a missing file")
+	}
+}
+
+func TestIsXtrabackupArtifact(t *testing.T) {
+	if !isXtrabackupArtifact("nightly/backup_mysql-physical_20260102_150405.xbstream.gz") {
+		t.Error("physical artifact not recognised")
+	}
+	if isXtrabackupArtifact("backup_mysql_app_20260102_150405.sql") {
+		t.Error("logical artifact taken for a physical one")
+	}
+}
+
+// fakeXtrabackup installs a shell script as xtrabackup next to tool_path. It
+// streams its arguments to stdout, writes the checkpoints to --extra-lsndir
+// and exits with exitCode.
+func fakeXtrabackup(t *testing.T, exitCode int) config.DatabaseConfig {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+for arg in "$@"; do
+	case "$arg" in
+	--extra-lsndir=*) lsn="${arg#--extra-lsndir=}" ;;
+	esac
+	echo "$arg"
+done
+mkdir -p "$lsn"
+printf 'backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 400\n' > "$lsn/xtrabackup_checkpoints"
+exit ` + strconv.Itoa(exitCode) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "xtrabackup"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return config.DatabaseConfig{
+		Type:     "mysql",
+		Host:     "localhost",
+		User:     "root",
+		ToolPath: filepath.Join(dir, "mysqldump"),
+		MySQL:    config.MySQLConfig{Engine: mysqlEnginePhysical},
+	}
+}
+
+func TestMySQLBackupPhysical(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as xtrabackup")
+	}
+	full := &Metadata{Artifact: "full", Provider: xtrabackupProviderName, Type: "full", Properties: map[string]string{"to_lsn": "100"}}
+	inc := &Metadata{Artifact: "inc", Provider: xtrabackupProviderName, Type: "incremental", Properties: map[string]string{"to_lsn": "200"}}
+
+	tests := []struct {
+		backupType string
+		wantType   string
+		wantBase   string
+		wantLSN    string
+	}{
+		{"full", "full", "", ""},
+		{"incremental", "incremental", "inc", "--incremental-lsn=200"},
+		{"differential", "differential", "full", "--incremental-lsn=100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backupType, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			m := NewMySQL(fakeXtrabackup(t, 0))
+
+			file, meta, err := m.BackupWithMetadata(tt.backupType, []*Metadata{inc, full})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(file, ".xbstream.gz") {
+				t.Fatalf("artifact %s isn't compressed", file)
+			}
+			if meta.Type != tt.wantType || meta.Base != tt.wantBase || meta.Properties["to_lsn"] != "400" {
+				t.Fatalf("metadata %+v", meta)
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(stream), "--stream=xbstream\n") {
+				t.Fatalf("stream %q", stream)
+			}
+			if got := strings.Contains(string(stream), "--incremental-lsn="); got != (tt.wantLSN != "") || !strings.Contains(string(stream), tt.wantLSN) {
+				t.Fatalf("stream %q, want %q", stream, tt.wantLSN)
+			}
+		})
+	}
+}
+
+func TestMySQLBackupPhysicalFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as xtrabackup")
+	}
+	t.Chdir(t.TempDir())
+	m := NewMySQL(fakeXtrabackup(t, 1))
+
+	if _, _, err := m.BackupWithMetadata("full", nil); err == nil || !strings.Contains(err.Error(), "xtrabackup failed") {
+		t.Fatalf("BackupWithMetadata = %v", err)
+	}
+	if entries, _ := os.ReadDir("."); len(entries) != 0 {
+		t.Fatalf("left files behind: %v", entries)
+	}
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+func TestMySQLServerAtLeast(t *testing.T) {
+	tests := []struct {
+		version             string
+		major, minor, patch int
+		want                bool
+	}{
+		{"8.0.26", 8, 0, 26, true},
+		{"8.0.25", 8, 0, 26, false},
+		{"8.0.27", 8, 0, 26, true},
+		{"8.1.0", 8, 0, 26, true},
+		{"9.0.1", 8, 0, 26, true},
+		{"5.7.44-log", 8, 0, 26, false},
+		{"8.0.36-0ubuntu0.22.04.1", 8, 0, 26, true},
+		{"8.4.3-commercial", 8, 0, 26, true},
+		{"10.11.6-MariaDB-0+deb12u1", 10, 11, 6, true},
+		{"10.11.6-MariaDB", 10, 12, 0, false},
+		// Compared numerically, not as strings
+		{"8.0.100", 8, 0, 26, true},
+		{"10.0.0", 9, 9, 9, true},
+		{"", 8, 0, 26, false},
+		{"unknown", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		got := mysqlServer{version: tt.version}.atLeast(tt.major, tt.minor, tt.patch)
+		if got != tt.want {
+			t.Errorf("mysqlServer{%q}.atLeast(%d, %d, %d) = %v", tt.version, tt.major, tt.minor, tt.patch, got)
+		}
+	}
+}
+
+func TestMySQLDumpArgs(t *testing.T) {
+	off, zero, one := false, 0, 1
+
+	tests := []struct {
+		name   string
+		dump   config.MySQLDumpConfig
+		server mysqlServer
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "defaults on MySQL 8.0",
+			server: mysqlServer{version: "8.0.36", binlog: true},
+			want:   []string{"--single-transaction", "--quick", "--routines", "--triggers", "--events", "--hex-blob", "--set-gtid-purged=OFF", "--source-data=2"},
+		},
+		{
+			name:   "MySQL before 8.0.26 uses --master-data",
+			server: mysqlServer{version: "5.7.44-log", binlog: true},
+			want:   []string{"--master-data=2"},
+			absent: []string{"--source-data=2"},
+		},
+		{
+			name:   "MariaDB has no GTID_PURGED and uses --master-data",
+			server: mysqlServer{version: "11.4.2-MariaDB", mariaDB: true, binlog: true},
+			want:   []string{"--master-data=2"},
+			absent: []string{"--set-gtid-purged=OFF"},
+		},
+		{
+			name:   "no binlog position without binary logging",
+			server: mysqlServer{version: "8.0.36"},
+			absent: []string{"--source-data=2", "--master-data=2"},
+		},
+		{
+			name:   "options turned off",
+			dump:   config.MySQLDumpConfig{Triggers: &off, Events: &off, SourceData: &zero, SetGTIDPurged: "AUTO"},
+			server: mysqlServer{version: "8.0.36", binlog: true},
+			want:   []string{"--skip-triggers", "--skip-events", "--set-gtid-purged=AUTO"},
+			absent: []string{"--triggers", "--events", "--source-data=2"},
+		},
+		{
+			name:   "binlog position as a statement",
+			dump:   config.MySQLDumpConfig{SourceData: &one},
+			server: mysqlServer{version: "8.0.36", binlog: true},
+			want:   []string{"--source-data=1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMySQL(config.DatabaseConfig{DBName: "app", MySQL: config.MySQLConfig{Dump: tt.dump}})
+			args := m.dumpArgs(tt.server)
+			for _, want := range tt.want {
+				if !contains(args, want) {
+					t.Errorf("%s missing from %s", want, strings.Join(args, " "))
+				}
+			}
+			for _, absent := range tt.absent {
+				if contains(args, absent) {
+					t.Errorf("%s in %s", absent, strings.Join(args, " "))
+				}
+			}
+		})
+	}
+}
+
+func TestQualifyTable(t *testing.T) {
+	tests := []struct {
+		table     string
+		name      string
+		qualified string
+		wantErr   bool
+	}{
+		{"orders", "orders", "app.orders", false},
+		{"app.orders", "orders", "app.orders", false},
+		{"billing.orders", "", "", true},
+		{"app_test.orders", "", "", true},
+	}
+	for _, tt := range tests {
+		name, err := tableName("app", tt.table)
+		if (err != nil) != tt.wantErr || name != tt.name {
+			t.Errorf("tableName(%q) = %q, %v", tt.table, name, err)
+		}
+		qualified, err := qualifyTable("app", tt.table)
+		if (err != nil) != tt.wantErr || qualified != tt.qualified {
+			t.Errorf("qualifyTable(%q) = %q, %v", tt.table, qualified, err)
+		}
+	}
+}
+
+func TestMySQLTableArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		tables  []string
+		exclude []string
+		want    string
+		wantErr bool
+	}{
+		{"whole database", nil, nil, "app", false},
+		{"bare and qualified tables", []string{"orders", "app.customers"}, nil, "app orders customers", false},
+		{"excluded tables", nil, []string{"sessions", "app.audit_log"}, "--ignore-table=app.sessions --ignore-table=app.audit_log app", false},
+		{"table of another database", []string{"billing.orders"}, nil, "", true},
+		{"excluded table of another database", nil, []string{"billing.orders"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMySQL(config.DatabaseConfig{DBName: "app", MySQL: config.MySQLConfig{Tables: tt.tables, ExcludeTables: tt.exclude}})
+			args, err := m.tableArgs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tableArgs error = %v", err)
+			}
+			if got := strings.Join(args, " "); got != tt.want {
+				t.Fatalf("tableArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,12 +3,13 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
 	_ "github.com/lib/pq"
 )
 
@@ -22,16 +23,13 @@ func NewPostgres(cfg config.DatabaseConfig) *Postgres {
 }
 
 func (p *Postgres) Connect() error {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s", 
-		p.Config.Host, p.Config.Port, p.Config.User, p.Config.Password, p.Config.DBName)
-	
-	if p.Config.ExtraParams != "" {
-		dsn = fmt.Sprintf("%s %s", dsn, p.Config.ExtraParams)
-	} else {
-		dsn = fmt.Sprintf("%s sslmode=disable", dsn)
+	dbName := p.Config.DBName
+	if discoveryEnabled(p.Config) {
+		// Discovery connects to the maintenance database
+		dbName = "postgres"
 	}
 
-	db, err := sql.Open("postgres", dsn)
+	db, err := sql.Open("postgres", pgDSN(p.Config, dbName))
 	if err != nil {
 		return err
 	}
@@ -39,6 +37,22 @@ func (p *Postgres) Connect() error {
 	return nil
 }
 
+// pgDSN builds the lib/pq connection string, also used for CockroachDB
+func pgDSN(cfg config.DatabaseConfig, dbName string) string {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s", 
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, dbName)
+	
+	for k, v := range pgTLSParams(cfg.TLS) {
+		dsn = fmt.Sprintf("%s %s='%s'", dsn, k, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v))
+	}
+	if cfg.ExtraParams != "" {
+		dsn = fmt.Sprintf("%s %s", dsn, cfg.ExtraParams)
+	} else if cfg.TLS.Mode == "" {
+		dsn = fmt.Sprintf("%s sslmode=disable", dsn)
+	}
+	return dsn
+}
+
 func (p *Postgres) TestConnection() error {
 	if p.conn == nil {
 		if err := p.Connect(); err != nil {
@@ -49,12 +63,12 @@ func (p *Postgres) TestConnection() error {
 }
 
 func (p *Postgres) Backup(backupType string) (string, error) {
+	if p.Config.Postgres.Mode == pgModePhysical {
+		return p.backupPhysical()
+	}
+
 	filename := fmt.Sprintf("backup_pg_%s_%s.sql", p.Config.DBName, time.Now().Format("20060102_150405"))
 	
-	// PGPASSWORD env var is safer than command line arg
-	os.Setenv("PGPASSWORD", p.Config.Password)
-	defer os.Unsetenv("PGPASSWORD")
-
 	args := []string{
 		"-h", p.Config.Host,
 		"-p", fmt.Sprintf("%d", p.Config.Port),
@@ -69,17 +83,20 @@ func (p *Postgres) Backup(backupType string) (string, error) {
 	}
 
 	cmd := exec.Command(cmdName, args...)
+	// PGPASSWORD is set on the command only, never in our own environment
+	cmd.Env = pgEnv(p.Config)
 	
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("pg_dump failed: %v, output: %s", err, string(output))
+		return "", fmt.Errorf("pg_dump failed: %v, output: %s", err, logger.Redact(string(output)))
 	}
 
 	return filename, nil
 }
 
 func (p *Postgres) Restore(backupFile string) error {
-	os.Setenv("PGPASSWORD", p.Config.Password)
-	defer os.Unsetenv("PGPASSWORD")
+	if isPhysicalArtifact(backupFile) {
+		return p.restorePhysical(backupFile)
+	}
 
 	args := []string{
 		"-h", p.Config.Host,
@@ -111,14 +128,74 @@ func (p *Postgres) Restore(backupFile string) error {
 	}
 
 	cmd := exec.Command(cmdName, args...)
+	// PGPASSWORD is set on the command only, never in our own environment
+	cmd.Env = pgEnv(p.Config)
 	
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("psql restore failed: %v, output: %s", err, string(output))
+		return fmt.Errorf("psql restore failed: %v, output: %s", err, logger.Redact(string(output)))
 	}
 
 	return nil
 }
 
+// pgSystemDatabases are skipped by discovery; template databases are never listed
+var pgSystemDatabases = map[string]bool{"postgres": true}
+
+func (p *Postgres) ListDatabases() ([]string, error) {
+	// A physical backup always covers the whole cluster
+	if !discoveryEnabled(p.Config) || p.Config.Postgres.Mode == pgModePhysical {
+		return nil, nil
+	}
+	if err := p.TestConnection(); err != nil {
+		return nil, err
+	}
+
+	rows, err := p.conn.Query("SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return selectDatabases(p.Config, names, pgSystemDatabases)
+}
+
+func (p *Postgres) ForDatabase(name string) Database {
+	return NewPostgres(forDatabase(p.Config, name))
+}
+
+// BackupGlobals dumps the roles and tablespaces, which pg_dump leaves out
+func (p *Postgres) BackupGlobals() (string, error) {
+	filename := fmt.Sprintf("backup_pg-globals_%s.sql", time.Now().Format("20060102_150405"))
+
+	args := []string{
+		"-h", p.Config.Host,
+		"-p", fmt.Sprintf("%d", p.Config.Port),
+		"-U", p.Config.User,
+		"--globals-only",
+		"-f", filename,
+	}
+
+	cmd := exec.Command(p.pgTool("pg_dumpall"), args...)
+	cmd.Env = pgEnv(p.Config)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pg_dumpall failed: %v, output: %s", err, logger.Redact(string(output)))
+	}
+
+	return filename, nil
+}
+
 func (p *Postgres) Close() error {
 	if p.conn != nil {
 		return p.conn.Close()
@@ -0,0 +1,319 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/logger"
+)
+
+const (
+	pgModePhysical         = "physical"
+	pgPhysicalPrefix       = "backup_pg-physical_"
+	physicalManifestName   = "manifest.json"
+	physicalManifestFormat = "pg_basebackup-tar"
+)
+
+// tablespaceArchive matches the per-tablespace tars pg_basebackup writes, <oid>.tar
+var tablespaceArchive = regexp.MustCompile(`^(\d+)\.tar$`)
+
+// physicalManifest describes the files of a physical backup artifact. It sits
+// next to them in the artifact, together with the backup_manifest that
+// pg_basebackup writes itself.
+type physicalManifest struct {
+	Format        string         `json:"format"`
+	Label         string         `json:"label"`
+	Created       time.Time      `json:"created"`
+	ServerVersion string         `json:"server_version,omitempty"`
+	Files         []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func isPhysicalArtifact(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), pgPhysicalPrefix)
+}
+
+// pgTool returns the path of a Postgres client binary, next to tool_path if set
+func (p *Postgres) pgTool(name string) string {
+	if p.Config.ToolPath != "" {
+		return filepath.Join(filepath.Dir(p.Config.ToolPath), name)
+	}
+	return name
+}
+
+// backupPhysical takes a base backup of the whole cluster with pg_basebackup:
+// tar format with the WAL needed for consistency streamed alongside. The tars,
+// pg_basebackup's backup_manifest and our manifest.json are packed into a
+// single tar artifact.
+func (p *Postgres) backupPhysical() (string, error) {
+	stamp := time.Now().Format("20060102_150405")
+	filename := pgPhysicalPrefix + stamp + ".tar"
+	label := "dbbackup-" + stamp
+
+	workDir, err := os.MkdirTemp("", "dbbackup-basebackup-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+	// pg_basebackup wants a directory that doesn't exist yet
+	targetDir := filepath.Join(workDir, "backup")
+
+	args := []string{
+		"-h", p.Config.Host,
+		"-p", fmt.Sprintf("%d", p.Config.Port),
+		"-U", p.Config.User,
+		"-D", targetDir,
+		"--format=tar",
+		"--wal-method=stream",
+		"--manifest-checksums=SHA256",
+		"--label=" + label,
+		"--no-password",
+	}
+	if p.Config.Postgres.Checkpoint != "" {
+		args = append(args, "--checkpoint="+p.Config.Postgres.Checkpoint)
+	}
+
+	cmd := exec.Command(p.pgTool("pg_basebackup"), args...)
+	cmd.Env = pgEnv(p.Config)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pg_basebackup failed: %v, output: %s", err, logger.Redact(string(output)))
+	}
+
+	manifest := physicalManifest{
+		Format:        physicalManifestFormat,
+		Label:         label,
+		Created:       time.Now().UTC(),
+		ServerVersion: p.serverVersion(),
+	}
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(targetDir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return "", err
+		}
+		manifest.Files = append(manifest.Files, manifestFile{Name: entry.Name(), Size: info.Size(), SHA256: sum})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(targetDir, physicalManifestName), data, 0600); err != nil {
+		return "", err
+	}
+
+	if err := tarDirectory(targetDir, filename); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("failed to pack base backup: %v", err)
+	}
+	return filename, nil
+}
+
+// serverVersion returns the server version for the manifest, if the
+// connection is open
+func (p *Postgres) serverVersion() string {
+	if p.conn == nil {
+		return ""
+	}
+	var version string
+	if err := p.conn.QueryRow("SHOW server_version").Scan(&version); err != nil {
+		return ""
+	}
+	return version
+}
+
+// restorePhysical unpacks a physical backup into the data directory of a
+// stopped instance. With a restore_command it also sets up archive recovery
+// through recovery.signal; otherwise the WAL in the backup is enough to reach
+// a consistent state. The instance is not started.
+func (p *Postgres) restorePhysical(backupFile string) error {
+	dataDir := p.Config.Postgres.DataDir
+	if dataDir == "" {
+		return fmt.Errorf("postgres.data_dir is required to restore a physical backup")
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "postmaster.pid")); err == nil {
+		return fmt.Errorf("postmaster.pid found in %s, stop the target instance first", dataDir)
+	}
+	if err := requireEmptyDir(dataDir); err != nil {
+		return err
+	}
+
+	workDir, err := os.MkdirTemp("", "dbbackup-basebackup-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := extractTar(backupFile, workDir); err != nil {
+		return err
+	}
+	manifest, err := readPhysicalManifest(workDir)
+	if err != nil {
+		return err
+	}
+
+	if err := extractTar(filepath.Join(workDir, "base.tar"), dataDir); err != nil {
+		return err
+	}
+	if err := extractTar(filepath.Join(workDir, "pg_wal.tar"), filepath.Join(dataDir, "pg_wal")); err != nil {
+		return err
+	}
+	if err := restoreTablespaces(workDir, dataDir, manifest); err != nil {
+		return err
+	}
+	// Postgres refuses to start on a data directory with looser permissions
+	if err := os.Chmod(dataDir, 0700); err != nil {
+		return err
+	}
+
+	if p.Config.Postgres.RestoreCommand != "" {
+		if err := p.writeRecoveryConfig(dataDir); err != nil {
+			return err
+		}
+		logger.Info("Physical backup restored with archive recovery, start the instance to replay WAL", "data_dir", dataDir)
+	} else {
+		logger.Info("Physical backup restored, start the instance to recover from the included WAL", "data_dir", dataDir)
+	}
+	return nil
+}
+
+func requireEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("data directory %s is not empty", dir)
+	}
+	return nil
+}
+
+// readPhysicalManifest reads manifest.json and verifies every file it lists
+func readPhysicalManifest(dir string) (*physicalManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, physicalManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("not a physical backup, %s is missing: %v", physicalManifestName, err)
+	}
+
+	var manifest physicalManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", physicalManifestName, err)
+	}
+	if manifest.Format != physicalManifestFormat {
+		return nil, fmt.Errorf("unsupported physical backup format: %s", manifest.Format)
+	}
+
+	for _, f := range manifest.Files {
+		sum, err := fileSHA256(filepath.Join(dir, f.Name))
+		if err != nil {
+			return nil, err
+		}
+		if sum != f.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s, the backup is corrupt", f.Name)
+		}
+	}
+	return &manifest, nil
+}
+
+// restoreTablespaces extracts the <oid>.tar of each tablespace to the location
+// recorded in tablespace_map. Postgres recreates the pg_tblspc links from the
+// map at startup.
+func restoreTablespaces(workDir, dataDir string, manifest *physicalManifest) error {
+	locations, err := readTablespaceMap(filepath.Join(dataDir, "tablespace_map"))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range manifest.Files {
+		match := tablespaceArchive.FindStringSubmatch(f.Name)
+		if match == nil {
+			continue
+		}
+		location, ok := locations[match[1]]
+		if !ok {
+			return fmt.Errorf("tablespace %s is not in tablespace_map", match[1])
+		}
+		if err := requireEmptyDir(location); err != nil {
+			return err
+		}
+		if err := extractTar(filepath.Join(workDir, f.Name), location); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTablespaceMap(path string) (map[string]string, error) {
+	locations := map[string]string{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return locations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		oid, location, ok := strings.Cut(scanner.Text(), " ")
+		if ok {
+			locations[oid] = location
+		}
+	}
+	return locations, scanner.Err()
+}
+
+// writeRecoveryConfig creates recovery.signal and appends restore_command and
+// the recovery target to postgresql.auto.conf
+func (p *Postgres) writeRecoveryConfig(dataDir string) error {
+	if err := os.WriteFile(filepath.Join(dataDir, "recovery.signal"), nil, 0600); err != nil {
+		return err
+	}
+
+	quote := func(v string) string { return "'" + strings.ReplaceAll(v, "'", "''") + "'" }
+
+	settings := "\n# Added by dbbackup restore\n"
+	settings += "restore_command = " + quote(p.Config.Postgres.RestoreCommand) + "\n"
+	if p.Config.Postgres.RecoveryTargetTime != "" {
+		settings += "recovery_target_time = " + quote(p.Config.Postgres.RecoveryTargetTime) + "\n"
+		settings += "recovery_target_action = 'promote'\n"
+	}
+
+	file, err := os.OpenFile(filepath.Join(dataDir, "postgresql.auto.conf"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(settings); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
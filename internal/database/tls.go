@@ -0,0 +1,188 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// TLS modes, named after libpq's sslmode
+const (
+	tlsDisable    = "disable"
+	tlsRequire    = "require"     // encrypt, don't verify the server
+	tlsVerifyCA   = "verify-ca"   // verify the certificate chain
+	tlsVerifyFull = "verify-full" // verify the chain and the host name
+)
+
+func tlsEnabled(cfg config.TLSConfig) bool {
+	return cfg.Mode != "" && cfg.Mode != tlsDisable
+}
+
+// newTLSConfig builds the client TLS config for the Go drivers. It returns nil
+// when TLS is not enabled.
+func newTLSConfig(cfg config.TLSConfig, host string) (*tls.Config, error) {
+	switch cfg.Mode {
+	case "", tlsDisable:
+		return nil, nil
+	case tlsRequire, tlsVerifyCA, tlsVerifyFull:
+	default:
+		return nil, fmt.Errorf("unsupported tls mode: %s", cfg.Mode)
+	}
+
+	tlsCfg := &tls.Config{ServerName: cfg.ServerName}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = host
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls ca file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls client certificate: %v", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	switch cfg.Mode {
+	case tlsRequire:
+		tlsCfg.InsecureSkipVerify = true
+	case tlsVerifyCA:
+		// Skip the built-in verification, which includes the host name, and
+		// check only the chain
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, tlsCfg.RootCAs)
+		}
+	}
+	return tlsCfg, nil
+}
+
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("server sent no certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+// pgTLSParams returns the libpq TLS settings as key/value pairs, used both in
+// the lib/pq DSN and as PG* environment variables for pg_dump and psql.
+// libpq has no server name override, the host is always verified.
+func pgTLSParams(cfg config.TLSConfig) map[string]string {
+	if cfg.Mode == "" {
+		return nil
+	}
+	params := map[string]string{"sslmode": cfg.Mode}
+	if cfg.CAFile != "" {
+		params["sslrootcert"] = cfg.CAFile
+	}
+	if cfg.CertFile != "" {
+		params["sslcert"] = cfg.CertFile
+	}
+	if cfg.KeyFile != "" {
+		params["sslkey"] = cfg.KeyFile
+	}
+	return params
+}
+
+// mysqlTLSOptions returns the TLS lines of the [client] option file. ssl-mode
+// is MySQL only, so it is prefixed with loose- for MariaDB clients, which use
+// ssl-verify-server-cert instead.
+func mysqlTLSOptions(cfg config.TLSConfig) []string {
+	switch cfg.Mode {
+	case "":
+		return nil
+	case tlsDisable:
+		return []string{"loose-ssl-mode=DISABLED", "loose-skip-ssl"}
+	}
+
+	opts := map[string]string{
+		tlsRequire:    "loose-ssl-mode=REQUIRED",
+		tlsVerifyCA:   "loose-ssl-mode=VERIFY_CA",
+		tlsVerifyFull: "loose-ssl-mode=VERIFY_IDENTITY",
+	}
+	lines := []string{opts[cfg.Mode], "loose-ssl"}
+	if cfg.Mode == tlsVerifyFull {
+		lines = append(lines, "loose-ssl-verify-server-cert")
+	}
+	if cfg.CAFile != "" {
+		lines = append(lines, "ssl-ca="+mysqlOptionValue(cfg.CAFile))
+	}
+	if cfg.CertFile != "" {
+		lines = append(lines, "ssl-cert="+mysqlOptionValue(cfg.CertFile))
+	}
+	if cfg.KeyFile != "" {
+		lines = append(lines, "ssl-key="+mysqlOptionValue(cfg.KeyFile))
+	}
+	return lines
+}
+
+// mongoTLSArgs returns the TLS flags for mongodump and mongorestore. The tools
+// want the client certificate and key in a single PEM file, so separate files
+// are combined into a temporary one; the returned function removes it.
+func mongoTLSArgs(cfg config.TLSConfig) ([]string, func(), error) {
+	noop := func() {}
+	if !tlsEnabled(cfg) {
+		return nil, noop, nil
+	}
+
+	args := []string{"--ssl"}
+	if cfg.CAFile != "" {
+		args = append(args, "--sslCAFile="+cfg.CAFile)
+	}
+	switch cfg.Mode {
+	case tlsRequire:
+		args = append(args, "--sslAllowInvalidCertificates", "--sslAllowInvalidHostnames")
+	case tlsVerifyCA:
+		args = append(args, "--sslAllowInvalidHostnames")
+	}
+
+	cleanup := noop
+	if cfg.CertFile != "" {
+		pemFile := cfg.CertFile
+		if cfg.KeyFile != "" && cfg.KeyFile != cfg.CertFile {
+			cert, err := os.ReadFile(cfg.CertFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read tls cert file: %v", err)
+			}
+			key, err := os.ReadFile(cfg.KeyFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read tls key file: %v", err)
+			}
+			pemFile, cleanup, err = writeOptionFile("dbbackup-mongo-*.pem", string(cert)+"\n"+string(key))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		args = append(args, "--sslPEMKeyFile="+pemFile)
+	}
+	return args, cleanup, nil
+}
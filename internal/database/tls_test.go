@@ -0,0 +1,226 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// writeCert writes a self-signed certificate and its key as PEM files
+func writeCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		name       string
+		cfg        config.TLSConfig
+		nilConfig  bool
+		insecure   bool
+		verifyPeer bool
+		serverName string
+		wantErr    string
+	}{
+		{name: "unset", cfg: config.TLSConfig{}, nilConfig: true},
+		{name: "disable", cfg: config.TLSConfig{Mode: tlsDisable}, nilConfig: true},
+		{name: "require", cfg: config.TLSConfig{Mode: tlsRequire}, insecure: true, serverName: "db.internal"},
+		{name: "verify-ca", cfg: config.TLSConfig{Mode: tlsVerifyCA, CAFile: certFile}, insecure: true, verifyPeer: true, serverName: "db.internal"},
+		{name: "verify-full", cfg: config.TLSConfig{Mode: tlsVerifyFull, CAFile: certFile}, serverName: "db.internal"},
+		{name: "server name override", cfg: config.TLSConfig{Mode: tlsVerifyFull, ServerName: "db.example.com"}, serverName: "db.example.com"},
+		{name: "client certificate", cfg: config.TLSConfig{Mode: tlsVerifyFull, CertFile: certFile, KeyFile: keyFile}, serverName: "db.internal"},
+		{name: "unknown mode", cfg: config.TLSConfig{Mode: "prefer"}, wantErr: "unsupported tls mode: prefer"},
+		{name: "missing ca file", cfg: config.TLSConfig{Mode: tlsVerifyFull, CAFile: "/nonexistent/ca.pem"}, wantErr: "failed to read tls ca file"},
+		{name: "ca file without certificates", cfg: config.TLSConfig{Mode: tlsVerifyFull, CAFile: empty}, wantErr: "no certificates found"},
+		{name: "certificate without key", cfg: config.TLSConfig{Mode: tlsRequire, CertFile: certFile}, wantErr: "failed to load tls client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCfg, err := newTLSConfig(tt.cfg, "db.internal")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newTLSConfig error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.nilConfig {
+				if tlsCfg != nil {
+					t.Fatalf("TLS config %+v, want none", tlsCfg)
+				}
+				return
+			}
+			if tlsCfg.InsecureSkipVerify != tt.insecure || (tlsCfg.VerifyPeerCertificate != nil) != tt.verifyPeer || tlsCfg.ServerName != tt.serverName {
+				t.Fatalf("InsecureSkipVerify %v, VerifyPeerCertificate set %v, ServerName %q",
+					tlsCfg.InsecureSkipVerify, tlsCfg.VerifyPeerCertificate != nil, tlsCfg.ServerName)
+			}
+			if (tt.cfg.CAFile != "") != (tlsCfg.RootCAs != nil) {
+				t.Errorf("RootCAs set %v with ca_file %q", tlsCfg.RootCAs != nil, tt.cfg.CAFile)
+			}
+			if (tt.cfg.CertFile != "") != (len(tlsCfg.Certificates) == 1) {
+				t.Errorf("%d client certificates with cert_file %q", len(tlsCfg.Certificates), tt.cfg.CertFile)
+			}
+		})
+	}
+}
+
+// TestTLSModesHandshake connects to a server whose certificate is not valid
+// for the host name dialled
+func TestTLSModesHandshake(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	// The failed handshakes are expected
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	otherCA, _ := writeCert(t)
+
+	tests := []struct {
+		name   string
+		cfg    config.TLSConfig
+		wantOK bool
+	}{
+		{"require accepts any certificate", config.TLSConfig{Mode: tlsRequire}, true},
+		{"verify-ca ignores the host name", config.TLSConfig{Mode: tlsVerifyCA, CAFile: caFile}, true},
+		{"verify-ca checks the chain", config.TLSConfig{Mode: tlsVerifyCA, CAFile: otherCA}, false},
+		{"verify-full checks the host name", config.TLSConfig{Mode: tlsVerifyFull, CAFile: caFile}, false},
+		{"verify-full with the certificate's name", config.TLSConfig{Mode: tlsVerifyFull, CAFile: caFile, ServerName: "example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsCfg, err := newTLSConfig(tt.cfg, "db.internal")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), tlsCfg)
+			if err == nil {
+				conn.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Fatalf("handshake error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPgTLSParams(t *testing.T) {
+	tests := []struct {
+		cfg  config.TLSConfig
+		want map[string]string
+	}{
+		{config.TLSConfig{}, nil},
+		{config.TLSConfig{Mode: tlsDisable}, map[string]string{"sslmode": "disable"}},
+		{config.TLSConfig{Mode: tlsVerifyFull, CAFile: "ca.pem", CertFile: "client.crt", KeyFile: "client.key", ServerName: "ignored"},
+			map[string]string{"sslmode": "verify-full", "sslrootcert": "ca.pem", "sslcert": "client.crt", "sslkey": "client.key"}},
+	}
+	for _, tt := range tests {
+		if got := pgTLSParams(tt.cfg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pgTLSParams(%+v) = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestMySQLTLSOptions(t *testing.T) {
+	tests := []struct {
+		cfg  config.TLSConfig
+		want []string
+	}{
+		{config.TLSConfig{}, nil},
+		{config.TLSConfig{Mode: tlsDisable}, []string{"loose-ssl-mode=DISABLED", "loose-skip-ssl"}},
+		{config.TLSConfig{Mode: tlsRequire}, []string{"loose-ssl-mode=REQUIRED", "loose-ssl"}},
+		{config.TLSConfig{Mode: tlsVerifyCA, CAFile: "ca.pem"}, []string{"loose-ssl-mode=VERIFY_CA", "loose-ssl", `ssl-ca="ca.pem"`}},
+		{config.TLSConfig{Mode: tlsVerifyFull, CAFile: "ca.pem", CertFile: "client.crt", KeyFile: "client.key"},
+			[]string{"loose-ssl-mode=VERIFY_IDENTITY", "loose-ssl", "loose-ssl-verify-server-cert", `ssl-ca="ca.pem"`, `ssl-cert="client.crt"`, `ssl-key="client.key"`}},
+	}
+	for _, tt := range tests {
+		if got := mysqlTLSOptions(tt.cfg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mysqlTLSOptions(%+v) = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestMongoTLSArgs(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	tests := []struct {
+		name string
+		cfg  config.TLSConfig
+		want []string
+	}{
+		{"unset", config.TLSConfig{}, nil},
+		{"disable", config.TLSConfig{Mode: tlsDisable}, nil},
+		{"require", config.TLSConfig{Mode: tlsRequire}, []string{"--ssl", "--sslAllowInvalidCertificates", "--sslAllowInvalidHostnames"}},
+		{"verify-ca", config.TLSConfig{Mode: tlsVerifyCA, CAFile: "ca.pem"}, []string{"--ssl", "--sslCAFile=ca.pem", "--sslAllowInvalidHostnames"}},
+		{"verify-full", config.TLSConfig{Mode: tlsVerifyFull, CAFile: "ca.pem"}, []string{"--ssl", "--sslCAFile=ca.pem"}},
+		{"combined pem", config.TLSConfig{Mode: tlsVerifyFull, CertFile: certFile}, []string{"--ssl", "--sslPEMKeyFile=" + certFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, cleanup, err := mongoTLSArgs(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Fatalf("args %v, want %v", args, tt.want)
+			}
+		})
+	}
+
+	t.Run("separate cert and key", func(t *testing.T) {
+		args, cleanup, err := mongoTLSArgs(config.TLSConfig{Mode: tlsVerifyFull, CertFile: certFile, KeyFile: keyFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemFile, ok := strings.CutPrefix(args[len(args)-1], "--sslPEMKeyFile=")
+		if !ok || pemFile == certFile {
+			t.Fatalf("args %v", args)
+		}
+		if _, err := tls.LoadX509KeyPair(pemFile, pemFile); err != nil {
+			t.Fatalf("combined PEM file: %v", err)
+		}
+		cleanup()
+		if _, err := os.Stat(pemFile); !os.IsNotExist(err) {
+			t.Fatalf("combined PEM file left behind: %v", err)
+		}
+	})
+}
@@ -1,17 +1,83 @@
 package logger
 
 import (
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
-)
+	"strings"
 
-var (
-	Info  *log.Logger
-	Error *log.Logger
+	"github.com/antigravity/dbbackup/internal/config"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func Init(level string) {
-	// Simple logger setup
-	Info = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	Error = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+func init() {
+	// Usable before Init, e.g. when no config file was found
+	slog.SetDefault(slog.New(newHandler(os.Stdout, "text", slog.LevelInfo)))
+}
+
+// Init configures the process-wide logger from the log section of the config.
+// It also becomes the slog default, so output from the standard log package
+// goes through the same handler and redaction.
+func Init(cfg config.LogConfig) error {
+	level, err := parseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	format := strings.ToLower(cfg.Format)
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("unsupported log format: %s", cfg.Format)
+	}
+
+	var out io.Writer = os.Stdout
+	if cfg.File != "" {
+		out = &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+	}
+
+	slog.SetDefault(slog.New(newHandler(out, format, level)))
+	return nil
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unsupported log level: %s", level)
+	}
+}
+
+func newHandler(out io.Writer, format string, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		AddSource:   level <= slog.LevelDebug,
+		ReplaceAttr: redactAttr,
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(out, opts)
+	}
+	return slog.NewTextHandler(out, opts)
+}
+
+func Debug(msg string, args ...any) { slog.Debug(msg, args...) }
+func Info(msg string, args ...any)  { slog.Info(msg, args...) }
+func Warn(msg string, args ...any)  { slog.Warn(msg, args...) }
+func Error(msg string, args ...any) { slog.Error(msg, args...) }
+
+// With returns a logger that adds args to every record, e.g. a run ID
+func With(args ...any) *slog.Logger {
+	return slog.Default().With(args...)
 }
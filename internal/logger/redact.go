@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// key=value and key: value pairs whose key looks like a credential
+	credentialPattern = regexp.MustCompile(`(?i)([\w-]*(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|customer[_-]?key)[\w-]*)(\s*[=:]\s*)("[^"]*"|'[^']*'|[^\s&;,"']+)`)
+	// user:password@ in connection strings and URLs
+	userinfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*):[^@\s]+@`)
+	// Authorization headers
+	authorizationPattern = regexp.MustCompile(`(?i)(authorization:\s*(?:bearer|basic)\s+)\S+`)
+	// attribute keys whose value is never logged
+	sensitiveKeyPattern = regexp.MustCompile(`(?i)password|passwd|secret|token|api[_-]?key|authorization|credential`)
+
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// AddSecret registers a value that must never appear in logs, such as a
+// configured password. Very short values are ignored to avoid mangling
+// unrelated output.
+func AddSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		if len(v) >= 4 {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Redact masks passwords, tokens and the credentials of connection strings in
+// s. It is applied to every log record and should be used on command output
+// before it ends up in an error.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsMu.RUnlock()
+
+	s = userinfoPattern.ReplaceAllString(s, "$1:"+redacted+"@")
+	s = authorizationPattern.ReplaceAllString(s, "$1"+redacted)
+	return credentialPattern.ReplaceAllString(s, "$1$2"+redacted)
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.MessageKey && sensitiveKeyPattern.MatchString(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/antigravity/dbbackup/internal/notifier"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Recorder turns backup, restore and prune events into Prometheus metrics. It
+// implements notifier.Notifier so that it receives the same events as the
+// notification channels.
+type Recorder struct {
+	registry      *prometheus.Registry
+	lastSuccess   *prometheus.GaugeVec
+	lastRun       *prometheus.GaugeVec
+	phaseDuration *prometheus.GaugeVec
+	artifactSize  *prometheus.GaugeVec
+	failures      *prometheus.CounterVec
+	uploadedBytes *prometheus.CounterVec
+
+	mu        sync.Mutex
+	succeeded map[string]bool // job and operation pairs with a success in this process
+}
+
+func NewRecorder() *Recorder {
+	r := &Recorder{
+		registry:  prometheus.NewRegistry(),
+		succeeded: map[string]bool{},
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dbbackup_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful operation.",
+		}, []string{"job", "operation"}),
+		lastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dbbackup_last_run_duration_seconds",
+			Help: "Duration of the last operation, successful or not.",
+		}, []string{"job", "operation", "status"}),
+		phaseDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dbbackup_phase_duration_seconds",
+			Help: "Duration of each phase of the last operation.",
+		}, []string{"job", "operation", "phase"}),
+		artifactSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dbbackup_artifact_size_bytes",
+			Help: "Size of the last backup artifact.",
+		}, []string{"job"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbbackup_failures_total",
+			Help: "Failed operations by the phase they failed in.",
+		}, []string{"job", "operation", "phase"}),
+		uploadedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbbackup_uploaded_bytes_total",
+			Help: "Bytes uploaded per storage.",
+		}, []string{"job", "storage"}),
+	}
+
+	r.registry.MustRegister(r.lastSuccess, r.lastRun, r.phaseDuration, r.artifactSize, r.failures, r.uploadedBytes)
+	return r
+}
+
+func (r *Recorder) Notify(event notifier.Event) error {
+	if event.Status == notifier.StatusStarted {
+		return nil
+	}
+
+	r.lastRun.WithLabelValues(event.Job, event.Operation, string(event.Status)).Set(event.Duration.Seconds())
+	for phase, d := range event.Phases {
+		r.phaseDuration.WithLabelValues(event.Job, event.Operation, phase).Set(d.Seconds())
+	}
+
+	switch event.Status {
+	case notifier.StatusSuccess:
+		r.lastSuccess.WithLabelValues(event.Job, event.Operation).Set(float64(event.Time.Unix()))
+		r.mu.Lock()
+		r.succeeded[event.Job+"/"+event.Operation] = true
+		r.mu.Unlock()
+		if event.Operation == "backup" {
+			r.artifactSize.WithLabelValues(event.Job).Set(float64(event.Size))
+			r.uploadedBytes.WithLabelValues(event.Job, event.Storage).Add(float64(event.Size))
+		}
+	case notifier.StatusFailure:
+		r.failures.WithLabelValues(event.Job, event.Operation, event.Phase).Inc()
+	}
+	return nil
+}
+
+// Push sends the metrics to a Prometheus Pushgateway, grouped under the job
+// and operation. It uses POST semantics so that a failed run doesn't wipe the
+// last success timestamp of an earlier one, and the operation in the grouping
+// key keeps a restore or prune from replacing the metrics of the last backup.
+func (r *Recorder) Push(url, job, operation string) error {
+	return push.New(url, job).
+		Grouping("operation", operation).
+		Gatherer(withoutGroupingLabels{r.registry}).
+		Add()
+}
+
+// withoutGroupingLabels drops the job and operation labels, which the
+// Pushgateway sets from the grouping key and refuses in pushed metrics
+type withoutGroupingLabels struct {
+	prometheus.Gatherer
+}
+
+func (g withoutGroupingLabels) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := m.Label[:0]
+			for _, l := range m.Label {
+				if l.GetName() != "job" && l.GetName() != "operation" {
+					labels = append(labels, l)
+				}
+			}
+			m.Label = labels
+		}
+	}
+	return families, err
+}
+
+// TextfilePath returns the textfile an operation writes its metrics to.
+// Backups use the configured path; restores and prunes write next to it, e.g.
+// dbbackup-restore.prom, so that they don't replace the metrics of the last
+// backup.
+func TextfilePath(path, operation string) string {
+	if operation == "backup" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + operation + ext
+}
+
+// WriteTextfile writes the metrics for the node_exporter textfile collector.
+// Last success timestamps from the previous file are carried over, so that a
+// failed run doesn't make it look like there never was a successful one.
+func (r *Recorder) WriteTextfile(path string) error {
+	if err := r.carryOverLastSuccess(path); err != nil {
+		return err
+	}
+	return prometheus.WriteToTextfile(path, r.registry)
+}
+
+func (r *Recorder) carryOverLastSuccess(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(file)
+	if err != nil {
+		// A corrupt file is simply replaced
+		return nil
+	}
+
+	family, ok := families["dbbackup_last_success_timestamp_seconds"]
+	if !ok {
+		return nil
+	}
+	for _, m := range family.GetMetric() {
+		labels := prometheus.Labels{}
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+
+		// Only fill in jobs that didn't succeed in this run
+		if r.hasSucceeded(labels["job"], labels["operation"]) {
+			continue
+		}
+		if gauge, err := r.lastSuccess.GetMetricWith(labels); err == nil {
+			gauge.Set(m.GetGauge().GetValue())
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) hasSucceeded(job, operation string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.succeeded[job+"/"+operation]
+}
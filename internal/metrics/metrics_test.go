@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/notifier"
+)
+
+var success = notifier.Event{
+	Job:       "postgres/app",
+	Operation: "backup",
+	Status:    notifier.StatusSuccess,
+	Duration:  90 * time.Second,
+	Size:      4096,
+	Storage:   "s3",
+	Time:      time.Unix(1767366245, 0),
+	Phases:    map[string]time.Duration{"dump": time.Minute, "upload": 30 * time.Second},
+}
+
+var failure = notifier.Event{
+	Job:       "postgres/app",
+	Operation: "backup",
+	Phase:     "upload",
+	Status:    notifier.StatusFailure,
+	Duration:  10 * time.Second,
+	Time:      time.Unix(1767452645, 0),
+}
+
+// scrape returns the metrics in the text format
+func scrape(t *testing.T, r *Recorder) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dbbackup.prom")
+	if err := r.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	return string(data)
+}
+
+func assertContains(t *testing.T, text string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, text)
+		}
+	}
+}
+
+func TestRecorderNotify(t *testing.T) {
+	r := NewRecorder()
+	r.Notify(notifier.Event{Job: "postgres/app", Operation: "backup", Status: notifier.StatusStarted})
+	r.Notify(success)
+	r.Notify(failure)
+
+	assertContains(t, scrape(t, r),
+		`dbbackup_last_success_timestamp_seconds{job="postgres/app",operation="backup"} 1.767366245e+09`,
+		`dbbackup_phase_duration_seconds{job="postgres/app",operation="backup",phase="dump"} 60`,
+		`dbbackup_artifact_size_bytes{job="postgres/app"} 4096`,
+		`dbbackup_uploaded_bytes_total{job="postgres/app",storage="s3"} 4096`,
+		`dbbackup_failures_total{job="postgres/app",operation="backup",phase="upload"} 1`,
+		`dbbackup_last_run_duration_seconds{job="postgres/app",operation="backup",status="failure"} 10`,
+	)
+}
+
+func TestWriteTextfileKeepsEarlierSuccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbbackup.prom")
+
+	first := NewRecorder()
+	first.Notify(success)
+	if err := first.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later failed run must not drop the last success
+	second := NewRecorder()
+	second.Notify(failure)
+	if err := second.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	assertContains(t, string(data),
+		`dbbackup_last_success_timestamp_seconds{job="postgres/app",operation="backup"} 1.767366245e+09`,
+		`dbbackup_failures_total{job="postgres/app",operation="backup",phase="upload"} 1`,
+	)
+}
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		method, path, body = req.Method, req.URL.Path, string(data)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	r := NewRecorder()
+	r.Notify(success)
+	if err := r.Push(srv.URL, "postgres/app", "backup"); err != nil {
+		t.Fatal(err)
+	}
+
+	// POST adds to the group instead of replacing it
+	if method != http.MethodPost {
+		t.Fatalf("pushed with %s", method)
+	}
+	// The job becomes the grouping key, base64 encoded because of the slash
+	if path != "/metrics/job@base64/cG9zdGdyZXMvYXBw/operation/backup" {
+		t.Fatalf("pushed to %s", path)
+	}
+	if !strings.Contains(body, "dbbackup_last_success_timestamp_seconds") {
+		t.Fatal("pushed body has no last success timestamp")
+	}
+	if strings.Contains(body, "postgres/app") {
+		t.Fatal("pushed body carries the grouping labels")
+	}
+}
+
+func TestRecorderRestoreAndPrune(t *testing.T) {
+	r := NewRecorder()
+	restored := success
+	restored.Operation = "restore"
+	restored.Phases = map[string]time.Duration{"download": time.Minute}
+	pruneFailure := failure
+	pruneFailure.Operation = "prune"
+	pruneFailure.Phase = "prune"
+	r.Notify(restored)
+	r.Notify(pruneFailure)
+
+	text := scrape(t, r)
+	assertContains(t, text,
+		`dbbackup_last_success_timestamp_seconds{job="postgres/app",operation="restore"} 1.767366245e+09`,
+		`dbbackup_phase_duration_seconds{job="postgres/app",operation="restore",phase="download"} 60`,
+		`dbbackup_failures_total{job="postgres/app",operation="prune",phase="prune"} 1`,
+	)
+	// Only backups upload artifacts
+	if strings.Contains(text, "dbbackup_artifact_size_bytes{") || strings.Contains(text, "dbbackup_uploaded_bytes_total{") {
+		t.Fatalf("restore recorded upload metrics:\n%s", text)
+	}
+}
+
+func TestTextfilePath(t *testing.T) {
+	tests := []struct {
+		operation string
+		want      string
+	}{
+		{"backup", "/var/lib/node_exporter/dbbackup.prom"},
+		{"restore", "/var/lib/node_exporter/dbbackup-restore.prom"},
+		{"prune", "/var/lib/node_exporter/dbbackup-prune.prom"},
+	}
+	for _, tt := range tests {
+		if got := TextfilePath("/var/lib/node_exporter/dbbackup.prom", tt.operation); got != tt.want {
+			t.Errorf("TextfilePath(%s) = %s, want %s", tt.operation, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"text/template"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+type DiscordNotifier struct {
+	WebhookURL string
+	// Template renders the complete JSON payload
+	Template *template.Template
+}
+
+func NewDiscordNotifier(cfg config.NotifierConfig, tmpl *template.Template) (*DiscordNotifier, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("discord requires url")
+	}
+	return &DiscordNotifier{WebhookURL: cfg.URL, Template: tmpl}, nil
+}
+
+func (d *DiscordNotifier) Notify(event Event) error {
+	body, err := render(d.Template, event)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		// Discord rejects embed descriptions longer than 4096 characters
+		text := event.Text()
+		if len(text) > 4096 {
+			text = text[:4093] + "..."
+		}
+
+		color, _ := strconv.ParseInt(statusColor(event.Status)[1:], 16, 32)
+		body, err = json.Marshal(map[string]any{
+			"embeds": []map[string]any{{
+				"title":       fmt.Sprintf("%s %s: %s", event.Operation, event.Status, event.Job),
+				"description": text,
+				"color":       color,
+			}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := post(d.WebhookURL, body, nil); err != nil {
+		return fmt.Errorf("discord notification failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+type EmailNotifier struct {
+	Config config.EmailConfig
+	// Template renders the message body
+	Template *template.Template
+}
+
+func NewEmailNotifier(cfg config.NotifierConfig, tmpl *template.Template) (*EmailNotifier, error) {
+	if cfg.Email.Host == "" || cfg.Email.From == "" || len(cfg.Email.To) == 0 {
+		return nil, fmt.Errorf("email requires host, from and to")
+	}
+	return &EmailNotifier{Config: cfg.Email, Template: tmpl}, nil
+}
+
+func (e *EmailNotifier) Notify(event Event) error {
+	body, err := message(e.Template, event)
+	if err != nil {
+		return err
+	}
+
+	port := e.Config.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(e.Config.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if e.Config.User != "" {
+		auth = smtp.PlainAuth("", e.Config.User, e.Config.Password, e.Config.Host)
+	}
+
+	subject := fmt.Sprintf("[dbbackup] %s %s: %s", event.Operation, event.Status, event.Job)
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.Config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.Config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", event.Time.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(body)
+	msg.WriteString("\r\n")
+
+	// SendMail upgrades to STARTTLS when the server offers it
+	if err := smtp.SendMail(addr, auth, e.Config.From, e.Config.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("email notification failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusStarted Status = "started"
+	StatusSuccess Status = "success"
+	StatusFailure Status = "failure"
+)
+
+// Event describes one step in the life of a backup job. Operation is backup,
+// restore or prune; Phase is the step within it (connect, dump, compress,
+// upload, download, decompress, restore, prune) and is set on failures.
+type Event struct {
+	Job       string        `json:"job"`
+	RunID     string        `json:"run_id,omitempty"` // correlates the event with logs and traces of the same run
+	Operation string        `json:"operation"`
+	Phase     string        `json:"phase,omitempty"`
+	Status    Status        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration_ns,omitempty"`
+	Artifact  string        `json:"artifact,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+	Storage   string        `json:"storage,omitempty"`
+	Database  string        `json:"database,omitempty"`
+	Time      time.Time     `json:"time"`
+	// Phases holds the duration of every completed phase of the operation
+	Phases map[string]time.Duration `json:"phases_ns,omitempty"`
+}
+
+// Text renders the default one-line description of the event
+func (e Event) Text() string {
+	op := "Job"
+	if e.Operation != "" {
+		op = strings.ToUpper(e.Operation[:1]) + e.Operation[1:]
+	}
+	subject := op
+	if e.Database != "" {
+		subject = fmt.Sprintf("%s of %s", op, e.Database)
+	}
+
+	switch e.Status {
+	case StatusStarted:
+		return fmt.Sprintf("%s started", subject)
+	case StatusFailure:
+		return fmt.Sprintf("%s failed during %s: %s", subject, e.Phase, e.Error)
+	}
+
+	msg := fmt.Sprintf("%s completed successfully in %s", subject, e.Duration.Round(time.Millisecond))
+	if e.Artifact != "" {
+		msg += ". File: " + e.Artifact
+		if e.Size > 0 {
+			msg += fmt.Sprintf(" (%s)", HumanBytes(e.Size))
+		}
+	}
+	return msg
+}
+
+// HumanBytes formats a byte count using binary units
+func HumanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// templateFuncs are available in notification templates
+var templateFuncs = template.FuncMap{
+	"bytes": HumanBytes,
+	"upper": strings.ToUpper,
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"color": statusColor,
+}
+
+// statusColor maps a status to a hex colour, e.g. for Slack attachments
+func statusColor(s Status) string {
+	switch s {
+	case StatusSuccess:
+		return "#2eb67d"
+	case StatusFailure:
+		return "#e01e5a"
+	default:
+		return "#439fe0"
+	}
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("notify").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// render executes tmpl against the event, or returns nil if there is no template
+func render(tmpl *template.Template, event Event) ([]byte, error) {
+	if tmpl == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("failed to render template: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var started = Event{
+	Job:       "postgres/app",
+	RunID:     "4bf92f3577b34da6a3ce929d0e0e4736",
+	Operation: "restore",
+	Status:    StatusStarted,
+	Database:  "app",
+	Time:      time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+}
+
+func TestEventText(t *testing.T) {
+	noDatabase := success
+	noDatabase.Database = ""
+	noDatabase.Operation = ""
+	noDatabase.Artifact = ""
+
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"started", started, "Restore of app started"},
+		{"success", success, "Backup of app completed successfully in 1.5s. File: backup_pg_app_20260102_150405.sql.gz (2.0 KiB)"},
+		{"failure", failure, "Backup of app failed during dump: pg_dump: connection refused"},
+		{"no database or artifact", noDatabase, "Job completed successfully in 1.5s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.event.Text(); got != tt.want {
+				t.Fatalf("Text() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateRendersEachEvent(t *testing.T) {
+	tmpl, err := parseTemplate(`{{ upper (printf "%s" .Status) }} {{ .Operation }} {{ .Job }} {{ color .Status }}` +
+		`{{ if .Phase }} phase={{ .Phase }}{{ end }}{{ if .Size }} size={{ bytes .Size }}{{ end }}` +
+		`{{ if .RunID }} run={{ .RunID }}{{ end }}{{ with .Phases }} dump={{ index . "dump" }}{{ end }} {{ json .Error }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withPhases := success
+	withPhases.Phases = map[string]time.Duration{"dump": time.Second, "upload": 500 * time.Millisecond}
+
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"started", started, `STARTED restore postgres/app #439fe0 run=4bf92f3577b34da6a3ce929d0e0e4736 ""`},
+		{"success", withPhases, `SUCCESS backup postgres/app #2eb67d size=2.0 KiB dump=1s ""`},
+		{"failure", failure, `FAILURE backup postgres/app #e01e5a phase=dump "pg_dump: connection refused"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := render(tmpl, tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Fatalf("render = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	tmpl, err := parseTemplate(`{{ .Missing }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := render(tmpl, success); err == nil || !strings.Contains(err.Error(), "failed to render template") {
+		t.Fatalf("unknown field = %v", err)
+	}
+
+	tmpl, err = parseTemplate(`{{ .Job }} is {{ .Status }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := render(tmpl, Event{Job: "app", Status: StatusSuccess}); err != nil {
+		t.Fatalf("zero-valued event = %v", err)
+	}
+}
+
+func TestMessageFallsBackToText(t *testing.T) {
+	got, err := message(nil, failure)
+	if err != nil || got != failure.Text() {
+		t.Fatalf("message without template = %q, %v", got, err)
+	}
+	if out, err := render(nil, failure); out != nil || err != nil {
+		t.Fatalf("render without template = %q, %v", out, err)
+	}
+
+	tmpl, _ := parseTemplate(`{{ .Job }}: {{ .Error }}`)
+	if got, _ := message(tmpl, failure); got != "postgres/app: pg_dump: connection refused" {
+		t.Fatalf("message = %q", got)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := HumanBytes(tt.n); got != tt.want {
+			t.Errorf("HumanBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+type Notifier interface {
+	Notify(event Event) error
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// New builds a notifier that fans out to every configured channel. The legacy
+// slack_webhook_url setting is treated as a Slack channel without filters.
+func New(cfg config.NotifyConfig) (Notifier, error) {
+	var multi Multi
+	if cfg.SlackWebhookURL != "" {
+		multi = append(multi, NewSlackNotifier(cfg))
+	}
+
+	for i, ch := range cfg.Channels {
+		n, err := newChannel(ch)
+		if err != nil {
+			return nil, fmt.Errorf("notify channel %d (%s): %v", i, ch.Type, err)
+		}
+		if len(ch.On) > 0 {
+			n = &Filtered{Notifier: n, On: ch.On}
+		}
+		multi = append(multi, n)
+	}
+	return multi, nil
+}
+
+func newChannel(ch config.NotifierConfig) (Notifier, error) {
+	tmpl, err := parseTemplate(ch.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ch.Type {
+	case "slack":
+		return &SlackNotifier{WebhookURL: ch.URL, Template: tmpl}, nil
+	case "email":
+		return NewEmailNotifier(ch, tmpl)
+	case "teams":
+		return NewTeamsNotifier(ch, tmpl)
+	case "discord":
+		return NewDiscordNotifier(ch, tmpl)
+	case "pagerduty":
+		return NewPagerDutyNotifier(ch, tmpl)
+	case "opsgenie":
+		return NewOpsgenieNotifier(ch, tmpl)
+	case "webhook":
+		return NewWebhookNotifier(ch, tmpl)
+	default:
+		return nil, fmt.Errorf("unsupported notifier type: %s", ch.Type)
+	}
+}
+
+// Multi sends every event to all of its notifiers and joins their errors
+type Multi []Notifier
+
+func (m Multi) Notify(event Event) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Filtered only forwards events matching one of the On entries. An entry
+// matches a status ("failure"), an operation ("restore") or both ("backup.success").
+type Filtered struct {
+	Notifier Notifier
+	On       []string
+}
+
+func (f *Filtered) Notify(event Event) error {
+	for _, on := range f.On {
+		if strings.EqualFold(on, string(event.Status)) ||
+			strings.EqualFold(on, event.Operation) ||
+			strings.EqualFold(on, event.Operation+"."+string(event.Status)) {
+			return f.Notifier.Notify(event)
+		}
+	}
+	return nil
+}
+
+// WithDefaults fills the job, database and storage of every event that
+// doesn't set them, so that the managers only report what they know.
+func WithDefaults(n Notifier, defaults Event) Notifier {
+	return &defaulted{Notifier: n, defaults: defaults}
+}
+
+type defaulted struct {
+	Notifier Notifier
+	defaults Event
+}
+
+func (d *defaulted) Notify(event Event) error {
+	if event.Job == "" {
+		event.Job = d.defaults.Job
+	}
+	if event.Database == "" {
+		event.Database = d.defaults.Database
+	}
+	if event.Storage == "" {
+		event.Storage = d.defaults.Storage
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return d.Notifier.Notify(event)
+}
+
+// message renders the event as text, through the channel template if there is one
+func message(tmpl *template.Template, event Event) (string, error) {
+	if tmpl == nil {
+		return event.Text(), nil
+	}
+	out, err := render(tmpl, event)
+	return string(out), err
+}
+
+// postJSON sends payload to url and fails on any non-2xx status
+func postJSON(url string, payload any, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(url, body, headers)
+}
+
+func post(url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("request failed with status: %d, body: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+package notifier
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// recorder is an HTTP stand-in for the webhook endpoints. It keeps every
+// request it receives and answers with status.
+type recorder struct {
+	mu       sync.Mutex
+	requests []recorded
+	status   int
+}
+
+type recorded struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newRecorder(t *testing.T) (*recorder, string) {
+	t.Helper()
+	rec := &recorder{status: http.StatusOK}
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+	return rec, srv.URL
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, recorded{path: req.URL.RequestURI(), header: req.Header, body: body})
+	status := r.status
+	r.mu.Unlock()
+	w.WriteHeader(status)
+	if status >= 300 {
+		w.Write([]byte("boom"))
+	}
+}
+
+func (r *recorder) last(t *testing.T) recorded {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) == 0 {
+		t.Fatal("no request received")
+	}
+	return r.requests[len(r.requests)-1]
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+var failure = Event{
+	Job:       "postgres/app",
+	Operation: "backup",
+	Phase:     "dump",
+	Status:    StatusFailure,
+	Error:     "pg_dump: connection refused",
+	Database:  "app",
+	Storage:   "s3",
+	Time:      time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+}
+
+var success = Event{
+	Job:       "postgres/app",
+	Operation: "backup",
+	Status:    StatusSuccess,
+	Duration:  1500 * time.Millisecond,
+	Artifact:  "backup_pg_app_20260102_150405.sql.gz",
+	Size:      2048,
+	Database:  "app",
+	Time:      time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+}
+
+func TestNewFiltersChannels(t *testing.T) {
+	failures, failuresURL := newRecorder(t)
+	restores, restoresURL := newRecorder(t)
+	everything, everythingURL := newRecorder(t)
+
+	n, err := New(config.NotifyConfig{Channels: []config.NotifierConfig{
+		{Type: "webhook", URL: failuresURL, On: []string{"failure"}},
+		{Type: "webhook", URL: restoresURL, On: []string{"restore.success"}},
+		{Type: "webhook", URL: everythingURL},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := success
+	restore.Operation = "restore"
+	for _, event := range []Event{failure, success, restore} {
+		if err := n.Notify(event); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+
+	if failures.count() != 1 || restores.count() != 1 || everything.count() != 3 {
+		t.Fatalf("requests: failure %d, restore.success %d, unfiltered %d", failures.count(), restores.count(), everything.count())
+	}
+}
+
+func TestNewRejectsInvalidChannels(t *testing.T) {
+	for _, ch := range []config.NotifierConfig{
+		{Type: "carrier-pigeon"},
+		{Type: "webhook"},
+		{Type: "pagerduty"},
+		{Type: "opsgenie"},
+		{Type: "email", Email: config.EmailConfig{Host: "smtp"}},
+		{Type: "slack", URL: "http://x", Template: "{{ .Job "},
+	} {
+		if _, err := New(config.NotifyConfig{Channels: []config.NotifierConfig{ch}}); err == nil {
+			t.Errorf("New accepted %+v", ch)
+		}
+	}
+}
+
+func TestMultiJoinsErrors(t *testing.T) {
+	broken, brokenURL := newRecorder(t)
+	broken.status = http.StatusInternalServerError
+	ok, okURL := newRecorder(t)
+
+	n := Multi{&WebhookNotifier{URL: brokenURL}, &WebhookNotifier{URL: okURL}}
+	err := n.Notify(success)
+	if err == nil || !strings.Contains(err.Error(), "status: 500, body: boom") {
+		t.Fatalf("Notify = %v", err)
+	}
+	if ok.count() != 1 {
+		t.Fatal("a failing channel kept the others from being notified")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rec, url := newRecorder(t)
+	n, err := NewWebhookNotifier(config.NotifierConfig{URL: url, Secret: "s3cret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(failure); err != nil {
+		t.Fatal(err)
+	}
+
+	req := rec.last(t)
+	ts := req.header.Get("X-Dbbackup-Timestamp")
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(ts + "."))
+	mac.Write(req.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get("X-Dbbackup-Signature") != want {
+		t.Fatalf("signature %q, want %q", req.header.Get("X-Dbbackup-Signature"), want)
+	}
+
+	var got struct {
+		Job     string `json:"job"`
+		Phase   string `json:"phase"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(req.body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Job != "postgres/app" || got.Phase != "dump" || got.Message != failure.Text() {
+		t.Fatalf("payload %s", req.body)
+	}
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	rec, url := newRecorder(t)
+	n, _ := NewWebhookNotifier(config.NotifierConfig{URL: url}, nil)
+	if err := n.Notify(success); err != nil {
+		t.Fatal(err)
+	}
+	if sig := rec.last(t).header.Get("X-Dbbackup-Signature"); sig != "" {
+		t.Fatalf("unexpected signature %q", sig)
+	}
+}
+
+func TestTemplatesReplacePayload(t *testing.T) {
+	rec, url := newRecorder(t)
+	n, err := New(config.NotifyConfig{Channels: []config.NotifierConfig{{
+		Type:     "slack",
+		URL:      url,
+		Template: `{"text": {{ json (printf "%s %s (%s)" .Job .Status (bytes .Size)) }}}`,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(success); err != nil {
+		t.Fatal(err)
+	}
+	if body := string(rec.last(t).body); body != `{"text": "postgres/app success (2.0 KiB)"}` {
+		t.Fatalf("body %s", body)
+	}
+}
+
+func TestDefaultPayloads(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"slack", `"color":"#e01e5a"`},
+		{"teams", `"color":"Attention"`},
+		{"discord", `"title":"backup failure: postgres/app"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			rec, url := newRecorder(t)
+			n, err := newChannel(config.NotifierConfig{Type: tt.typ, URL: url})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := n.Notify(failure); err != nil {
+				t.Fatal(err)
+			}
+			if body := string(rec.last(t).body); !strings.Contains(body, tt.want) {
+				t.Fatalf("body %s does not contain %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestPagerDutyTriggersAndResolves(t *testing.T) {
+	rec, url := newRecorder(t)
+	n, err := NewPagerDutyNotifier(config.NotifierConfig{RoutingKey: "key", URL: url}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := success
+	started.Status = StatusStarted
+	for _, event := range []Event{started, failure, success} {
+		if err := n.Notify(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rec.count() != 2 {
+		t.Fatalf("%d requests, started events must not be sent", rec.count())
+	}
+
+	var actions []string
+	for _, req := range rec.requests {
+		var payload struct {
+			RoutingKey  string `json:"routing_key"`
+			DedupKey    string `json:"dedup_key"`
+			EventAction string `json:"event_action"`
+		}
+		json.Unmarshal(req.body, &payload)
+		if payload.RoutingKey != "key" || payload.DedupKey != "dbbackup/postgres/app/backup" {
+			t.Fatalf("payload %s", req.body)
+		}
+		actions = append(actions, payload.EventAction)
+	}
+	if strings.Join(actions, ",") != "trigger,resolve" {
+		t.Fatalf("actions %v", actions)
+	}
+}
+
+func TestOpsgenieOpensAndClosesAlert(t *testing.T) {
+	rec, url := newRecorder(t)
+	n, err := NewOpsgenieNotifier(config.NotifierConfig{APIKey: "key", URL: url + "/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Notify(failure); err != nil {
+		t.Fatal(err)
+	}
+	open := rec.last(t)
+	if open.path != "/v2/alerts" || open.header.Get("Authorization") != "GenieKey key" {
+		t.Fatalf("alert sent to %s with %q", open.path, open.header.Get("Authorization"))
+	}
+	if !strings.Contains(string(open.body), `"alias":"dbbackup/postgres/app/backup"`) {
+		t.Fatalf("alert %s", open.body)
+	}
+
+	if err := n.Notify(success); err != nil {
+		t.Fatal(err)
+	}
+	if path := rec.last(t).path; path != "/v2/alerts/dbbackup%2Fpostgres%2Fapp%2Fbackup/close?identifierType=alias" {
+		t.Fatalf("close sent to %s", path)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	var got Event
+	n := WithDefaults(notifierFunc(func(e Event) error { got = e; return nil }), Event{Job: "job", Database: "db", Storage: "local"})
+
+	n.Notify(Event{Operation: "prune", Storage: "s3"})
+	if got.Job != "job" || got.Database != "db" || got.Storage != "s3" || got.Time.IsZero() {
+		t.Fatalf("event %+v", got)
+	}
+}
+
+type notifierFunc func(Event) error
+
+func (f notifierFunc) Notify(e Event) error { return f(e) }
+
+// fakeSMTP accepts a single plain-text SMTP session with AUTH PLAIN and
+// records the credentials, envelope and message it receives. done is closed
+// when the session ends.
+type fakeSMTP struct {
+	done chan struct{}
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+func startSMTPServer(t *testing.T) (*fakeSMTP, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	fake := &fakeSMTP{done: make(chan struct{})}
+	go func() {
+		defer close(fake.done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fake.serve(conn)
+	}()
+	return fake, ln.Addr().(*net.TCPAddr).Port
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			_, creds, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(creds)
+			f.auth = string(decoded)
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			f.from = arg
+			reply("250 OK")
+		case "RCPT":
+			f.to = append(f.to, arg)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			f.data = data.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestEmailAgainstStandIn(t *testing.T) {
+	fake, port := startSMTPServer(t)
+
+	n, err := NewEmailNotifier(config.NotifierConfig{Email: config.EmailConfig{
+		Host:     "127.0.0.1",
+		Port:     port,
+		User:     "backup",
+		Password: "secret",
+		From:     "dbbackup@example.com",
+		To:       []string{"ops@example.com", "dba@example.com"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(failure); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	<-fake.done
+
+	if fake.auth != "\x00backup\x00secret" {
+		t.Fatalf("auth %q", fake.auth)
+	}
+	if fake.from != "FROM:<dbbackup@example.com>" || len(fake.to) != 2 {
+		t.Fatalf("envelope from %q to %v", fake.from, fake.to)
+	}
+	for _, want := range []string{
+		"Subject: [dbbackup] backup failure: postgres/app\r\n",
+		"To: ops@example.com, dba@example.com\r\n",
+		failure.Text(),
+	} {
+		if !strings.Contains(fake.data, want) {
+			t.Fatalf("message does not contain %q:\n%s", want, fake.data)
+		}
+	}
+}
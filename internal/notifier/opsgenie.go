@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+const opsgenieAPIURL = "https://api.opsgenie.com"
+
+// OpsgenieNotifier opens an Opsgenie alert per job and operation on failure and
+// closes it on the next success.
+type OpsgenieNotifier struct {
+	APIKey string
+	URL    string
+	// Template renders the alert description
+	Template *template.Template
+}
+
+func NewOpsgenieNotifier(cfg config.NotifierConfig, tmpl *template.Template) (*OpsgenieNotifier, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("opsgenie requires api_key")
+	}
+
+	apiURL := cfg.URL
+	if apiURL == "" {
+		apiURL = opsgenieAPIURL
+	}
+	return &OpsgenieNotifier{APIKey: cfg.APIKey, URL: strings.TrimSuffix(apiURL, "/"), Template: tmpl}, nil
+}
+
+func (o *OpsgenieNotifier) Notify(event Event) error {
+	headers := map[string]string{"Authorization": "GenieKey " + o.APIKey}
+	alias := fmt.Sprintf("dbbackup/%s/%s", event.Job, event.Operation)
+
+	var err error
+	switch event.Status {
+	case StatusFailure:
+		var description string
+		description, err = message(o.Template, event)
+		if err != nil {
+			return err
+		}
+
+		title := fmt.Sprintf("%s of %s failed during %s", event.Operation, event.Job, event.Phase)
+		if len(title) > 130 {
+			title = title[:127] + "..."
+		}
+		err = postJSON(o.URL+"/v2/alerts", map[string]any{
+			"message":     title,
+			"description": description,
+			"alias":       alias,
+			"source":      "dbbackup",
+			"priority":    "P2",
+			"details": map[string]string{
+				"database": event.Database,
+				"storage":  event.Storage,
+				"phase":    event.Phase,
+				"error":    event.Error,
+			},
+		}, headers)
+	case StatusSuccess:
+		err = postJSON(o.URL+"/v2/alerts/"+url.PathEscape(alias)+"/close?identifierType=alias", map[string]string{
+			"source": "dbbackup",
+			"note":   event.Text(),
+		}, headers)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("opsgenie notification failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package notifier
+
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyNotifier triggers a PagerDuty Events v2 incident per job and
+// operation on failure, and resolves it on the next success.
+type PagerDutyNotifier struct {
+	RoutingKey string
+	URL        string
+	// Template renders the incident summary
+	Template *template.Template
+}
+
+func NewPagerDutyNotifier(cfg config.NotifierConfig, tmpl *template.Template) (*PagerDutyNotifier, error) {
+	if cfg.RoutingKey == "" {
+		return nil, fmt.Errorf("pagerduty requires routing_key")
+	}
+
+	url := cfg.URL
+	if url == "" {
+		url = pagerDutyEventsURL
+	}
+	return &PagerDutyNotifier{RoutingKey: cfg.RoutingKey, URL: url, Template: tmpl}, nil
+}
+
+func (p *PagerDutyNotifier) Notify(event Event) error {
+	payload := map[string]any{
+		"routing_key": p.RoutingKey,
+		"dedup_key":   fmt.Sprintf("dbbackup/%s/%s", event.Job, event.Operation),
+	}
+
+	switch event.Status {
+	case StatusFailure:
+		summary, err := message(p.Template, event)
+		if err != nil {
+			return err
+		}
+		if len(summary) > 1024 {
+			summary = summary[:1021] + "..."
+		}
+		payload["event_action"] = "trigger"
+		payload["payload"] = map[string]any{
+			"summary":        summary,
+			"source":         event.Job,
+			"severity":       "error",
+			"component":      event.Database,
+			"group":          event.Operation,
+			"class":          event.Phase,
+			"timestamp":      event.Time,
+			"custom_details": event,
+		}
+	case StatusSuccess:
+		payload["event_action"] = "resolve"
+	default:
+		return nil
+	}
+
+	if err := postJSON(p.URL, payload, nil); err != nil {
+		return fmt.Errorf("pagerduty notification failed: %v", err)
+	}
+	return nil
+}
@@ -1,46 +1,67 @@
 package notifier
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"text/template"
 
 	"github.com/antigravity/dbbackup/internal/config"
 )
 
-type Notifier interface {
-	Notify(message string) error
-}
-
 type SlackNotifier struct {
 	WebhookURL string
+	// Template renders the complete JSON payload, e.g. custom Block Kit blocks
+	Template *template.Template
 }
 
 func NewSlackNotifier(cfg config.NotifyConfig) *SlackNotifier {
 	return &SlackNotifier{WebhookURL: cfg.SlackWebhookURL}
 }
 
-func (s *SlackNotifier) Notify(message string) error {
+func (s *SlackNotifier) Notify(event Event) error {
 	if s.WebhookURL == "" {
 		return nil // No-op if no webhook URL
 	}
 
-	payload := map[string]string{"text": message}
-	jsonPayload, err := json.Marshal(payload)
+	body, err := render(s.Template, event)
 	if err != nil {
 		return err
 	}
+	if body == nil {
+		body, err = json.Marshal(slackPayload(event))
+		if err != nil {
+			return err
+		}
+	}
 
-	resp, err := http.Post(s.WebhookURL, "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return err
+	if err := post(s.WebhookURL, body, nil); err != nil {
+		return fmt.Errorf("slack notification failed: %v", err)
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("slack notification failed with status: %d", resp.StatusCode)
+// slackPayload renders the event as an attachment coloured by status
+func slackPayload(event Event) map[string]any {
+	text := event.Text()
+	context := fmt.Sprintf("*Job:* %s", event.Job)
+	if event.Storage != "" {
+		context += fmt.Sprintf(" | *Storage:* %s", event.Storage)
 	}
 
-	return nil
+	return map[string]any{
+		"text": text,
+		"attachments": []map[string]any{{
+			"color": statusColor(event.Status),
+			"blocks": []map[string]any{
+				{
+					"type": "section",
+					"text": map[string]string{"type": "mrkdwn", "text": text},
+				},
+				{
+					"type":     "context",
+					"elements": []map[string]string{{"type": "mrkdwn", "text": context}},
+				},
+			},
+		}},
+	}
 }
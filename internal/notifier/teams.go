@@ -0,0 +1,80 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// TeamsNotifier posts an Adaptive Card to a Microsoft Teams Workflows webhook
+type TeamsNotifier struct {
+	WebhookURL string
+	// Template renders the complete JSON payload
+	Template *template.Template
+}
+
+func NewTeamsNotifier(cfg config.NotifierConfig, tmpl *template.Template) (*TeamsNotifier, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("teams requires url")
+	}
+	return &TeamsNotifier{WebhookURL: cfg.URL, Template: tmpl}, nil
+}
+
+func (t *TeamsNotifier) Notify(event Event) error {
+	body, err := render(t.Template, event)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		body, err = json.Marshal(teamsPayload(event))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := post(t.WebhookURL, body, nil); err != nil {
+		return fmt.Errorf("teams notification failed: %v", err)
+	}
+	return nil
+}
+
+func teamsPayload(event Event) map[string]any {
+	color := "Accent"
+	switch event.Status {
+	case StatusSuccess:
+		color = "Good"
+	case StatusFailure:
+		color = "Attention"
+	}
+
+	facts := []map[string]string{{"title": "Job", "value": event.Job}}
+	if event.Storage != "" {
+		facts = append(facts, map[string]string{"title": "Storage", "value": event.Storage})
+	}
+
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]any{
+					{
+						"type":  "TextBlock",
+						"text":  event.Text(),
+						"wrap":  true,
+						"color": color,
+					},
+					{
+						"type":  "FactSet",
+						"facts": facts,
+					},
+				},
+			},
+		}},
+	}
+}
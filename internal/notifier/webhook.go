@@ -0,0 +1,67 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// WebhookNotifier posts the event as JSON to an arbitrary URL. When a secret is
+// configured the request carries X-Dbbackup-Signature: sha256=<hex HMAC of
+// "<timestamp>.<body>">, with the timestamp in X-Dbbackup-Timestamp.
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	// Template renders the complete JSON payload instead of the raw event
+	Template *template.Template
+}
+
+func NewWebhookNotifier(cfg config.NotifierConfig, tmpl *template.Template) (*WebhookNotifier, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook requires url")
+	}
+	return &WebhookNotifier{URL: cfg.URL, Secret: cfg.Secret, Template: tmpl}, nil
+}
+
+func (w *WebhookNotifier) Notify(event Event) error {
+	body, err := render(w.Template, event)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		body, err = json.Marshal(struct {
+			Event
+			Message string `json:"message"`
+		}{event, event.Text()})
+		if err != nil {
+			return err
+		}
+	}
+
+	headers := map[string]string{}
+	if w.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		headers["X-Dbbackup-Timestamp"] = ts
+		headers["X-Dbbackup-Signature"] = "sha256=" + sign(w.Secret, ts, body)
+	}
+
+	if err := post(w.URL, body, headers); err != nil {
+		return fmt.Errorf("webhook notification failed: %v", err)
+	}
+	return nil
+}
+
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
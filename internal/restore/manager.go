@@ -1,59 +1,261 @@
 package restore
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/antigravity/dbbackup/internal/backup"
 	"github.com/antigravity/dbbackup/internal/database"
-	"github.com/antigravity/dbbackup/internal/logger"
+	"github.com/antigravity/dbbackup/internal/notifier"
 	"github.com/antigravity/dbbackup/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Manager struct {
-	DB      database.Database
-	Storage storage.Storage
+	DB       database.Database
+	Storage  storage.Storage
+	Notifier notifier.Notifier
 }
 
-func NewManager(db database.Database, st storage.Storage) *Manager {
+func NewManager(db database.Database, st storage.Storage, notif notifier.Notifier) *Manager {
 	return &Manager{
-		DB:      db,
-		Storage: st,
+		DB:       db,
+		Storage:  st,
+		Notifier: notif,
 	}
 }
 
+// notify sends an event to the notifier, if any. Notification errors are logged
+// and never fail the restore.
+func (m *Manager) notify(run *backup.Run, event notifier.Event) {
+	if m.Notifier == nil {
+		return
+	}
+	event.Operation = "restore"
+	event.RunID = run.ID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	_, end := run.Phase("notify", attribute.String("notify.status", string(event.Status)))
+	err := m.Notifier.Notify(event)
+	end(err)
+	if err != nil {
+		run.Log.Error("Notification failed", "error", err)
+	}
+}
+
+// fail reports a failed restore phase and returns err
+func (m *Manager) fail(run *backup.Run, phase, backupFile string, err error) error {
+	m.notify(run, notifier.Event{
+		Phase:    phase,
+		Status:   notifier.StatusFailure,
+		Error:    err.Error(),
+		Duration: time.Since(run.Start),
+		Artifact: backupFile,
+		Phases:   run.Phases,
+	})
+	run.Log.Error("Restore failed", "phase", phase, "error", err)
+	run.End(err)
+	return err
+}
+
 func (m *Manager) PerformRestore(backupFile string) error {
-	logger.Info.Printf("Starting restore from %s...", backupFile)
+	run := backup.StartRun("restore",
+		attribute.String("db.provider", backup.ProviderName(m.DB)),
+		attribute.String("storage.provider", backup.ProviderName(m.Storage)),
+		attribute.String("backup.artifact", backupFile),
+	)
+	run.Log.Info("Starting restore", "file", backupFile)
+	m.notify(run, notifier.Event{Status: notifier.StatusStarted, Artifact: backupFile})
+	return m.restoreBackup(run, backupFile)
+}
 
-	// 1. Download from Storage
-	localFile := backupFile
-	// If path contains directories, we might want to flatten it or ensure dirs exist.
-	// For now, let's just download to current dir with same name.
-	
-	err := m.Storage.Download(backupFile, localFile)
+// PerformRestoreToPoint rolls the database back in place to a bookmark, or to
+// a time when bookmark is empty. A time beyond the history the provider keeps
+// is restored from the newest backup taken before it.
+func (m *Manager) PerformRestoreToPoint(bookmark string, at time.Time) error {
+	point := bookmark
+	if point == "" {
+		point = at.Format(time.RFC3339)
+	}
+	run := backup.StartRun("restore",
+		attribute.String("db.provider", backup.ProviderName(m.DB)),
+		attribute.String("storage.provider", backup.ProviderName(m.Storage)),
+		attribute.String("restore.point", point),
+	)
+	run.Log.Info("Starting point-in-time restore", "point", point)
+	m.notify(run, notifier.Event{Status: notifier.StatusStarted, Artifact: point})
+
+	restorer, ok := m.DB.(database.PointInTimeRestorer)
+	if !ok {
+		return m.fail(run, "restore", point, fmt.Errorf("%s databases can't be restored to a point in time", backup.ProviderName(m.DB)))
+	}
+
+	_, end := run.Phase("restore", attribute.String("restore.point", point))
+	err := restorer.RestoreToPoint(bookmark, at)
+	end(err)
+	if errors.Is(err, database.ErrPointUnavailable) && bookmark == "" {
+		backupFile, err := m.backupBefore(restorer, at)
+		if err != nil {
+			return m.fail(run, "restore", point, err)
+		}
+		run.Log.Info("Point is beyond the database history, restoring the newest backup before it", "point", point, "file", backupFile)
+		return m.restoreBackup(run, backupFile)
+	}
+	if err != nil {
+		return m.fail(run, "restore", point, fmt.Errorf("point-in-time restore failed: %v", err))
+	}
+
+	duration := time.Since(run.Start)
+	run.Log.Info("Restore completed successfully", "duration", duration)
+	m.notify(run, notifier.Event{
+		Status:   notifier.StatusSuccess,
+		Duration: duration,
+		Artifact: point,
+		Phases:   run.Phases,
+	})
+	run.End(nil)
+	return nil
+}
+
+// backupBefore returns the newest backup of the database taken at or before at
+func (m *Manager) backupBefore(restorer database.PointInTimeRestorer, at time.Time) (string, error) {
+	metas, err := backup.ListMetadata(m.Storage)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup metadata: %v", err)
+	}
+	for _, meta := range metas {
+		if restorer.HasBackup(meta) && !meta.Created.After(at) {
+			return meta.Artifact, nil
+		}
+	}
+	return "", fmt.Errorf("%s is beyond the database history and there is no backup from before it", at.Format(time.RFC3339))
+}
+
+// restoreBackup downloads a backup with the ones it builds on and restores it
+func (m *Manager) restoreBackup(run *backup.Run, backupFile string) error {
+	// 1. An incremental backup is restored together with the backups it builds on
+	chain, err := m.chain(run, backupFile)
 	if err != nil {
-		return fmt.Errorf("download from storage failed: %v", err)
+		return m.fail(run, "download", backupFile, err)
 	}
-	defer os.Remove(localFile)
 
-	// 2. Decompress if needed
-	restoreFile := localFile
-	if strings.HasSuffix(localFile, ".gz") {
-		decompressedFile, err := backup.DecompressFile(localFile)
+	// 2. Download and decompress each backup
+	var files []string
+	var size int64
+	for _, artifact := range chain {
+		file, artifactSize, cleanup, phase, err := m.fetch(run, artifact)
 		if err != nil {
-			return fmt.Errorf("decompression failed: %v", err)
+			return m.fail(run, phase, backupFile, err)
 		}
-		restoreFile = decompressedFile
-		defer os.Remove(restoreFile)
-		logger.Info.Printf("Decompressed to: %s", restoreFile)
+		defer cleanup()
+		files = append(files, file)
+		size += artifactSize
 	}
 
 	// 3. Restore to DB
-	if err := m.DB.Restore(restoreFile); err != nil {
-		return fmt.Errorf("database restore failed: %v", err)
+	_, end := run.Phase("restore", attribute.Int("restore.chain_length", len(files)))
+	if len(files) > 1 {
+		err = m.DB.(database.ChainRestorer).RestoreChain(files)
+	} else {
+		err = m.DB.Restore(files[0])
+	}
+	end(err)
+	if err != nil {
+		return m.fail(run, "restore", backupFile, fmt.Errorf("database restore failed: %v", err))
 	}
 
-	logger.Info.Println("Restore completed successfully")
+	duration := time.Since(run.Start)
+	run.Log.Info("Restore completed successfully", "duration", duration)
+	m.notify(run, notifier.Event{
+		Status:   notifier.StatusSuccess,
+		Duration: duration,
+		Artifact: backupFile,
+		Size:     size,
+		Phases:   run.Phases,
+	})
+	run.End(nil)
 	return nil
 }
+
+// chain returns the artifacts to restore, from the full backup to backupFile.
+// Only providers that restore chains look at the metadata; a backup without
+// metadata stands on its own.
+func (m *Manager) chain(run *backup.Run, backupFile string) ([]string, error) {
+	if _, ok := m.DB.(database.ChainRestorer); !ok {
+		return []string{backupFile}, nil
+	}
+
+	chain := []string{backupFile}
+	artifact := backupFile
+	for {
+		meta, err := backup.ReadMetadata(m.Storage, artifact)
+		if err != nil {
+			if artifact != backupFile {
+				return nil, fmt.Errorf("failed to read metadata of %s: %v", artifact, err)
+			}
+			run.Log.Debug("No backup metadata, restoring the backup on its own", "file", backupFile, "error", err)
+			return chain, nil
+		}
+		if meta.Base == "" {
+			break
+		}
+		for _, seen := range chain {
+			if seen == meta.Base {
+				return nil, fmt.Errorf("backup chain of %s loops at %s", backupFile, meta.Base)
+			}
+		}
+		chain = append([]string{meta.Base}, chain...)
+		artifact = meta.Base
+	}
+
+	if len(chain) > 1 {
+		run.Log.Info("Restoring incremental backup chain", "backups", chain)
+	}
+	return chain, nil
+}
+
+// fetch downloads an artifact and decompresses it if needed. It returns the
+// local file to restore, the downloaded size and a function removing the local
+// files; on failure it returns the phase that failed.
+func (m *Manager) fetch(run *backup.Run, artifact string) (string, int64, func(), string, error) {
+	// If path contains directories, we might want to flatten it or ensure dirs exist.
+	// For now, let's just download to current dir with same name.
+	localFile := artifact
+	span, end := run.Phase("download", attribute.String("backup.artifact", artifact))
+	err := m.Storage.Download(artifact, localFile)
+	end(err)
+	if errors.Is(err, storage.ErrRehydrationPending) {
+		return "", 0, nil, "download", fmt.Errorf("%v; re-run the restore once the backup is available", err)
+	}
+	if err != nil {
+		return "", 0, nil, "download", fmt.Errorf("download of %s from storage failed: %v", artifact, err)
+	}
+
+	var size int64
+	if info, err := os.Stat(localFile); err == nil {
+		size = info.Size()
+	}
+	span.SetAttributes(attribute.Int64("backup.size", size))
+
+	if !strings.HasSuffix(localFile, ".gz") {
+		return localFile, size, func() { os.Remove(localFile) }, "", nil
+	}
+
+	_, end = run.Phase("decompress", attribute.String("backup.artifact", artifact))
+	decompressedFile, err := backup.DecompressFile(localFile)
+	end(err)
+	if err != nil {
+		os.Remove(localFile)
+		return "", 0, nil, "decompress", fmt.Errorf("decompression failed: %v", err)
+	}
+	run.Log.Info("Backup decompressed", "file", decompressedFile)
+	return decompressedFile, size, func() {
+		os.Remove(localFile)
+		os.Remove(decompressedFile)
+	}, "", nil
+}
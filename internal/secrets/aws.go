@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// awsProvider reads secrets from AWS Secrets Manager. References are a secret
+// name or ARN, optionally followed by #field for JSON secrets.
+type awsProvider struct {
+	client *secretsmanager.Client
+}
+
+func newAWSProvider(cfg config.AWSSecretsConfig) (*awsProvider, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	if cfg.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.Profile))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := secretsmanager.NewFromConfig(awsCfg, func(o *secretsmanager.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+	return &awsProvider{client: client}, nil
+}
+
+func (a *awsProvider) Lookup(ctx context.Context, ref string) (string, error) {
+	id, field := splitField(ref)
+
+	out, err := a.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var secret string
+	switch {
+	case out.SecretString != nil:
+		secret = *out.SecretString
+	case out.SecretBinary != nil:
+		secret = string(out.SecretBinary)
+	default:
+		return "", fmt.Errorf("secret %s has no value", id)
+	}
+	return pickField(secret, field)
+}
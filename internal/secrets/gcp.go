@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"golang.org/x/oauth2/google"
+)
+
+const defaultGCPSecretsEndpoint = "https://secretmanager.googleapis.com"
+
+// gcpProvider reads secrets from Google Secret Manager through its REST API.
+// References are projects/<p>/secrets/<s>[/versions/<v>], optionally
+// followed by #field for JSON secrets. The version defaults to latest.
+type gcpProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newGCPProvider(cfg config.GCPSecretsConfig) (*gcpProvider, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCPSecretsEndpoint
+	}
+
+	client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		// Emulators at a custom endpoint don't need credentials
+		if cfg.Endpoint == "" {
+			return nil, err
+		}
+		client = http.DefaultClient
+	}
+
+	return &gcpProvider{endpoint: strings.TrimSuffix(endpoint, "/"), client: client}, nil
+}
+
+func (g *gcpProvider) Lookup(ctx context.Context, ref string) (string, error) {
+	name, field := splitField(ref)
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("secret manager returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse secret manager response: %v", err)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret payload: %v", err)
+	}
+	return pickField(string(secret), field)
+}
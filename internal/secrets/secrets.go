@@ -0,0 +1,242 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+)
+
+// referencePattern matches ${provider:reference} anywhere in a config value
+var referencePattern = regexp.MustCompile(`\$\{(env|file|vault|aws-sm|gcp-sm|azure-kv):([^}]+)\}`)
+
+// Provider looks up a single secret by the reference that follows its prefix
+type Provider interface {
+	Lookup(ctx context.Context, ref string) (string, error)
+}
+
+// Resolver replaces secret references in config values with the secrets they
+// point to. Each reference is looked up once per process.
+type Resolver struct {
+	cfg       config.SecretsConfig
+	mu        sync.Mutex
+	cache     map[string]string
+	providers map[string]Provider
+}
+
+func NewResolver(cfg config.SecretsConfig) *Resolver {
+	return &Resolver{
+		cfg:       cfg,
+		cache:     map[string]string{},
+		providers: map[string]Provider{},
+	}
+}
+
+// ResolveConfig resolves the references in every string of cfg in place. The
+// secrets section is resolved first, with env and file references only, since
+// it configures the other providers.
+func ResolveConfig(cfg *config.Config) error {
+	bootstrap := NewResolver(config.SecretsConfig{})
+	if err := bootstrap.resolveValue(reflect.ValueOf(&cfg.Secrets).Elem(), "secrets"); err != nil {
+		return err
+	}
+
+	secretsCfg := cfg.Secrets
+	if err := NewResolver(secretsCfg).resolveValue(reflect.ValueOf(cfg).Elem(), ""); err != nil {
+		return err
+	}
+	cfg.Secrets = secretsCfg
+	return nil
+}
+
+// Resolve returns value with its secret references replaced. A value that is
+// entirely a file:// URL is replaced by the contents of that file.
+func (r *Resolver) Resolve(value string) (string, error) {
+	if strings.HasPrefix(value, "file://") {
+		return r.lookup("file", strings.TrimPrefix(value, "file://"))
+	}
+
+	var err error
+	resolved := referencePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if err != nil {
+			return match
+		}
+		parts := referencePattern.FindStringSubmatch(match)
+		var secret string
+		secret, err = r.lookup(parts[1], parts[2])
+		return secret
+	})
+	return resolved, err
+}
+
+func (r *Resolver) lookup(scheme, ref string) (string, error) {
+	key := scheme + ":" + ref
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.cache[key]; ok {
+		return v, nil
+	}
+
+	provider, err := r.provider(scheme)
+	if err != nil {
+		return "", err
+	}
+	v, err := provider.Lookup(context.Background(), ref)
+	if err != nil {
+		// The reference itself is not secret, only its value
+		return "", fmt.Errorf("failed to resolve secret %s: %v", key, err)
+	}
+
+	logger.AddSecret(v)
+	r.cache[key] = v
+	return v, nil
+}
+
+func (r *Resolver) provider(scheme string) (Provider, error) {
+	if p, ok := r.providers[scheme]; ok {
+		return p, nil
+	}
+
+	var p Provider
+	var err error
+	switch scheme {
+	case "env":
+		p = envProvider{}
+	case "file":
+		p = fileProvider{}
+	case "vault":
+		p, err = newVaultProvider(r.cfg.Vault)
+	case "aws-sm":
+		p, err = newAWSProvider(r.cfg.AWS)
+	case "gcp-sm":
+		p, err = newGCPProvider(r.cfg.GCP)
+	case "azure-kv":
+		p, err = newAzureProvider(r.cfg.Azure)
+	default:
+		return nil, fmt.Errorf("unsupported secret provider: %s", scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s secret provider: %v", scheme, err)
+	}
+	r.providers[scheme] = p
+	return p, nil
+}
+
+// resolveValue walks structs, maps and slices and resolves every string it finds
+func (r *Resolver) resolveValue(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		resolved, err := r.Resolve(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		v.SetString(resolved)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			if err := r.resolveValue(v.Field(i), joinPath(path, fieldName(t.Field(i)))); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.resolveValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, k := range v.MapKeys() {
+			resolved, err := r.Resolve(v.MapIndex(k).String())
+			if err != nil {
+				return fmt.Errorf("%s.%v: %v", path, k, err)
+			}
+			v.SetMapIndex(k, reflect.ValueOf(resolved))
+		}
+	}
+	return nil
+}
+
+func fieldName(f reflect.StructField) string {
+	if tag := f.Tag.Get("mapstructure"); tag != "" {
+		return tag
+	}
+	return f.Name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// splitField splits "reference#field" into the reference and the JSON field
+// to pick from the secret, if any
+func splitField(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// pickField returns one field of a JSON object secret, or the secret itself
+// when no field is requested
+func pickField(secret, field string) (string, error) {
+	if field == "" {
+		return secret, nil
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, can't select %q", field)
+	}
+	return stringField(values, field)
+}
+
+func stringField(values map[string]any, field string) (string, error) {
+	v, ok := values[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+type envProvider struct{}
+
+func (envProvider) Lookup(_ context.Context, name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return v, nil
+}
+
+type fileProvider struct{}
+
+// Lookup reads a secret file, e.g. a Docker or Kubernetes secret. A single
+// trailing newline is dropped.
+func (fileProvider) Lookup(_ context.Context, path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(string(content), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
@@ -0,0 +1,466 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+)
+
+// countingProvider serves fixed secrets and counts its lookups
+type countingProvider struct {
+	values  map[string]string
+	lookups int
+}
+
+func (p *countingProvider) Lookup(_ context.Context, ref string) (string, error) {
+	p.lookups++
+	v, ok := p.values[ref]
+	if !ok {
+		return "", fmt.Errorf("not found")
+	}
+	return v, nil
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("DBBACKUP_TEST_PASSWORD", "from-env")
+	secretFile := filepath.Join(t.TempDir(), "password")
+	os.WriteFile(secretFile, []byte("from-file\r\n"), 0600)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"plain value", "postgres", "postgres", ""},
+		{"env", "${env:DBBACKUP_TEST_PASSWORD}", "from-env", ""},
+		{"file", "${file:" + secretFile + "}", "from-file", ""},
+		{"file url", "file://" + secretFile, "from-file", ""},
+		{"embedded", "postgres://app:${env:DBBACKUP_TEST_PASSWORD}@db/app", "postgres://app:from-env@db/app", ""},
+		{"several", "${env:DBBACKUP_TEST_PASSWORD}/${env:DBBACKUP_TEST_PASSWORD}", "from-env/from-env", ""},
+		{"unknown provider is left alone", "${keyring:db}", "${keyring:db}", ""},
+		{"missing env", "${env:DBBACKUP_TEST_UNSET}", "", "failed to resolve secret env:DBBACKUP_TEST_UNSET: environment variable DBBACKUP_TEST_UNSET is not set"},
+		{"missing file", "${file:/nonexistent/secret}", "", "failed to resolve secret file:/nonexistent/secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewResolver(config.SecretsConfig{}).Resolve(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Resolve(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Resolve(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveLooksUpEachReferenceOnce(t *testing.T) {
+	provider := &countingProvider{values: map[string]string{"secret/db#password": "pw"}}
+	r := NewResolver(config.SecretsConfig{})
+	r.providers["vault"] = provider
+
+	for i := 0; i < 3; i++ {
+		if got, err := r.Resolve("${vault:secret/db#password}"); err != nil || got != "pw" {
+			t.Fatalf("Resolve = %q, %v", got, err)
+		}
+	}
+	if provider.lookups != 1 {
+		t.Fatalf("%d lookups, want 1", provider.lookups)
+	}
+}
+
+func TestPickField(t *testing.T) {
+	tests := []struct {
+		secret  string
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "", "plain", false},
+		{`{"user":"app","password":"pw"}`, "", `{"user":"app","password":"pw"}`, false},
+		{`{"user":"app","password":"pw"}`, "password", "pw", false},
+		{`{"port":5432}`, "port", "5432", false},
+		{`{"enabled":true}`, "enabled", "true", false},
+		{`{"user":"app"}`, "password", "", true},
+		{"plain", "password", "", true},
+		{`["a","b"]`, "0", "", true},
+	}
+	for _, tt := range tests {
+		got, err := pickField(tt.secret, tt.field)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("pickField(%q, %q) = %q, %v", tt.secret, tt.field, got, err)
+		}
+	}
+}
+
+func TestSplitField(t *testing.T) {
+	tests := []struct {
+		ref, path, field string
+	}{
+		{"secret/data/db#password", "secret/data/db", "password"},
+		{"secret/data/db", "secret/data/db", ""},
+		{"arn:aws:secretsmanager:eu-west-1:1:secret:db#a#b", "arn:aws:secretsmanager:eu-west-1:1:secret:db#a", "b"},
+	}
+	for _, tt := range tests {
+		if path, field := splitField(tt.ref); path != tt.path || field != tt.field {
+			t.Errorf("splitField(%q) = %q, %q", tt.ref, path, field)
+		}
+	}
+}
+
+func TestResolveConfig(t *testing.T) {
+	vault := newFakeVault(t)
+	t.Setenv("DBBACKUP_TEST_VAULT_ADDR", vault.URL)
+	t.Setenv("DBBACKUP_TEST_S3_KEY", "AKIDTEST")
+
+	cfg := config.Config{
+		Secrets: config.SecretsConfig{Vault: config.VaultConfig{
+			Address: "${env:DBBACKUP_TEST_VAULT_ADDR}",
+			Token:   "root",
+		}},
+		Database: config.DatabaseConfig{Password: "${vault:secret/data/db#password}"},
+		Storage:  config.StorageConfig{S3: config.S3Config{AccessKeyID: "${env:DBBACKUP_TEST_S3_KEY}"}},
+		Notify: config.NotifyConfig{Channels: []config.NotifierConfig{
+			{Type: "webhook", Secret: "${vault:secret/data/db#user}"},
+		}},
+	}
+	if err := ResolveConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Secrets.Vault.Address != vault.URL {
+		t.Errorf("secrets.vault.address = %q", cfg.Secrets.Vault.Address)
+	}
+	if cfg.Database.Password != "v2-pw" {
+		t.Errorf("database.password = %q", cfg.Database.Password)
+	}
+	if cfg.Storage.S3.AccessKeyID != "AKIDTEST" {
+		t.Errorf("storage.s3.access_key_id = %q", cfg.Storage.S3.AccessKeyID)
+	}
+	if cfg.Notify.Channels[0].Secret != "app" {
+		t.Errorf("notify.channels[0].secret = %q", cfg.Notify.Channels[0].Secret)
+	}
+}
+
+func TestResolveConfigNamesTheFailingField(t *testing.T) {
+	cfg := config.Config{Database: config.DatabaseConfig{Password: "${env:DBBACKUP_TEST_UNSET}"}}
+	err := ResolveConfig(&cfg)
+	if err == nil || !strings.HasPrefix(err.Error(), "database.password: ") {
+		t.Fatalf("ResolveConfig = %v", err)
+	}
+}
+
+// newFakeVault serves a KV v2 secret at secret/data/db and a KV v1 secret at
+// kv/db, for the token "root"
+func newFakeVault(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/db":
+			fmt.Fprint(w, `{"data":{"data":{"user":"app","password":"v2-pw"},"metadata":{"version":3}}}`)
+		case "/v1/kv/db":
+			fmt.Fprint(w, `{"data":{"password":"v1-pw"}}`)
+		case "/v1/ns/kv/db":
+			if r.Header.Get("X-Vault-Namespace") != "team" {
+				http.Error(w, `{"errors":["no namespace"]}`, http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"password":"ns-pw"}}`)
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultProvider(t *testing.T) {
+	vault := newFakeVault(t)
+
+	tests := []struct {
+		ref       string
+		namespace string
+		want      string
+		wantErr   string
+	}{
+		{"secret/data/db#password", "", "v2-pw", ""},
+		{"/secret/data/db#user", "", "app", ""},
+		{"kv/db", "", "v1-pw", ""},
+		{"kv/db#password", "", "v1-pw", ""},
+		{"ns/kv/db", "team", "ns-pw", ""},
+		{"secret/data/db", "", "", "has 2 fields, select one with #field"},
+		{"secret/data/db#token", "", "", `secret has no field "token"`},
+		{"secret/data/missing#password", "", "", "vault returned status 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			p, err := newVaultProvider(config.VaultConfig{Address: vault.URL + "/", Token: "root", Namespace: tt.namespace})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Lookup(context.Background(), tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Lookup error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Lookup = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultProviderFromEnvironment(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := newVaultProvider(config.VaultConfig{}); err == nil {
+		t.Fatal("vault provider without an address")
+	}
+
+	vault := newFakeVault(t)
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	p, err := newVaultProvider(config.VaultConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Lookup(context.Background(), "kv/db"); err != nil || got != "v1-pw" {
+		t.Fatalf("Lookup = %q, %v", got, err)
+	}
+}
+
+// TestVaultDevServer runs against a real Vault, e.g. `vault server -dev
+// -dev-root-token-id=root`, when DBBACKUP_TEST_VAULT_ADDR is set.
+func TestVaultDevServer(t *testing.T) {
+	addr := os.Getenv("DBBACKUP_TEST_VAULT_ADDR")
+	if addr == "" {
+		t.Skip("DBBACKUP_TEST_VAULT_ADDR not set")
+	}
+	token := os.Getenv("DBBACKUP_TEST_VAULT_TOKEN")
+	if token == "" {
+		token = "root"
+	}
+
+	// The dev server mounts KV v2 at secret/
+	req, _ := http.NewRequest(http.MethodPost, strings.TrimSuffix(addr, "/")+"/v1/secret/data/dbbackup-test",
+		strings.NewReader(`{"data":{"password":"dev-pw"}}`))
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("writing the test secret: status %d", resp.StatusCode)
+	}
+
+	r := NewResolver(config.SecretsConfig{Vault: config.VaultConfig{Address: addr, Token: token}})
+	if got, err := r.Resolve("${vault:secret/data/dbbackup-test#password}"); err != nil || got != "dev-pw" {
+		t.Fatalf("Resolve = %q, %v", got, err)
+	}
+}
+
+// newFakeSecretsManager is a LocalStack-style stand-in for the AWS Secrets
+// Manager GetSecretValue call
+func newFakeSecretsManager(t *testing.T, secrets map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			http.Error(w, "unsupported", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIDTEST/") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		var in struct {
+			SecretId string
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &in)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		value, ok := secrets[in.SecretId]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Name": in.SecretId, "SecretString": value})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAWSProvider(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	srv := newFakeSecretsManager(t, map[string]string{
+		"prod/db":  `{"username":"app","password":"aws-pw"}`,
+		"prod/raw": "raw-pw",
+	})
+	p, err := newAWSProvider(config.AWSSecretsConfig{Region: "eu-west-1", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{"prod/db#password", "aws-pw", ""},
+		{"prod/raw", "raw-pw", ""},
+		{"prod/raw#password", "", "not a JSON object"},
+		{"prod/missing", "", "ResourceNotFoundException"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := p.Lookup(context.Background(), tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Lookup error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Lookup = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCPProvider(t *testing.T) {
+	// No credentials, so the provider talks to the emulator unauthenticated
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/secrets/db/versions/latest:access" && r.URL.Path != "/v1/projects/p/secrets/db/versions/2:access" {
+			http.Error(w, `{"error":{"code":404}}`, http.StatusNotFound)
+			return
+		}
+		data := base64.StdEncoding.EncodeToString([]byte(`{"password":"gcp-pw"}`))
+		fmt.Fprintf(w, `{"name":"projects/p/secrets/db/versions/2","payload":{"data":%q}}`, data)
+	}))
+	defer srv.Close()
+
+	p, err := newGCPProvider(config.GCPSecretsConfig{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"projects/p/secrets/db#password", "projects/p/secrets/db/versions/2#password"} {
+		if got, err := p.Lookup(context.Background(), ref); err != nil || got != "gcp-pw" {
+			t.Errorf("Lookup(%q) = %q, %v", ref, got, err)
+		}
+	}
+	if _, err := p.Lookup(context.Background(), "projects/p/secrets/other"); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("Lookup of a missing secret = %v", err)
+	}
+}
+
+func TestParseAzureRef(t *testing.T) {
+	tests := []struct {
+		ref                     string
+		vaultURL, name, version string
+		wantErr                 bool
+	}{
+		{"myvault/db-password", "https://myvault.vault.azure.net", "db-password", "", false},
+		{"myvault/db-password/abc123", "https://myvault.vault.azure.net", "db-password", "abc123", false},
+		{"myvault/secrets/db-password", "https://myvault.vault.azure.net", "db-password", "", false},
+		{"https://localhost:8443/secrets/db-password", "https://localhost:8443", "db-password", "", false},
+		{"myvault", "", "", "", true},
+		{"myvault/a/b/c", "", "", "", true},
+	}
+	for _, tt := range tests {
+		vaultURL, name, version, err := parseAzureRef(tt.ref)
+		if (err != nil) != tt.wantErr || vaultURL != tt.vaultURL || name != tt.name || version != tt.version {
+			t.Errorf("parseAzureRef(%q) = %q, %q, %q, %v", tt.ref, vaultURL, name, version, err)
+		}
+	}
+}
+
+// fakeAzureCredential hands out a fixed token and records the scopes asked for
+type fakeAzureCredential struct {
+	scopes []string
+}
+
+func (c *fakeAzureCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.scopes = append(c.scopes, opts.Scopes...)
+	return azcore.AccessToken{Token: "az-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestAzureProvider(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Key Vault clients first ask without a token and authenticate
+		// against the challenge
+		if r.Header.Get("Authorization") != "Bearer az-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// An empty version asks for the latest one as /secrets/<name>/
+		switch r.URL.Path {
+		case "/secrets/db/", "/secrets/db/v2":
+			fmt.Fprintf(w, `{"value":"az-pw","id":"https://%s/secrets/db/v2"}`, r.Host)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"SecretNotFound","message":"A secret with (name/id) other was not found in this key vault."}}`)
+		}
+	}))
+	defer srv.Close()
+
+	cred := &fakeAzureCredential{}
+	p := &azureProvider{cred: cred, transport: srv.Client(), clients: map[string]*azsecrets.Client{}}
+	for _, ref := range []string{srv.URL + "/db", srv.URL + "/secrets/db/v2"} {
+		if got, err := p.Lookup(context.Background(), ref); err != nil || got != "az-pw" {
+			t.Errorf("Lookup(%q) = %q, %v", ref, got, err)
+		}
+	}
+	if len(cred.scopes) == 0 || cred.scopes[0] != "https://vault.azure.net/.default" {
+		t.Errorf("token scopes %v", cred.scopes)
+	}
+	if _, err := p.Lookup(context.Background(), srv.URL+"/other"); err == nil || !strings.Contains(err.Error(), "SecretNotFound") {
+		t.Errorf("Lookup of a missing secret = %v", err)
+	}
+	if len(p.clients) != 1 {
+		t.Errorf("%d clients for one vault", len(p.clients))
+	}
+}
+
+func TestVaultProviderRedactsToken(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "s.env-vault-token")
+	if _, err := newVaultProvider(config.VaultConfig{Address: "http://vault:8200"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := logger.Redact("X-Vault-Token: s.env-vault-token"); strings.Contains(got, "s.env-vault-token") {
+		t.Fatalf("token not redacted: %s", got)
+	}
+}
@@ -0,0 +1,103 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/antigravity/dbbackup/internal/logger"
+)
+
+// vaultProvider reads secrets from HashiCorp Vault KV engines (v1 and v2)
+// through the HTTP API. References look like secret/data/db#password.
+type vaultProvider struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func newVaultProvider(cfg config.VaultConfig) (*vaultProvider, error) {
+	address := cfg.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	if address == "" {
+		return nil, fmt.Errorf("vault address is not set (secrets.vault.address or VAULT_ADDR)")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault token is not set (secrets.vault.token or VAULT_TOKEN)")
+	}
+	logger.AddSecret(token)
+
+	return &vaultProvider{
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (v *vaultProvider) Lookup(ctx context.Context, ref string) (string, error) {
+	path, field := splitField(ref)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse vault response: %v", err)
+	}
+
+	// KV v2 nests the secret under data.data, next to data.metadata
+	data := result.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("secret %s has %d fields, select one with #field", path, len(data))
+		}
+		for k := range data {
+			field = k
+		}
+	}
+	return stringField(data, field)
+}
@@ -6,8 +6,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/antigravity/dbbackup/internal/config"
 )
 
@@ -50,8 +54,77 @@ func (a *AzureStorage) Upload(srcPath string, destPath string) error {
 	}
 	defer file.Close()
 
-	_, err = a.client.UploadFile(context.TODO(), a.Config.Path, destPath, file, nil)
-	return err
+	opts := &azblob.UploadFileOptions{}
+	if a.Config.Azure.EncryptionScope != "" {
+		opts.CPKScopeInfo = &blob.CPKScopeInfo{EncryptionScope: &a.Config.Azure.EncryptionScope}
+	}
+	if a.Config.Azure.AccessTier != "" {
+		tier := blob.AccessTier(a.Config.Azure.AccessTier)
+		opts.AccessTier = &tier
+	}
+	if len(a.Config.Tags) > 0 {
+		opts.Tags = a.Config.Tags
+		opts.Metadata = make(map[string]*string, len(a.Config.Tags))
+		for k, v := range a.Config.Tags {
+			opts.Metadata[k] = to.Ptr(v)
+		}
+	}
+
+	if _, err = a.client.UploadFile(context.TODO(), a.Config.Path, destPath, file, opts); err != nil {
+		return err
+	}
+
+	// Immutability needs version-level WORM support enabled on the container
+	blobClient := a.blobClient(destPath)
+	if until := retainUntil(a.Config.Lock); !until.IsZero() {
+		mode := blob.ImmutabilityPolicySettingUnlocked
+		if isCompliance(a.Config.Lock) {
+			mode = blob.ImmutabilityPolicySettingLocked
+		}
+		if _, err := blobClient.SetImmutabilityPolicy(context.TODO(), until, &blob.SetImmutabilityPolicyOptions{Mode: &mode}); err != nil {
+			return fmt.Errorf("failed to set immutability policy: %v", err)
+		}
+	}
+	if a.Config.Lock.LegalHold {
+		if _, err := blobClient.SetLegalHold(context.TODO(), true, nil); err != nil {
+			return fmt.Errorf("failed to set legal hold: %v", err)
+		}
+	}
+	return nil
+}
+
+func (a *AzureStorage) blobClient(path string) *blob.Client {
+	return a.client.ServiceClient().NewContainerClient(a.Config.Path).NewBlobClient(path)
+}
+
+// ensureRehydrated checks whether a blob is in the Archive tier, and starts
+// rehydrating it to an online tier if it is.
+func (a *AzureStorage) ensureRehydrated(path string) error {
+	blobClient := a.blobClient(path)
+	props, err := blobClient.GetProperties(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	if props.AccessTier == nil || blob.AccessTier(*props.AccessTier) != blob.AccessTierArchive {
+		return nil
+	}
+	if props.ArchiveStatus != nil && strings.HasPrefix(*props.ArchiveStatus, "rehydrate-pending") {
+		return fmt.Errorf("%w: %s (%s)", ErrRehydrationPending, path, *props.ArchiveStatus)
+	}
+
+	tier := blob.AccessTierHot
+	if a.Config.Azure.RehydrateTier != "" {
+		tier = blob.AccessTier(a.Config.Azure.RehydrateTier)
+	}
+	priority := blob.RehydratePriorityStandard
+	if a.Config.Azure.RehydratePriority != "" {
+		priority = blob.RehydratePriority(a.Config.Azure.RehydratePriority)
+	}
+
+	if _, err := blobClient.SetTier(context.TODO(), tier, &blob.SetTierOptions{RehydratePriority: &priority}); err != nil {
+		return fmt.Errorf("failed to rehydrate %s: %v", path, err)
+	}
+	return fmt.Errorf("%w: requested %s priority rehydration of %s to %s", ErrRehydrationPending, priority, path, tier)
 }
 
 func (a *AzureStorage) Download(srcPath string, destPath string) error {
@@ -60,6 +133,10 @@ func (a *AzureStorage) Download(srcPath string, destPath string) error {
 		return err
 	}
 
+	if err := a.ensureRehydrated(srcPath); err != nil {
+		return err
+	}
+
 	file, err := os.Create(destPath)
 	if err != nil {
 		return err
@@ -94,6 +171,10 @@ func (a *AzureStorage) Delete(path string) error {
 }
 
 func (a *AzureStorage) GetReader(path string) (io.ReadCloser, error) {
+	if err := a.ensureRehydrated(path); err != nil {
+		return nil, err
+	}
+
 	// DownloadStream is the method for streaming
 	resp, err := a.client.DownloadStream(context.TODO(), a.Config.Path, path, nil)
 	if err != nil {
@@ -101,3 +182,19 @@ func (a *AzureStorage) GetReader(path string) (io.ReadCloser, error) {
 	}
 	return resp.Body, nil
 }
+
+func (a *AzureStorage) Hold(path string) error {
+	_, err := a.blobClient(path).SetLegalHold(context.TODO(), true, nil)
+	return err
+}
+
+func (a *AzureStorage) IsLocked(path string) (bool, error) {
+	props, err := a.blobClient(path).GetProperties(context.TODO(), nil)
+	if err != nil {
+		return false, err
+	}
+	if props.LegalHold != nil && *props.LegalHold {
+		return true, nil
+	}
+	return props.ImmutabilityPolicyExpiresOn != nil && props.ImmutabilityPolicyExpiresOn.After(time.Now()), nil
+}
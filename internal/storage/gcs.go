@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/antigravity/dbbackup/internal/config"
@@ -39,6 +40,19 @@ func (g *GCSStorage) Upload(srcPath string, destPath string) error {
 	defer file.Close()
 
 	wc := g.client.Bucket(g.Config.Path).Object(destPath).NewWriter(context.TODO())
+	wc.KMSKeyName = g.Config.GCS.KMSKeyName
+	wc.StorageClass = g.Config.GCS.StorageClass
+	if len(g.Config.Tags) > 0 {
+		wc.Metadata = g.Config.Tags
+	}
+	if until := retainUntil(g.Config.Lock); !until.IsZero() {
+		mode := "Unlocked"
+		if isCompliance(g.Config.Lock) {
+			mode = "Locked"
+		}
+		wc.Retention = &storage.ObjectRetention{Mode: mode, RetainUntil: until}
+	}
+	wc.TemporaryHold = g.Config.Lock.LegalHold
 	if _, err = io.Copy(wc, file); err != nil {
 		wc.Close()
 		return err
@@ -91,3 +105,27 @@ func (g *GCSStorage) Delete(path string) error {
 func (g *GCSStorage) GetReader(path string) (io.ReadCloser, error) {
 	return g.client.Bucket(g.Config.Path).Object(path).NewReader(context.TODO())
 }
+
+func (g *GCSStorage) Hold(path string) error {
+	_, err := g.client.Bucket(g.Config.Path).Object(path).Update(context.TODO(), storage.ObjectAttrsToUpdate{
+		TemporaryHold: true,
+	})
+	return err
+}
+
+func (g *GCSStorage) IsLocked(path string) (bool, error) {
+	attrs, err := g.client.Bucket(g.Config.Path).Object(path).Attrs(context.TODO())
+	if err != nil {
+		return false, err
+	}
+	if attrs.TemporaryHold || attrs.EventBasedHold {
+		return true, nil
+	}
+
+	// Bucket retention policies set RetentionExpirationTime, object retention sets Retention
+	now := time.Now()
+	if attrs.RetentionExpirationTime.After(now) {
+		return true, nil
+	}
+	return attrs.Retention != nil && attrs.Retention.RetainUntil.After(now), nil
+}
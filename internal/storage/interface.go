@@ -1,6 +1,14 @@
 package storage
 
-import "io"
+import (
+	"errors"
+	"io"
+)
+
+// ErrRehydrationPending is returned when a backup sits in an archive tier and has
+// to be rehydrated before it can be read. The rehydration has been requested and
+// the restore can be retried once it completes.
+var ErrRehydrationPending = errors.New("backup is in an archive tier, rehydration is pending")
 
 // Storage interface defines the methods that any storage provider must implement
 type Storage interface {
@@ -19,3 +27,13 @@ type Storage interface {
 	// GetReader returns a reader for a file in storage
 	GetReader(path string) (io.ReadCloser, error)
 }
+
+// Locker is implemented by storage providers that support immutable (WORM)
+// backups. Retention is applied on upload from StorageConfig.Lock.
+type Locker interface {
+	// Hold places a legal hold on a file, which blocks deletion until it is lifted
+	Hold(path string) error
+
+	// IsLocked reports whether a file is protected by a retention period or legal hold
+	IsLocked(path string) (bool, error)
+}
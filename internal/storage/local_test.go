@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+func newLocalStorage(t *testing.T, lock config.LockConfig, files ...string) *LocalStorage {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("backup"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Read-only files and markers would fail the TempDir cleanup otherwise
+	t.Cleanup(func() {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			os.Chmod(filepath.Join(dir, entry.Name()), 0600)
+		}
+		os.Chmod(dir, 0700)
+	})
+	return NewLocalStorage(config.StorageConfig{Type: "local", Path: dir, Lock: lock})
+}
+
+func TestLocalHold(t *testing.T) {
+	l := newLocalStorage(t, config.LockConfig{}, "backup.sql.gz")
+
+	if locked, err := l.IsLocked("backup.sql.gz"); err != nil || locked {
+		t.Fatalf("IsLocked before the hold = %v, %v", locked, err)
+	}
+	if err := l.Hold("backup.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	// Holding again must not trip over the read-only marker
+	if err := l.Hold("backup.sql.gz"); err != nil {
+		t.Fatalf("second Hold = %v", err)
+	}
+	if locked, err := l.IsLocked("backup.sql.gz"); err != nil || !locked {
+		t.Fatalf("IsLocked after the hold = %v, %v", locked, err)
+	}
+	if err := l.Delete("backup.sql.gz"); err == nil || !strings.Contains(err.Error(), "locked by retention or legal hold") {
+		t.Fatalf("Delete = %v", err)
+	}
+
+	files, err := l.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "backup.sql.gz" {
+		t.Fatalf("List = %v, want the backup without its marker", files)
+	}
+}
+
+func TestLocalHoldMissingFile(t *testing.T) {
+	l := newLocalStorage(t, config.LockConfig{})
+	if err := l.Hold("missing.sql.gz"); !os.IsNotExist(err) {
+		t.Fatalf("Hold = %v", err)
+	}
+}
+
+func TestLocalHoldUnwritableDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	l := newLocalStorage(t, config.LockConfig{}, "backup.sql.gz")
+	if err := os.Chmod(l.Config.Path, 0555); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a marker the hold would silently not exist
+	if err := l.Hold("backup.sql.gz"); !os.IsPermission(err) {
+		t.Fatalf("Hold = %v, want a permission error", err)
+	}
+	if locked, _ := l.IsLocked("backup.sql.gz"); locked {
+		t.Fatal("backup reported as locked without a marker")
+	}
+}
+
+func TestLocalIsLockedRetention(t *testing.T) {
+	tests := []struct {
+		name          string
+		retentionDays int
+		age           time.Duration
+		want          bool
+	}{
+		{"no retention", 0, time.Hour, false},
+		{"inside the window", 7, 6 * 24 * time.Hour, true},
+		{"past the window", 7, 8 * 24 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLocalStorage(t, config.LockConfig{RetentionDays: tt.retentionDays}, "backup.sql.gz")
+			modTime := time.Now().Add(-tt.age)
+			if err := os.Chtimes(filepath.Join(l.Config.Path, "backup.sql.gz"), modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			locked, err := l.IsLocked("backup.sql.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if locked != tt.want {
+				t.Fatalf("IsLocked = %v, want %v", locked, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalUploadMakesLockedFilesReadOnly(t *testing.T) {
+	l := newLocalStorage(t, config.LockConfig{RetentionDays: 7})
+	src := filepath.Join(t.TempDir(), "backup.sql.gz")
+	os.WriteFile(src, []byte("backup"), 0600)
+
+	if err := l.Upload(src, "backup.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(l.Config.Path, "backup.sql.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0444 {
+		t.Fatalf("uploaded file mode %v, want read-only", info.Mode().Perm())
+	}
+	if err := l.Delete("backup.sql.gz"); err == nil {
+		t.Fatal("deleted a file inside its retention window")
+	}
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// retainUntil returns the end of the retention window for a file uploaded now,
+// or the zero time when no retention is configured.
+func retainUntil(cfg config.LockConfig) time.Time {
+	if cfg.RetentionDays <= 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, 0, cfg.RetentionDays)
+}
+
+// isCompliance reports whether the lock mode is compliance. Anything else is
+// treated as governance, which privileged users can still override.
+func isCompliance(cfg config.LockConfig) bool {
+	return strings.EqualFold(cfg.Mode, "compliance")
+}
@@ -2,14 +2,24 @@ package storage
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	internalConfig "github.com/antigravity/dbbackup/internal/config"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 type S3Storage struct {
@@ -20,17 +30,70 @@ type S3Storage struct {
 func NewS3Storage(cfg internalConfig.StorageConfig) (*S3Storage, error) {
 	// Load AWS config
 	// This will automatically pick up AWS_ACCESS_KEY_ID etc from env if not specified
-	awsCfg, err := config.LoadDefaultConfig(context.TODO(), 
+	opts := []func(*config.LoadOptions) error{
 		config.WithRegion(cfg.Region),
-	)
+	}
+
+	if cfg.S3.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(cfg.S3.Profile))
+	}
+
+	// Static keys take precedence over the default credential chain
+	if cfg.S3.AccessKeyID != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.S3.AccessKeyID, cfg.S3.SecretAccessKey, cfg.S3.SessionToken),
+		))
+	}
+
+	if cfg.S3.CABundle != "" {
+		caBundle, err := os.Open(cfg.S3.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open CA bundle: %v", err)
+		}
+		defer caBundle.Close()
+		opts = append(opts, config.WithCustomCABundle(caBundle))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	client := s3.NewFromConfig(awsCfg)
+	if cfg.S3.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.S3.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if cfg.S3.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.S3.ExternalID)
+			}
+			if cfg.S3.RoleSessionName != "" {
+				o.RoleSessionName = cfg.S3.RoleSessionName
+			}
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.S3.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.S3.Endpoint)
+		}
+		o.UsePathStyle = cfg.S3.UsePathStyle
+	})
 	return &S3Storage{Config: cfg, client: client}, nil
 }
 
+// sseCustomer returns the SSE-C algorithm, key and key MD5, or nils when SSE-C is not configured
+func (s *S3Storage) sseCustomer() (*string, *string, *string, error) {
+	if s.Config.S3.SSECustomerKey == "" {
+		return nil, nil, nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(s.Config.S3.SSECustomerKey)
+	if err != nil || len(key) != 32 {
+		return nil, nil, nil, fmt.Errorf("sse_customer_key must be a base64-encoded 256-bit key")
+	}
+	sum := md5.Sum(key)
+	return aws.String("AES256"), aws.String(s.Config.S3.SSECustomerKey), aws.String(base64.StdEncoding.EncodeToString(sum[:])), nil
+}
+
 func (s *S3Storage) Upload(srcPath string, destPath string) error {
 	file, err := os.Open(srcPath)
 	if err != nil {
@@ -38,51 +101,158 @@ func (s *S3Storage) Upload(srcPath string, destPath string) error {
 	}
 	defer file.Close()
 
-	_, err = s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(s.Config.Path), // Path is used as Bucket name for S3
 		Key:    aws.String(destPath),
 		Body:   file,
-	})
+	}
+
+	if s.Config.S3.ServerSideEncryption != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(s.Config.S3.ServerSideEncryption)
+	}
+	if s.Config.S3.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.Config.S3.KMSKeyID)
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5, err = s.sseCustomer()
+	if err != nil {
+		return err
+	}
+	if s.Config.S3.StorageClass != "" {
+		input.StorageClass = types.StorageClass(s.Config.S3.StorageClass)
+	}
+	if until := retainUntil(s.Config.Lock); !until.IsZero() {
+		input.ObjectLockMode = types.ObjectLockModeGovernance
+		if isCompliance(s.Config.Lock) {
+			input.ObjectLockMode = types.ObjectLockModeCompliance
+		}
+		input.ObjectLockRetainUntilDate = aws.Time(until)
+	}
+	if s.Config.Lock.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+	if len(s.Config.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range s.Config.Tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+
+	_, err = s.client.PutObject(context.TODO(), input)
 	return err
 }
 
+// ensureRestored checks whether an object in GLACIER or DEEP_ARCHIVE has a
+// readable copy, and requests one if it doesn't. GLACIER_IR objects are readable
+// directly.
+func (s *S3Storage) ensureRestored(key string) error {
+	head, err := s.headObject(key)
+	if err != nil {
+		return err
+	}
+	if head.StorageClass != types.StorageClassGlacier && head.StorageClass != types.StorageClassDeepArchive {
+		return nil
+	}
+
+	if head.Restore != nil {
+		if strings.Contains(*head.Restore, `ongoing-request="false"`) {
+			return nil
+		}
+		return fmt.Errorf("%w: restore of %s is still in progress", ErrRehydrationPending, key)
+	}
+
+	days := int32(s.Config.S3.RestoreDays)
+	if days <= 0 {
+		days = 1
+	}
+	tier := types.TierStandard
+	if s.Config.S3.RestoreTier != "" {
+		tier = types.Tier(s.Config.S3.RestoreTier)
+	}
+
+	_, err = s.client.RestoreObject(context.TODO(), &s3.RestoreObjectInput{
+		Bucket: aws.String(s.Config.Path),
+		Key:    aws.String(key),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(days),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: tier},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to request restore of %s: %v", key, err)
+	}
+	return fmt.Errorf("%w: requested %s restore of %s from %s", ErrRehydrationPending, tier, key, head.StorageClass)
+}
+
+func (s *S3Storage) headObject(key string) (*s3.HeadObjectOutput, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Config.Path),
+		Key:    aws.String(key),
+	}
+	var err error
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5, err = s.sseCustomer()
+	if err != nil {
+		return nil, err
+	}
+	return s.client.HeadObject(context.TODO(), input)
+}
+
+func (s *S3Storage) getObject(key string) (*s3.GetObjectOutput, error) {
+	if err := s.ensureRestored(key); err != nil {
+		return nil, err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Config.Path),
+		Key:    aws.String(key),
+	}
+	var err error
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5, err = s.sseCustomer()
+	if err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(context.TODO(), input)
+}
+
 func (s *S3Storage) Download(srcPath string, destPath string) error {
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		return err
 	}
 
-	file, err := os.Create(destPath)
+	resp, err := s.getObject(srcPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer resp.Body.Close()
 
-	resp, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(s.Config.Path),
-		Key:    aws.String(srcPath),
-	})
+	file, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer file.Close()
 
 	_, err = io.Copy(file, resp.Body)
 	return err
 }
 
+// List returns every key under path, following the pages of at most 1000
+// keys that ListObjectsV2 returns
 func (s *S3Storage) List(path string) ([]string, error) {
-	resp, err := s.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.Config.Path),
 		Prefix: aws.String(path),
 	})
-	if err != nil {
-		return nil, err
-	}
 
 	var files []string
-	for _, item := range resp.Contents {
-		files = append(files, *item.Key)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Contents {
+			files = append(files, *item.Key)
+		}
 	}
 	return files, nil
 }
@@ -96,12 +266,29 @@ func (s *S3Storage) Delete(path string) error {
 }
 
 func (s *S3Storage) GetReader(path string) (io.ReadCloser, error) {
-	resp, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(s.Config.Path),
-		Key:    aws.String(path),
-	})
+	resp, err := s.getObject(path)
 	if err != nil {
 		return nil, err
 	}
 	return resp.Body, nil
 }
+
+func (s *S3Storage) Hold(path string) error {
+	_, err := s.client.PutObjectLegalHold(context.TODO(), &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(s.Config.Path),
+		Key:       aws.String(path),
+		LegalHold: &types.ObjectLockLegalHold{Status: types.ObjectLockLegalHoldStatusOn},
+	})
+	return err
+}
+
+func (s *S3Storage) IsLocked(path string) (bool, error) {
+	head, err := s.headObject(path)
+	if err != nil {
+		return false, err
+	}
+	if head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn {
+		return true, nil
+	}
+	return head.ObjectLockRetainUntilDate != nil && head.ObjectLockRetainUntilDate.After(time.Now()), nil
+}
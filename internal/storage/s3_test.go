@@ -0,0 +1,449 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+// fakeS3 is a minimal path-style S3 stand-in, enough for the calls the
+// storage makes. It lists at most pageSize keys per page and keeps the
+// headers each object was uploaded with.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	headers  map[string]http.Header
+	auth     []string
+	pageSize int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, headers: map[string]http.Header{}, pageSize: 1000}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != "bucket" {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodPut && r.URL.Query().Has("legal-hold"):
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := readS3Body(r)
+		if bytes.Contains(body, []byte("<Status>ON</Status>")) {
+			f.headers[key].Set("X-Amz-Object-Lock-Legal-Hold", "ON")
+		}
+	case r.Method == http.MethodPut:
+		body, err := readS3Body(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+		f.headers[key] = r.Header.Clone()
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range f.headers[key] {
+			if strings.HasPrefix(name, "X-Amz-Object-Lock-") {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := r.URL.Query().Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+f.pageSize, len(keys))
+
+	type content struct {
+		Key string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{IsTruncated: end < len(keys)}
+	if result.IsTruncated {
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, content{Key: key})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// readS3Body reads a PUT body, decoding the aws-chunked encoding the SDK uses
+// for trailing checksums
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return io.ReadAll(r.Body)
+	}
+	var out bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return out.Bytes(), nil
+		}
+		if _, err := io.CopyN(&out, br, size); err != nil {
+			return nil, err
+		}
+		br.ReadString('\n')
+	}
+}
+
+func s3Config(endpoint string) config.StorageConfig {
+	return config.StorageConfig{
+		Type:   "s3",
+		Path:   "bucket",
+		Region: "us-east-1",
+		S3: config.S3Config{
+			Endpoint:        endpoint,
+			UsePathStyle:    true,
+			AccessKeyID:     "AKIDTEST",
+			SecretAccessKey: "secret",
+		},
+	}
+}
+
+func TestS3StorageAgainstStandIn(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	st, err := NewS3Storage(s3Config(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "backup.sql")
+	if err := os.WriteFile(src, []byte("CREATE TABLE t (id int);"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Upload(src, "backup_pg_app_20260102_150405.sql"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	files, err := st.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(files) != 1 || files[0] != "backup_pg_app_20260102_150405.sql" {
+		t.Fatalf("List = %v", files)
+	}
+
+	dest := filepath.Join(t.TempDir(), "restored", "backup.sql")
+	if err := st.Download(files[0], dest); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "CREATE TABLE t (id int);" {
+		t.Fatalf("downloaded %q", data)
+	}
+
+	if err := st.Delete(files[0]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if files, _ := st.List(""); len(files) != 0 {
+		t.Fatalf("List after Delete = %v", files)
+	}
+
+	// The static keys sign every request
+	for _, auth := range fake.auth {
+		if !strings.Contains(auth, "Credential=AKIDTEST/") {
+			t.Fatalf("request not signed with the static key: %q", auth)
+		}
+	}
+}
+
+func TestS3StorageListPages(t *testing.T) {
+	fake := newFakeS3()
+	fake.pageSize = 2
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	var want []string
+	for i := range 5 {
+		key := fmt.Sprintf("backup_pg_app_20260102_15040%d.sql", i)
+		fake.objects[key] = []byte("x")
+		want = append(want, key)
+	}
+	fake.objects["clickhouse/app/part.bin"] = []byte("x")
+
+	st, err := NewS3Storage(s3Config(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := st.List("backup_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Fatalf("List = %v, want %v", files, want)
+	}
+	if len(fake.auth) != 3 {
+		t.Fatalf("%d list requests, want 3 pages", len(fake.auth))
+	}
+}
+
+func TestS3StorageCustomCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(newFakeS3())
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := s3Config(srv.URL)
+	if st, err := NewS3Storage(cfg); err != nil {
+		t.Fatal(err)
+	} else if _, err := st.List(""); err == nil {
+		t.Fatal("List succeeded without trusting the server certificate")
+	}
+
+	cfg.S3.CABundle = caFile
+	st, err := NewS3Storage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.List(""); err != nil {
+		t.Fatalf("List with CA bundle: %v", err)
+	}
+}
+
+func TestS3StorageLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		lock     config.LockConfig
+		wantMode string
+		wantHold string
+	}{
+		{"no lock", config.LockConfig{}, "", ""},
+		{"governance by default", config.LockConfig{RetentionDays: 30}, "GOVERNANCE", ""},
+		{"compliance", config.LockConfig{Mode: "Compliance", RetentionDays: 30}, "COMPLIANCE", ""},
+		{"legal hold on upload", config.LockConfig{LegalHold: true}, "", "ON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3()
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			cfg := s3Config(srv.URL)
+			cfg.Lock = tt.lock
+			st, err := NewS3Storage(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := filepath.Join(t.TempDir(), "backup.sql.gz")
+			os.WriteFile(src, []byte("backup"), 0600)
+			if err := st.Upload(src, "backup.sql.gz"); err != nil {
+				t.Fatal(err)
+			}
+
+			headers := fake.headers["backup.sql.gz"]
+			if got := headers.Get("X-Amz-Object-Lock-Mode"); got != tt.wantMode {
+				t.Errorf("lock mode %q, want %q", got, tt.wantMode)
+			}
+			if got := headers.Get("X-Amz-Object-Lock-Legal-Hold"); got != tt.wantHold {
+				t.Errorf("legal hold %q, want %q", got, tt.wantHold)
+			}
+			until := headers.Get("X-Amz-Object-Lock-Retain-Until-Date")
+			if tt.lock.RetentionDays > 0 {
+				retain, err := time.Parse(time.RFC3339, until)
+				if err != nil {
+					t.Fatalf("retain until %q: %v", until, err)
+				}
+				if want := time.Now().AddDate(0, 0, tt.lock.RetentionDays); retain.Sub(want).Abs() > time.Minute {
+					t.Errorf("retain until %v, want about %v", retain, want)
+				}
+			} else if until != "" {
+				t.Errorf("retain until %q without retention", until)
+			}
+
+			locked, err := st.IsLocked("backup.sql.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.wantMode != "" || tt.wantHold != ""; locked != want {
+				t.Fatalf("IsLocked = %v, want %v", locked, want)
+			}
+		})
+	}
+}
+
+func TestS3StorageHold(t *testing.T) {
+	fake := newFakeS3()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	st, err := NewS3Storage(s3Config(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "backup.sql.gz")
+	os.WriteFile(src, []byte("backup"), 0600)
+	if err := st.Upload(src, "backup.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Hold("backup.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.objects["backup.sql.gz"]) != "backup" {
+		t.Fatalf("hold overwrote the object with %q", fake.objects["backup.sql.gz"])
+	}
+	if locked, err := st.IsLocked("backup.sql.gz"); err != nil || !locked {
+		t.Fatalf("IsLocked after the hold = %v, %v", locked, err)
+	}
+	if err := st.Hold("missing.sql.gz"); err == nil {
+		t.Fatal("held a missing object")
+	}
+}
+
+func TestS3StorageUploadHeaders(t *testing.T) {
+	customerKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	keySum := md5.Sum(bytes.Repeat([]byte{7}, 32))
+
+	tests := []struct {
+		name    string
+		s3      config.S3Config
+		tags    map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "kms",
+			s3:   config.S3Config{ServerSideEncryption: "aws:kms", KMSKeyID: "arn:aws:kms:us-east-1:111122223333:key/backup"},
+			want: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "arn:aws:kms:us-east-1:111122223333:key/backup",
+			},
+		},
+		{
+			name: "customer key",
+			s3:   config.S3Config{SSECustomerKey: customerKey},
+			want: map[string]string{
+				"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+				"X-Amz-Server-Side-Encryption-Customer-Key":       customerKey,
+				"X-Amz-Server-Side-Encryption-Customer-Key-Md5":   base64.StdEncoding.EncodeToString(keySum[:]),
+			},
+		},
+		{
+			name: "storage class and tags",
+			s3:   config.S3Config{StorageClass: "STANDARD_IA"},
+			tags: map[string]string{"team": "db", "env": "prod"},
+			want: map[string]string{
+				"X-Amz-Storage-Class": "STANDARD_IA",
+				"X-Amz-Tagging":       "env=prod&team=db",
+			},
+		},
+		{
+			name:    "invalid customer key",
+			s3:      config.S3Config{SSECustomerKey: "c2hvcnQ="},
+			wantErr: "sse_customer_key must be a base64-encoded 256-bit key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3()
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			cfg := s3Config(srv.URL)
+			tt.s3.Endpoint, tt.s3.UsePathStyle = cfg.S3.Endpoint, true
+			tt.s3.AccessKeyID, tt.s3.SecretAccessKey = cfg.S3.AccessKeyID, cfg.S3.SecretAccessKey
+			cfg.S3 = tt.s3
+			cfg.Tags = tt.tags
+			st, err := NewS3Storage(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := filepath.Join(t.TempDir(), "backup.sql.gz")
+			os.WriteFile(src, []byte("backup"), 0600)
+
+			err = st.Upload(src, "backup.sql.gz")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Upload = %v, want %q", err, tt.wantErr)
+				}
+				if _, ok := fake.objects["backup.sql.gz"]; ok {
+					t.Fatal("uploaded with an invalid key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers := fake.headers["backup.sql.gz"]
+			for name, want := range tt.want {
+				if got := headers.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
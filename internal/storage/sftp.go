@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpTempSuffix marks files of uploads in progress, which List leaves out
+const sftpTempSuffix = ".part"
+
+type SFTPStorage struct {
+	Config    config.StorageConfig
+	sshConfig *ssh.ClientConfig
+}
+
+func NewSFTPStorage(cfg config.StorageConfig) (*SFTPStorage, error) {
+	if cfg.SFTP.Host == "" {
+		return nil, fmt.Errorf("sftp host is required")
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.SFTP.PrivateKeyFile != "" {
+		key, err := os.ReadFile(cfg.SFTP.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %v", err)
+		}
+
+		var signer ssh.Signer
+		if cfg.SFTP.PrivateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(cfg.SFTP.PrivateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.SFTP.Password != "" {
+		auth = append(auth, ssh.Password(cfg.SFTP.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp requires a password or a private key")
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !cfg.SFTP.InsecureIgnoreHostKey {
+		knownHostsFile := cfg.SFTP.KnownHostsFile
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts: %v", err)
+		}
+		hostKeyCallback = callback
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		User:            cfg.SFTP.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	return &SFTPStorage{Config: cfg, sshConfig: sshConfig}, nil
+}
+
+// connect opens a fresh SSH connection and SFTP session. The returned
+// function closes both.
+func (s *SFTPStorage) connect() (*sftp.Client, func(), error) {
+	port := s.Config.SFTP.Port
+	if port == 0 {
+		port = 22
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(s.Config.SFTP.Host, strconv.Itoa(port)), s.sshConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh dial failed: %v", err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("sftp session failed: %v", err)
+	}
+
+	return client, func() {
+		client.Close()
+		conn.Close()
+	}, nil
+}
+
+func (s *SFTPStorage) remotePath(p string) string {
+	return path.Join(s.Config.Path, p)
+}
+
+func (s *SFTPStorage) Upload(srcPath string, destPath string) error {
+	client, closeFn, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	targetPath := s.remotePath(destPath)
+	if err := client.MkdirAll(path.Dir(targetPath)); err != nil {
+		return err
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	// Write to a temporary name so a partial upload is never mistaken for a backup
+	tmpPath := targetPath + sftpTempSuffix
+	destFile, err := client.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := destFile.ReadFrom(srcFile); err != nil {
+		destFile.Close()
+		client.Remove(tmpPath)
+		return err
+	}
+	if err := destFile.Close(); err != nil {
+		client.Remove(tmpPath)
+		return err
+	}
+
+	if err := replaceFile(client, tmpPath, targetPath); err != nil {
+		client.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// replaceFile moves tmpPath over targetPath. Without the atomic posix-rename
+// extension, a plain rename fails on an existing target, so the old file is
+// moved aside first and put back if the rename fails.
+func replaceFile(client *sftp.Client, tmpPath, targetPath string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(tmpPath, targetPath)
+	}
+
+	asidePath := ""
+	if _, err := client.Stat(targetPath); err == nil {
+		asidePath = targetPath + ".prev" + sftpTempSuffix
+		if err := client.Rename(targetPath, asidePath); err != nil {
+			return fmt.Errorf("failed to move the existing file aside: %v", err)
+		}
+	}
+	if err := client.Rename(tmpPath, targetPath); err != nil {
+		if asidePath != "" {
+			client.Rename(asidePath, targetPath)
+		}
+		return err
+	}
+	if asidePath != "" {
+		client.Remove(asidePath)
+	}
+	return nil
+}
+
+func (s *SFTPStorage) Download(srcPath string, destPath string) error {
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	client, closeFn, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	srcFile, err := client.Open(s.remotePath(srcPath))
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = srcFile.WriteTo(destFile)
+	return err
+}
+
+func (s *SFTPStorage) List(p string) ([]string, error) {
+	client, closeFn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	entries, err := client.ReadDir(s.remotePath(p))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), sftpTempSuffix) {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, nil
+}
+
+func (s *SFTPStorage) Delete(p string) error {
+	client, closeFn, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	return client.Remove(s.remotePath(p))
+}
+
+func (s *SFTPStorage) GetReader(p string) (io.ReadCloser, error) {
+	client, closeFn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := client.Open(s.remotePath(p))
+	if err != nil {
+		closeFn()
+		return nil, err
+	}
+	return &sftpReader{File: file, closeFn: closeFn}, nil
+}
+
+// sftpReader closes the underlying SSH connection together with the file
+type sftpReader struct {
+	*sftp.File
+	closeFn func()
+}
+
+func (r *sftpReader) Close() error {
+	err := r.File.Close()
+	r.closeFn()
+	return err
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSFTPServer runs an in-process SSH server with the sftp subsystem on a
+// random port. It returns the storage config for it, with a known_hosts file
+// holding its host key.
+func startSFTPServer(t *testing.T) config.StorageConfig {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "backup" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverCfg.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, serverCfg)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort("127.0.0.1", strconv.Itoa(addr.Port)))}, hostKey.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return config.StorageConfig{
+		Type: "sftp",
+		Path: t.TempDir(),
+		SFTP: config.SFTPConfig{
+			Host:           "127.0.0.1",
+			Port:           addr.Port,
+			User:           "backup",
+			Password:       "secret",
+			KnownHostsFile: knownHosts,
+		},
+	}
+}
+
+func serveSFTP(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// The payload is the length-prefixed subsystem name
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						channel.Close()
+						return
+					}
+					server.Serve()
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPStorageAgainstInProcessServer(t *testing.T) {
+	cfg := startSFTPServer(t)
+	st, err := NewSFTPStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "backup.sql")
+	os.WriteFile(src, []byte("first"), 0600)
+	if err := st.Upload(src, "backup_pg_app_20260102_150405.sql"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	// An upload in progress must not show up as a backup
+	os.WriteFile(filepath.Join(cfg.Path, "backup_pg_app_20260103_150405.sql"+sftpTempSuffix), []byte("partial"), 0600)
+
+	files, err := st.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(files) != 1 || files[0] != "backup_pg_app_20260102_150405.sql" {
+		t.Fatalf("List = %v", files)
+	}
+
+	dest := filepath.Join(t.TempDir(), "out.sql")
+	if err := st.Download(files[0], dest); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "first" {
+		t.Fatalf("downloaded %q", data)
+	}
+
+	reader, err := st.GetReader(files[0])
+	if err != nil {
+		t.Fatalf("GetReader: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "first" {
+		t.Fatalf("read %q", data)
+	}
+
+	if err := st.Delete(files[0]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Path, files[0])); !os.IsNotExist(err) {
+		t.Fatalf("file still exists after Delete: %v", err)
+	}
+}
+
+func TestSFTPStorageReplacesWithoutPosixRename(t *testing.T) {
+	if err := sftp.SetSFTPExtensions(); err != nil {
+		t.Fatal(err)
+	}
+	defer sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com", "statvfs@openssh.com")
+
+	cfg := startSFTPServer(t)
+	st, err := NewSFTPStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "backup.sql")
+	for _, content := range []string{"first", "second"} {
+		os.WriteFile(src, []byte(content), 0600)
+		if err := st.Upload(src, "backup.sql"); err != nil {
+			t.Fatalf("Upload %s: %v", content, err)
+		}
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(cfg.Path, "backup.sql")); string(data) != "second" {
+		t.Fatalf("content after replace = %q", data)
+	}
+	entries, _ := os.ReadDir(cfg.Path)
+	if len(entries) != 1 {
+		t.Fatalf("leftover files after replace: %v", entries)
+	}
+}
+
+func TestSFTPStorageRejectsUnknownHostKey(t *testing.T) {
+	cfg := startSFTPServer(t)
+
+	// known_hosts holding another key for the same address
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(pub)
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(cfg.SFTP.Host, strconv.Itoa(cfg.SFTP.Port)))}, otherKey)
+	os.WriteFile(cfg.SFTP.KnownHostsFile, []byte(line+"\n"), 0600)
+
+	st, err := NewSFTPStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.List(""); err == nil {
+		t.Fatal("List succeeded with a mismatching host key")
+	}
+}
@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/antigravity/dbbackup/internal/config"
+)
+
+const defaultWebDAVChunkSize = 10 << 20
+
+// WebDAVStorage stores backups on a WebDAV server (Nextcloud, ownCloud, most NAS
+// appliances). With readOnly set it serves the http storage type, which can only
+// download artifacts from a plain HTTP(S) URL.
+type WebDAVStorage struct {
+	Config   config.StorageConfig
+	baseURL  *url.URL
+	client   *http.Client
+	readOnly bool
+}
+
+func NewWebDAVStorage(cfg config.StorageConfig) (*WebDAVStorage, error) {
+	return newWebDAVStorage(cfg, false)
+}
+
+func NewHTTPStorage(cfg config.StorageConfig) (*WebDAVStorage, error) {
+	return newWebDAVStorage(cfg, true)
+}
+
+func newWebDAVStorage(cfg config.StorageConfig, readOnly bool) (*WebDAVStorage, error) {
+	rawURL := cfg.WebDAV.URL
+	if rawURL == "" {
+		rawURL = cfg.Path
+	}
+
+	baseURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url: %v", err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("webdav url must be http or https, got %q", rawURL)
+	}
+
+	return &WebDAVStorage{
+		Config:   cfg,
+		baseURL:  baseURL,
+		client:   &http.Client{},
+		readOnly: readOnly,
+	}, nil
+}
+
+func (w *WebDAVStorage) resolve(p string) string {
+	u := *w.baseURL
+	u.Path = path.Join("/", u.Path, p)
+	return u.String()
+}
+
+func (w *WebDAVStorage) do(method, target string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return w.send(req)
+}
+
+func (w *WebDAVStorage) send(req *http.Request) (*http.Response, error) {
+	if w.Config.WebDAV.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Config.WebDAV.BearerToken)
+	} else if w.Config.WebDAV.User != "" {
+		req.SetBasicAuth(w.Config.WebDAV.User, w.Config.WebDAV.Password)
+	}
+	return w.client.Do(req)
+}
+
+// expect drains and closes the response body and returns an error unless the
+// status code is one of the accepted ones.
+func expect(resp *http.Response, method string, accepted ...int) error {
+	defer resp.Body.Close()
+	for _, code := range accepted {
+		if resp.StatusCode == code {
+			io.Copy(io.Discard, resp.Body)
+			return nil
+		}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("webdav %s failed with status: %d, body: %s", method, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (w *WebDAVStorage) errReadOnly() error {
+	return fmt.Errorf("http storage is read-only")
+}
+
+// mkcolAll creates every missing collection on the way to dir
+func (w *WebDAVStorage) mkcolAll(dir string) error {
+	current := ""
+	for _, part := range strings.Split(path.Clean("/"+dir), "/") {
+		if part == "" {
+			continue
+		}
+		current = path.Join(current, part)
+
+		resp, err := w.do("MKCOL", w.resolve(current)+"/", nil, nil)
+		if err != nil {
+			return err
+		}
+		// 405 Method Not Allowed means the collection already exists
+		if err := expect(resp, "MKCOL", http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WebDAVStorage) Upload(srcPath string, destPath string) error {
+	if w.readOnly {
+		return w.errReadOnly()
+	}
+
+	if dir := path.Dir(destPath); dir != "." && dir != "/" {
+		if err := w.mkcolAll(dir); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if w.Config.WebDAV.UploadsURL != "" {
+		return w.uploadChunked(file, info.Size(), destPath)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, w.resolve(destPath), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := w.send(req)
+	if err != nil {
+		return err
+	}
+	return expect(resp, "PUT", http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+// uploadChunked implements the Nextcloud chunked upload protocol: the chunks
+// are PUT into a temporary upload collection and assembled with a final MOVE.
+func (w *WebDAVStorage) uploadChunked(file *os.File, size int64, destPath string) error {
+	chunkSize := w.Config.WebDAV.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultWebDAVChunkSize
+	}
+
+	uploadDir := strings.TrimSuffix(w.Config.WebDAV.UploadsURL, "/") + fmt.Sprintf("/dbbackup-%d", time.Now().UnixNano())
+	headers := map[string]string{
+		"Destination":     w.resolve(destPath),
+		"OC-Total-Length": fmt.Sprintf("%d", size),
+	}
+
+	resp, err := w.do("MKCOL", uploadDir, nil, headers)
+	if err != nil {
+		return err
+	}
+	if err := expect(resp, "MKCOL", http.StatusCreated); err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	for i := 1; ; i++ {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			resp, err := w.do(http.MethodPut, fmt.Sprintf("%s/%05d", uploadDir, i), bytes.NewReader(buf[:n]), headers)
+			if err != nil {
+				w.abortUpload(uploadDir)
+				return err
+			}
+			if err := expect(resp, "PUT", http.StatusCreated, http.StatusNoContent); err != nil {
+				w.abortUpload(uploadDir)
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			w.abortUpload(uploadDir)
+			return err
+		}
+	}
+
+	resp, err = w.do("MOVE", uploadDir+"/.file", nil, headers)
+	if err != nil {
+		w.abortUpload(uploadDir)
+		return err
+	}
+	if err := expect(resp, "MOVE", http.StatusCreated, http.StatusNoContent); err != nil {
+		w.abortUpload(uploadDir)
+		return err
+	}
+	return nil
+}
+
+// abortUpload removes a partially written upload collection, best effort
+func (w *WebDAVStorage) abortUpload(uploadDir string) {
+	if resp, err := w.do(http.MethodDelete, uploadDir, nil, nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (w *WebDAVStorage) Download(srcPath string, destPath string) error {
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	rc, err := w.GetReader(srcPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	file, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, rc)
+	return err
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func (w *WebDAVStorage) List(p string) ([]string, error) {
+	if w.readOnly {
+		return nil, fmt.Errorf("http storage does not support listing")
+	}
+
+	propfind := `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+	resp, err := w.do("PROPFIND", w.resolve(p)+"/", strings.NewReader(propfind), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav PROPFIND failed with status: %d", resp.StatusCode)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %v", err)
+	}
+
+	var files []string
+	for _, r := range ms.Responses {
+		isCollection := false
+		for _, ps := range r.Propstat {
+			if ps.Prop.ResourceType.Collection != nil {
+				isCollection = true
+			}
+		}
+		if isCollection {
+			continue
+		}
+
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			href = r.Href
+		}
+		files = append(files, path.Base(href))
+	}
+	return files, nil
+}
+
+func (w *WebDAVStorage) Delete(p string) error {
+	if w.readOnly {
+		return w.errReadOnly()
+	}
+
+	resp, err := w.do(http.MethodDelete, w.resolve(p), nil, nil)
+	if err != nil {
+		return err
+	}
+	return expect(resp, "DELETE", http.StatusOK, http.StatusNoContent)
+}
+
+func (w *WebDAVStorage) GetReader(p string) (io.ReadCloser, error) {
+	resp, err := w.do(http.MethodGet, w.resolve(p), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s failed with status: %d", p, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
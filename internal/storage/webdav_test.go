@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVStorageAgainstStandIn(t *testing.T) {
+	fs := webdav.NewMemFS()
+	if err := fs.Mkdir(context.Background(), "/backups", 0755); err != nil {
+		t.Fatal(err)
+	}
+	dav := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "backup" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	st, err := NewWebDAVStorage(config.StorageConfig{
+		Type:   "webdav",
+		WebDAV: config.WebDAVConfig{URL: srv.URL + "/backups", User: "backup", Password: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(t.TempDir(), "backup.sql")
+	os.WriteFile(src, []byte("dump"), 0600)
+	for _, name := range []string{"backup_pg_app_20260102_150405.sql", "backup_pg_app_20260103_150405.sql"} {
+		if err := st.Upload(src, name); err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+	}
+
+	// Missing collections are created on the way
+	if err := st.Upload(src, "nested/dir/backup.sql"); err != nil {
+		t.Fatalf("Upload nested: %v", err)
+	}
+	if nested, err := st.List("nested/dir"); err != nil || len(nested) != 1 {
+		t.Fatalf("List nested = %v, %v", nested, err)
+	}
+
+	files, err := st.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	sort.Strings(files)
+	if strings.Join(files, ",") != "backup_pg_app_20260102_150405.sql,backup_pg_app_20260103_150405.sql" {
+		t.Fatalf("List = %v", files)
+	}
+
+	dest := filepath.Join(t.TempDir(), "out.sql")
+	if err := st.Download(files[0], dest); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "dump" {
+		t.Fatalf("downloaded %q", data)
+	}
+
+	if err := st.Delete(files[0]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if files, _ := st.List(""); len(files) != 1 {
+		t.Fatalf("List after Delete = %v", files)
+	}
+
+	// The http type reads the same server but never writes
+	ro, err := NewHTTPStorage(config.StorageConfig{
+		Type:   "http",
+		WebDAV: config.WebDAVConfig{URL: srv.URL + "/backups", User: "backup", Password: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ro.Download(files[1], dest); err != nil {
+		t.Fatalf("http Download: %v", err)
+	}
+	if err := ro.Upload(src, "x.sql"); err == nil {
+		t.Fatal("http storage accepted an upload")
+	}
+}
+
+// fakeChunkedUploads records the requests of a Nextcloud chunked upload and
+// assembles the chunks on MOVE, or fails the MOVE with failMove
+type fakeChunkedUploads struct {
+	mu        sync.Mutex
+	requests  []string
+	chunks    map[string]string
+	assembled string
+	failMove  bool
+}
+
+func (f *fakeChunkedUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	switch r.Method {
+	case "MKCOL":
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.chunks[r.URL.Path] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	case "MOVE":
+		if f.failMove {
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		}
+		var names []string
+		for name := range f.chunks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f.assembled += f.chunks[name]
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func chunkedStorage(t *testing.T, fake *fakeChunkedUploads) (*WebDAVStorage, string) {
+	t.Helper()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	st, err := NewWebDAVStorage(config.StorageConfig{
+		Type: "webdav",
+		WebDAV: config.WebDAVConfig{
+			URL:        srv.URL + "/files",
+			UploadsURL: srv.URL + "/uploads",
+			ChunkSize:  4,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "backup.sql")
+	os.WriteFile(src, []byte("0123456789"), 0600)
+	return st, src
+}
+
+func TestWebDAVStorageChunkedUpload(t *testing.T) {
+	fake := &fakeChunkedUploads{chunks: map[string]string{}}
+	st, src := chunkedStorage(t, fake)
+
+	if err := st.Upload(src, "backup.sql"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(fake.chunks) != 3 {
+		t.Fatalf("uploaded %d chunks, want 3", len(fake.chunks))
+	}
+	if fake.assembled != "0123456789" {
+		t.Fatalf("assembled %q", fake.assembled)
+	}
+}
+
+func TestWebDAVStorageChunkedUploadAbortsOnFailedMove(t *testing.T) {
+	fake := &fakeChunkedUploads{chunks: map[string]string{}, failMove: true}
+	st, src := chunkedStorage(t, fake)
+
+	if err := st.Upload(src, "backup.sql"); err == nil {
+		t.Fatal("Upload succeeded although the MOVE failed")
+	}
+	last := fake.requests[len(fake.requests)-1]
+	if !strings.HasPrefix(last, "DELETE /uploads/dbbackup-") {
+		t.Fatalf("upload collection not removed, last request %q", last)
+	}
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/antigravity/dbbackup/internal/config"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.TracingConfig
+		sdk     bool
+		wantErr string
+	}{
+		{"disabled", config.TracingConfig{}, false, ""},
+		{"grpc", config.TracingConfig{Enabled: true, Endpoint: "localhost:4317", Insecure: true}, true, ""},
+		{"http", config.TracingConfig{Enabled: true, Protocol: "http", Endpoint: "localhost:4318", Insecure: true, SampleRatio: 0.5}, true, ""},
+		{"unknown protocol", config.TracingConfig{Enabled: true, Protocol: "zipkin"}, false, "unsupported tracing protocol: zipkin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			global := otel.GetTracerProvider()
+			t.Cleanup(func() { otel.SetTracerProvider(global) })
+
+			shutdown, err := Init(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Init error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Nothing was traced, so shutting down has nothing to send
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown = %v", err)
+			}
+			if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok != tt.sdk {
+				t.Fatalf("SDK provider installed = %v, want %v", ok, tt.sdk)
+			}
+		})
+	}
+}